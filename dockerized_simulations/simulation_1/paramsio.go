@@ -0,0 +1,145 @@
+// Loading of parameter sets from JSON at runtime and dumping of the
+// effective network parameters, so hyperparameters can be changed
+// without recompiling params.go (or rebuilding the docker image).
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emer/emergent/params"
+	"github.com/goki/gi/gi"
+	"github.com/schapirolab/leabra-sleep/hip"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// OpenParamsFiles loads params.Sets from each of the comma-separated JSON
+// files in fnms (as written by params.Sets.SaveJSON) and merges them into
+// ss.Params in order, so later files override earlier ones and all of
+// them override the compiled-in SavedParamsSets.
+func (ss *Sim) OpenParamsFiles(fnms string) error {
+	for _, fnm := range strings.Split(fnms, ",") {
+		fnm = strings.TrimSpace(fnm)
+		if fnm == "" {
+			continue
+		}
+		var ld params.Sets
+		if err := ld.OpenJSON(gi.FileName(fnm)); err != nil {
+			return fmt.Errorf("paramsfile %v: %v", fnm, err)
+		}
+		ss.Params = MergeParamsSets(ss.Params, ld)
+		fmt.Printf("Loaded params from: %v\n", fnm)
+	}
+	return nil
+}
+
+// MergeParamsSets merges src into dst and returns the result.  Sets,
+// sheets and selectors are matched by name: matching params are
+// overridden with the src value, and anything not already in dst is added.
+// dst itself is not modified -- the compiled-in SavedParamsSets shares
+// its storage with ss.Params.
+func MergeParamsSets(dst, src params.Sets) params.Sets {
+	out := CopyParamsSets(dst)
+	for _, sset := range src {
+		dset, err := out.SetByNameTry(sset.Name)
+		if err != nil {
+			out = append(out, sset)
+			continue
+		}
+		if sset.Desc != "" {
+			dset.Desc = sset.Desc
+		}
+		if dset.Sheets == nil {
+			dset.Sheets = params.Sheets{}
+		}
+		for shnm, ssh := range sset.Sheets {
+			dsh, ok := dset.Sheets[shnm]
+			if !ok {
+				dset.Sheets[shnm] = ssh
+				continue
+			}
+			for _, ssel := range *ssh {
+				dsel, err := dsh.SelByNameTry(ssel.Sel)
+				if err != nil {
+					*dsh = append(*dsh, ssel)
+					continue
+				}
+				if dsel.Params == nil {
+					dsel.Params = params.Params{}
+				}
+				for pnm, pv := range ssel.Params {
+					dsel.Params[pnm] = pv
+				}
+			}
+		}
+	}
+	return out
+}
+
+// CopyParamsSets returns a deep copy of ps
+func CopyParamsSets(ps params.Sets) params.Sets {
+	out := make(params.Sets, len(ps))
+	for i, set := range ps {
+		nset := &params.Set{Name: set.Name, Desc: set.Desc, Sheets: params.Sheets{}}
+		for shnm, sh := range set.Sheets {
+			nsh := make(params.Sheet, len(*sh))
+			for j, sel := range *sh {
+				nsel := &params.Sel{Sel: sel.Sel, Desc: sel.Desc, Params: params.Params{}}
+				for pnm, pv := range sel.Params {
+					nsel.Params[pnm] = pv
+				}
+				nsh[j] = nsel
+			}
+			nset.Sheets[shnm] = &nsh
+		}
+		out[i] = nset
+	}
+	return out
+}
+
+// DumpParams writes the effective parameters of every layer and projection
+// to fnm: first as they stand after SetParams (wake), then again with the
+// sleep learning mask of each sleep stage applied.  Only used by the
+// -dumpparams mode, which exits afterward.
+func (ss *Sim) DumpParams(fnm string) error {
+	f, err := os.Create(fnm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "// ParamSet: %v  Tag: %v\n", ss.ParamsName(), ss.Tag)
+	fmt.Fprintf(f, "\n//////////////////////////////////////////////////////////////\n// Wake\n\n")
+	ss.WriteNetParams(f)
+
+	for _, stg := range SlpMaskStages {
+		ps := NewParamScope(stg, ss.Net)
+		ss.SleepParams(stg, stg)
+		fmt.Fprintf(f, "\n//////////////////////////////////////////////////////////////\n// %v\n\n", stg)
+		ss.WriteNetParams(f)
+		ps.Restore()
+	}
+	return nil
+}
+
+// WriteNetParams writes Net.AllParams plus the layer Off state and the CHL
+// params of each projection, which AllParams does not include.
+func (ss *Sim) WriteNetParams(f *os.File) {
+	fmt.Fprint(f, ss.Net.AllParams())
+	fmt.Fprintf(f, "/////////////////////////////////////////////////\nOff / CHL\n")
+	for _, lyi := range ss.Net.Layers {
+		ly := lyi.(leabra.LeabraLayer).AsLeabra()
+		fmt.Fprintf(f, "Layer: %v  Off: %v\n", ly.Nm, ly.IsOff())
+		for _, pj := range ly.RcvPrjns {
+			cp, ok := pj.(*hip.CHLPrjn)
+			if !ok {
+				continue
+			}
+			b, _ := json.Marshal(&cp.CHL)
+			fmt.Fprintf(f, "  Prjn: %v  Learn: %v  CHL: %s\n", cp.Name(), cp.Learn.Learn, b)
+		}
+	}
+}
@@ -11,13 +11,13 @@ import (
 	"encoding/csv"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/goki/ki/bitflag"
@@ -66,6 +66,11 @@ func guirun() {
 // LogPrec is precision for saving float values in logs
 const LogPrec = 4
 
+// NStatTstTrls is the number of test trials, from the start of each test
+// epoch, that the shared / unique and cued / uncued test stats are computed
+// over: the first 105 rows of test_sats.txt, each of the 15 satellites 7 times
+const NStatTstTrls = 105
+
 // Sim encapsulates the entire simulation model, and we define all the
 // functionality as methods on this struct.  This structure keeps all relevant
 // state information organized and available without having to pass everything around
@@ -82,6 +87,15 @@ type Sim struct {
 	TstCycLog    *etable.Table     `view:"no-inline" desc:"testing cycle-level log data"`
 	RunLog       *etable.Table     `view:"no-inline" desc:"summary log of each run"`
 	RunStats     *etable.Table     `view:"no-inline" desc:"aggregate stats on all runs"`
+	RSALog       *etable.Table     `view:"no-inline" desc:"representational similarity summaries of the RSA layers at each milestone"`
+	WtChgLog     *etable.Table     `view:"no-inline" desc:"weight changes of each projection over each wake and sleep phase"`
+	SlpTrlLog    *etable.Table     `view:"no-inline" desc:"record of each sleep learning trial (plus / minus contrast)"`
+	KickLog      *etable.Table     `view:"no-inline" desc:"record of each attractor escape kick during sleep"`
+	DownscaleLog *etable.Table     `view:"no-inline" desc:"synaptic downscaling of each target projection over each sleep block"`
+	ParamLog     *etable.Table     `view:"no-inline" desc:"parameters changed by each sleep block, restored at its end"`
+	SlpTstLog    *etable.Table     `view:"no-inline" desc:"interim test results during sleep: the sleep learning curve"`
+	StrucSlpLog  *etable.Table     `view:"no-inline" desc:"record of each structured sleep trial"`
+	SessLog      *etable.Table     `view:"no-inline" desc:"test results at the end of each session of the protocol"`
 	TstStats     *etable.Table     `view:"no-inline" desc:"testing stats"`
 	Params       params.Sets       `view:"no-inline" desc:"full collection of param sets"`
 	ParamSet     string            `desc:"which set of *additional* parameters to use -- always applies Base and optionaly this next if set"`
@@ -98,11 +112,21 @@ type Sim struct {
 	TestUpdt     leabra.TimeScales `desc:"at what time scale to update the display during testing?  Anything longer than Epoch updates at Epoch in this model"`
 	TestInterval int               `desc:"how often to run through all the test patterns, in terms of training epochs -- can use 0 or -1 for no testing"`
 
+	// StructSleep Implementation vars
+	StrucSlp        StrucSleep        `desc:"structured sleep parameters (-strucsleep)"`
+	StrucSleepUpdt  leabra.TimeScales `desc:"at what time scale to update the display during strucsleep?  Anything longer than Epoch updates at Epoch in this model"`
+	OscillStartCyc  int               `desc:"Structured sleep oscillation start cycle in minus phase -- 1 is default and means starting on the first minus phase cycle"`
+	OscillStopCyc   int               `desc:"Structured sleep oscillation stop cycle in minus phase -- 75 is default and means stopping on the last minus phase cycle"`
+	OscillAmplitude float64           `desc:"Structured sleep oscillation amplitude around midline"`
+	OscillPeriod    float64           `desc:"Structured sleep oscillation period"`
+	OscillMidline   float64           `desc:"Structured sleep oscillation midline - this is the value around which oscillation occurs"`
+
 	// DS: Sleep implementation vars
 	SleepEnv     env.FixedTable    `desc:"Training environment -- contains everything about iterating over sleep trials"`
 	SlpCycLog    *etable.Table     `view:"no-inline" desc:"sleeping cycle-level log data"`
 	SlpCycPlot   *eplot.Plot2D     `view:"-" desc:"the sleeping cycle plot"`
 	MaxSlpCyc    int               `desc:"maximum number of cycle to sleep for a trial"`
+	SlpCycEvery  int               `desc:"if > 0, the SlpCycLog of each sleep block is saved to its own file, every this many cycles (-slpcyclog)"`
 	Sleep        bool              `desc:"Sleep or not"`
 	LrnDrgSlp    bool              `desc:"Learning during sleep?"`
 	SlpPlusThr   float32           `desc:"The threshold for entering a sleep plus phase"`
@@ -113,6 +137,9 @@ type Sim struct {
 	AvgLaySim    float64           `desc:"Average layer similaity between this cycle and last cycle"`
 	SynDep       bool              `desc:"Syn Dep during sleep?"`
 	SlpLearn     bool              `desc:"Learn during sleep?"`
+	SlpDWt       bool              `desc:"apply the sleep learning rule (SlpDWt) at the end of each sleep trial -- if false, sleep trials are detected and logged without learning"`
+	SlpRules     SlpRules          `desc:"sleep learning rule of each projection (-slprule)"`
+	SlpMask      SlpMask           `desc:"sleep-time learning mask and learning rates of each sleep stage (-slpmask)"`
 	PlusPhase    bool              `desc:"Sleep Plusphase on/off"`
 	MinusPhase   bool              `desc:"Sleep Minusphase on/off"`
 	ZError       int               `desc:"Consec Zero error epochs"`
@@ -123,6 +150,7 @@ type Sim struct {
 	SlpWrtOut    bool              `desc:"Write out Sleep Acts? Set to false to reduce disk space consumption"`
 	TstWrtOut    bool              `desc:"Write out Tst Acts? Set to false to reduce disk space consumption"`
 	SlpTstWrtOut bool              `desc:"Write out Sleep Tst Epoch Acts? Set to false to reduce disk space consumption"`
+	SlpResWrtOut bool              `desc:"Write out the pre- and post-sleep test results of each run and sleep condition to slpres.csv, read by -report"`
 
 	// statistics: note use float64 as that is best for etable.Table - DS Note: TrlSSE, TrlAvgSSE, TrlCosDiff don't need Shared and Unique vals... only accumulators do.
 	TestNm     string  `inactive:"+" desc:"what set of patterns are we currently testing"`
@@ -164,28 +192,77 @@ type Sim struct {
 	HiddenType    string `view:"-" inactive:"+" desc:"Feature type that is Hidden on this trial - Shared or Unique"`
 	HiddenFeature string `view:"-" inactive:"+" desc:"Feature that is Hidden on this trial - F1-F5"`
 
-	Win          *gi.Window       `view:"-" desc:"main GUI window"`
-	NetView      *netview.NetView `view:"-" desc:"the network viewer"`
-	ToolBar      *gi.ToolBar      `view:"-" desc:"the master toolbar"`
-	TrnTrlPlot   *eplot.Plot2D    `view:"-" desc:"the training trial plot"`
-	TrnEpcPlot   *eplot.Plot2D    `view:"-" desc:"the training epoch plot"`
-	TstEpcPlot   *eplot.Plot2D    `view:"-" desc:"the testing epoch plot"`
-	TstTrlPlot   *eplot.Plot2D    `view:"-" desc:"the test-trial plot"`
-	TstCycPlot   *eplot.Plot2D    `view:"-" desc:"the test-cycle plot"`
-	RunPlot      *eplot.Plot2D    `view:"-" desc:"the run plot"`
-	TrnEpcFile   *os.File         `view:"-" desc:"log file"`
-	RunFile      *os.File         `view:"-" desc:"log file"`
-	TmpVals      []float32        `view:"-" desc:"temp slice for holding values -- prevent mem allocs"`
-	LayStatNms   []string         `view:"-" desc:"names of layers to collect more detailed stats on (avg act, etc)"`
-	TstNms       []string         `view:"-" desc:"names of test tables"`
-	SaveWts      bool             `view:"-" desc:"for command-line run only, auto-save final weights after each run"`
-	NoGui        bool             `view:"-" desc:"if true, runing in no GUI mode"`
-	LogSetParams bool             `view:"-" desc:"if true, print message for all params that are set"`
-	IsRunning    bool             `view:"-" desc:"true if sim is running"`
-	StopNow      bool             `view:"-" desc:"flag to stop running"`
-	NeedsNewRun  bool             `view:"-" desc:"flag to initialize NewRun if last one finished"`
-	RndSeed      int64            `view:"-" desc:"the current random seed"`
-	DirSeed      int64            `view:"-" desc:"the current random seed for dir"`
+	// run-level results, recorded in the RunLog
+	Lesion     string            `inactive:"+" desc:"lesion condition of the current test -- one of LesionNms"`
+	CritEpc    int               `inactive:"+" desc:"epoch at which the network reached the sleep criterion -- -1 if not reached this run"`
+	LesionRes  map[string]TstRes `view:"-" desc:"results of the last TestAll, by lesion condition"`
+	PreSlpRes  map[string]TstRes `view:"-" desc:"results of the pre-sleep test of this run, by lesion condition -- nil if not tested"`
+	PostSlpRes map[string]TstRes `view:"-" desc:"results of the last test of the protocol under the current sleep condition, by lesion condition -- nil if the run did not sleep"`
+	Protocol   []ProtoStep       `desc:"steps run once the network reaches the sleep criterion (-protocol)"`
+	SlpConds   []SlpCond         `desc:"conditions the protocol is run under, each from the weights at the sleep criterion (-slpconds)"`
+	SlpCond    SlpCond           `inactive:"+" desc:"sleep condition of the protocol in progress -- empty outside the protocol"`
+	CondRes    []CondRes         `view:"-" desc:"outcome of the protocol under each sleep condition of this run"`
+	Sess       int               `inactive:"+" desc:"current session of the protocol: the number of the step in progress, 0 before the protocol"`
+	TotSlpTrls int               `inactive:"+" desc:"number of sleep learning trials over all the sleep steps of the protocol so far"`
+
+	// RSA
+	RSAAt   []string       `desc:"protocol milestones at which to run representational similarity analysis -- any of RSAMilestones (-rsa)"`
+	RSALays []string       `desc:"layers whose settled ActM patterns are compared by RSA (-rsalays)"`
+	RSAEnv  env.FixedTable `view:"-" desc:"environment presenting the RSA items"`
+
+	SnapWtsAt []string `desc:"protocol milestones at which to save a snapshot of the weights -- any of SnapMilestones (-snapwts)"`
+
+	WtChg  WtChgAcct `view:"-" desc:"per-projection weight change accounting of the current wake or sleep phase"`
+	SlpTrl SlpTrl    `view:"-" desc:"the sleep learning trial in progress"`
+
+	Stability  map[string]StabilityMetric `view:"-" desc:"network stability metric (AvgLaySim) of each of the SleepStages (-stability)"`
+	StabActThr float32                    `desc:"layers with less total activity than this count as unstable in the per-layer stability metrics (-stabactthr)"`
+	SlpThr     SlpThresh                  `desc:"plus / minus phase thresholds of sleep learning on AvgLaySim (-slpthr)"`
+	Kick       KickPolicy                 `desc:"attractor escape policy during sleep (-kick)"`
+	Watchdog   Watchdog                   `desc:"NaN / runaway activity watchdog during sleep (-watchdog)"`
+	Downscale  Downscale                  `desc:"synaptic downscaling during sleep (-downscale)"`
+	SlpTest    SlpTestSched               `desc:"interim tests during sleep (-slptest)"`
+	TMR        []TMRCue                   `desc:"targeted memory reactivation cue schedule during sleep (-tmr)"`
+	TMRActive  []string                   `view:"-" desc:"items cued on the current sleep cycle"`
+
+	Win           *gi.Window       `view:"-" desc:"main GUI window"`
+	NetView       *netview.NetView `view:"-" desc:"the network viewer"`
+	ToolBar       *gi.ToolBar      `view:"-" desc:"the master toolbar"`
+	TrnTrlPlot    *eplot.Plot2D    `view:"-" desc:"the training trial plot"`
+	TrnEpcPlot    *eplot.Plot2D    `view:"-" desc:"the training epoch plot"`
+	TstEpcPlot    *eplot.Plot2D    `view:"-" desc:"the testing epoch plot"`
+	TstTrlPlot    *eplot.Plot2D    `view:"-" desc:"the test-trial plot"`
+	TstCycPlot    *eplot.Plot2D    `view:"-" desc:"the test-cycle plot"`
+	RunPlot       *eplot.Plot2D    `view:"-" desc:"the run plot"`
+	TrnEpcFile    *LogFile         `view:"-" desc:"training epoch log file"`
+	TstEpcFile    *LogFile         `view:"-" desc:"testing epoch log file"`
+	RunFile       *LogFile         `view:"-" desc:"run log file"`
+	TrnTrlFile    *LogFile         `view:"-" desc:"training trial log file"`
+	TstTrlFile    *LogFile         `view:"-" desc:"testing trial log file"`
+	RSAFile       *LogFile         `view:"-" desc:"RSA log file"`
+	WtChgFile     *LogFile         `view:"-" desc:"weight change log file"`
+	SlpTrlFile    *LogFile         `view:"-" desc:"sleep learning trial log file"`
+	KickFile      *LogFile         `view:"-" desc:"sleep kick log file"`
+	DownscaleFile *LogFile         `view:"-" desc:"downscaling log file"`
+	ParamFile     *LogFile         `view:"-" desc:"parameter scope log file"`
+	SlpCycFile    *LogFile         `view:"-" desc:"sleep cycle log file of the current sleep block"`
+	SlpTstFile    *LogFile         `view:"-" desc:"interim sleep test log file"`
+	StrucSlpFile  *LogFile         `view:"-" desc:"structured sleep log file"`
+	SessFile      *LogFile         `view:"-" desc:"session log file"`
+	LogDelim      etable.Delims    `view:"-" desc:"column delimiter of the log files (-logfmt)"`
+	TmpVals       []float32        `view:"-" desc:"temp slice for holding values -- prevent mem allocs"`
+	LayStatNms    []string         `view:"-" desc:"names of layers to collect more detailed stats on (avg act, etc)"`
+	TstNms        []string         `view:"-" desc:"names of test tables"`
+	SaveWts       bool             `view:"-" desc:"for command-line run only, auto-save final weights after each run"`
+	NoGui         bool             `view:"-" desc:"if true, runing in no GUI mode"`
+	LogSetParams  bool             `view:"-" desc:"if true, print message for all params that are set"`
+	IsRunning     bool             `view:"-" desc:"true if sim is running"`
+	StopNow       bool             `view:"-" desc:"flag to stop running"`
+	NeedsNewRun   bool             `view:"-" desc:"flag to initialize NewRun if last one finished"`
+	RndSeed       int64            `view:"-" desc:"the current random seed"`
+	DirSeed       int64            `view:"-" desc:"master random seed of the current batch -- the default batch ID"`
+	Manifest      *Manifest        `view:"-" desc:"provenance manifest of the current batch of runs"`
+	Out           OutputLayout     `view:"-" desc:"where output files of the batch are written"`
 }
 
 // this registers this Sim Type and gives it properties that e.g.,
@@ -210,14 +287,35 @@ func (ss *Sim) New() {
 	ss.TstCycLog = &etable.Table{}
 	ss.RunLog = &etable.Table{}
 	ss.RunStats = &etable.Table{}
+	ss.RSALog = &etable.Table{}
+	ss.WtChgLog = &etable.Table{}
+	ss.SlpTrlLog = &etable.Table{}
+	ss.KickLog = &etable.Table{}
+	ss.DownscaleLog = &etable.Table{}
+	ss.ParamLog = &etable.Table{}
+	ss.SlpTstLog = &etable.Table{}
+	ss.StrucSlpLog = &etable.Table{}
+	ss.SessLog = &etable.Table{}
 	ss.Params = SavedParamsSets
 	ss.ViewOn = true
 	ss.TrainUpdt = leabra.AlphaCycle
 	ss.TestUpdt = leabra.AlphaCycle
+	ss.StrucSleepUpdt = leabra.AlphaCycle
+	ss.StrucSlp.Defaults()
 	ss.TestInterval = 1
 	ss.LogSetParams = false
 	ss.LayStatNms = []string{"F1", "F2", "F3", "F4", "F5", "ClassName", "CodeName", "pCA1", "CTX", "DG"}
 	ss.TstNms = []string{"Sat"}
+	ss.RSALays = []string{"CTX", "DG", "CA3", "pCA1", "dCA1"}
+	ss.StabActThr = DefStabActThr
+	ss.SetStability("")
+	ss.SlpThr.Defaults()
+	ss.Kick.Defaults()
+	ss.Watchdog.Defaults()
+	ss.Downscale.Defaults()
+	ss.SlpMask.Set(DefSlpMask)
+	ss.Protocol, _ = ParseProtocol(DefProtocol)
+	ss.SlpConds, _ = ParseSlpConds(DefSlpConds)
 	ss.TrialPerEpc = 50
 	ss.ShTrlNum = 0
 	ss.UnTrlNum = 0
@@ -231,6 +329,7 @@ func (ss *Sim) New() {
 	ss.MaxSlpCyc = 50000
 	ss.SynDep = true
 	ss.SlpLearn = true
+	ss.SlpDWt = true
 	ss.PlusPhase = false
 	ss.MinusPhase = false
 	ss.ExecSleep = true
@@ -240,6 +339,15 @@ func (ss *Sim) New() {
 	ss.SlpWrtOut = false    // true to output sleep cyc acts
 	ss.TstWrtOut = false    // true to output tst trl acts
 	ss.SlpTstWrtOut = false // true to output extra test epoch results from both sides of sleep
+	ss.SlpResWrtOut = true  // true to output slpres.csv, for -report
+
+	ss.OscillStartCyc = 1     // minus start cycle
+	ss.OscillStopCyc = 75     // minus stop cycle
+	ss.OscillAmplitude = 0.05 // amplitude around midline
+	ss.OscillPeriod = 75.     // in cycles
+	ss.OscillMidline = 1.     // horizontal zero value
+	ss.Out.Root = "output"
+	ss.LogDelim = etable.Tab
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
@@ -257,6 +365,15 @@ func (ss *Sim) Config() {
 	ss.ConfigTstTrlLog(ss.TstTrlLog)
 	ss.ConfigTstCycLog(ss.TstCycLog)
 	ss.ConfigRunLog(ss.RunLog)
+	ss.ConfigRSALog(ss.RSALog)
+	ss.ConfigWtChgLog(ss.WtChgLog)
+	ss.ConfigSlpTrlLog(ss.SlpTrlLog)
+	ss.ConfigKickLog(ss.KickLog)
+	ss.ConfigDownscaleLog(ss.DownscaleLog)
+	ss.ConfigParamLog(ss.ParamLog)
+	ss.ConfigSlpTstLog(ss.SlpTstLog)
+	ss.ConfigStrucSlpLog(ss.StrucSlpLog)
+	ss.ConfigSessLog(ss.SessLog)
 
 	ss.ConfigSlpCycLog(ss.SlpCycLog)
 }
@@ -286,7 +403,7 @@ func (ss *Sim) ConfigEnv() {
 
 	ss.SleepEnv.Nm = "SleepEnv"
 	ss.SleepEnv.Dsc = "sleep params and state"
-	ss.SleepEnv.Table = etable.NewIdxView(ss.TrainSat) // this is needed for the configenv to happen correctly even if no pats are ever shown
+	ss.SleepEnv.Table = ss.StrucPats()
 	ss.SleepEnv.Validate()
 
 	ss.TrainEnv.Init(0)
@@ -455,6 +572,10 @@ func (ss *Sim) Counters(state string) string { // changed from boolean to string
 			"%.2f\t\t", ss.TrainEnv.Run.Cur, ss.TrainEnv.Epoch.Cur, ss.TestEnv.Trial.Cur, ss.Time.Cycle,
 			fmt.Sprintf(ss.TestEnv.TrialName.Cur), ss.HiddenType, ss.HiddenFeature, ss.EpcShPctCor,
 			ss.EpcUnPctCor, ss.EpcUnSSE, ss.EpcShSSE)
+	} else if state == "strucsleep" {
+		return fmt.Sprintf("Run:"+" "+"%d\tEpoch:"+" "+"%d\tTrial:"+" "+"%d\tCycle:"+" "+"%d\tName:"+" "+
+			"%s\t InhibFactor: "+" "+"%.4f\t\t\t\t", ss.TrainEnv.Run.Cur, ss.TrainEnv.Epoch.Cur,
+			ss.SleepEnv.Trial.Cur, ss.Time.Cycle, fmt.Sprintf(ss.SleepEnv.TrialName.Cur), ss.InhibFactor)
 	} else if state == "sleep" {
 		return fmt.Sprintf("Run:"+" "+"%d\tEpoch:"+" "+"%d\tCycle:"+" "+"%d\tInhibFactor: "+" "+
 			"%.6f\tAvgLaySim: "+" "+"%.6f\t\t\t\nShared Percent Correct:"+" "+"%.2f\t Unique Percent Correct:"+
@@ -512,6 +633,14 @@ func (ss *Sim) SleepCycInit() {
 
 }
 
+// SleepParams applies the sleep learning mask of stage (SlpMask) to the
+// network, and prints what it changed for sleep block block.  The parameter
+// scope of the block undoes it.
+func (ss *Sim) SleepParams(stage, block string) {
+	sets := ss.SlpMask.Apply(ss.Net, stage)
+	fmt.Printf("Sleep learning mask of %v: %v\n", block, strings.Join(sets, " "))
+}
+
 func (ss *Sim) BackToWake() {
 	// Effwt back to =Wt
 	if ss.SynDep {
@@ -653,59 +782,21 @@ func (ss *Sim) AlphaCyc(train bool) {
 		}
 	}
 
-	if ss.TrainEnv.Run.Cur == 0 {
-		ss.DirSeed = ss.RndSeed
-	}
-
+	var filelrnacts *os.File
 	if !train && ss.TstWrtOut {
-		dirpathacts := "output/" + "tst_acts/" + fmt.Sprint(ss.DirSeed) + "_truns_" +
-			fmt.Sprint(ss.MaxRuns) + "_run_" + fmt.Sprint(ss.TrainEnv.Run.Cur)
-
-		if _, err := os.Stat(filepath.FromSlash(dirpathacts)); os.IsNotExist(err) {
-			os.MkdirAll(filepath.FromSlash(dirpathacts), os.ModePerm)
+		var err error
+		filelrnacts, _, err = ss.OpenOutput(ss.Out.RunFile(ss.TrainEnv.Run.Cur, "tst_acts",
+			"lrnacts_epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur)+".csv"))
+		if err != nil {
+			log.Println(err)
 		}
+	}
 
-		filelrnacts, _ := os.OpenFile(filepath.FromSlash(dirpathacts+"/"+"lrnacts"+fmt.Sprint(ss.RndSeed)+"_"+
-			"run"+fmt.Sprint(ss.TrainEnv.Run.Cur)+"epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur)+".csv"),
-			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-
+	if filelrnacts != nil {
 		defer filelrnacts.Close()
 		writerlrnacts := csv.NewWriter(filelrnacts)
 		defer writerlrnacts.Flush()
 
-		if ss.TrainEnv.Epoch.Cur == 1 {
-
-			// copying params.go to better track params associated with the run data
-			paramsdata, err := ioutil.ReadFile("params.go")
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			err = ioutil.WriteFile(filepath.FromSlash(dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"params.go"),
-				paramsdata, 0644)
-			if err != nil {
-				fmt.Println("Error creating", dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"_"+"params.go")
-				fmt.Println(err)
-				return
-			}
-
-			mainfile, err := ioutil.ReadFile("simulation_1.go")
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			err = ioutil.WriteFile(dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"simulation_1.go",
-				mainfile, 0644)
-			if err != nil {
-				fmt.Println("Error creating", dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"_"+"params.go")
-				fmt.Println(err)
-				return
-			}
-
-		}
-
 		if ss.TestEnv.Trial.Cur == 0 {
 			headers := []string{"Run", "Epoch", "Cycle", "TrialName"}
 
@@ -823,6 +914,7 @@ func (ss *Sim) AlphaCyc(train bool) {
 
 	if train {
 		ss.Net.DWt()
+		ss.WtChg.AccumDWt(ss.Net)
 	}
 	if ss.ViewOn && viewUpdt == leabra.AlphaCycle {
 		ss.UpdateView("train")
@@ -853,6 +945,10 @@ func (ss *Sim) ApplyInputs(en env.Env) {
 // TrainTrial runs one trial of training using TrainEnv
 func (ss *Sim) TrainTrial() {
 
+	if ss.Manifest == nil {
+		ss.StartManifest()
+	}
+
 	if ss.NeedsNewRun {
 		ss.NewRun()
 	}
@@ -872,54 +968,12 @@ func (ss *Sim) TrainTrial() {
 
 			if ss.EpcShPctCor >= 0.66 && ss.EpcUnPctCor >= 0.66 {
 				ss.TestAll(true) // Extra test right before sleep - results written to slp_tst dir
-
-				if ss.ExecSleep && ss.SlpWrtOut {
-					dirpathslp := "output/" + "slp_acts/" + fmt.Sprint(ss.DirSeed) + "/"
-					if _, err := os.Stat(filepath.FromSlash(dirpathslp)); os.IsNotExist(err) {
-						os.MkdirAll(filepath.FromSlash(dirpathslp), os.ModePerm)
-					}
-					fileslpres, _ := os.OpenFile(filepath.FromSlash(dirpathslp+"slpres.csv"),
-						os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-					defer fileslpres.Close()
-					writerslpres := csv.NewWriter(fileslpres)
-					defer writerslpres.Flush()
-					if ss.TrainEnv.Run.Cur == 0 {
-						headers := []string{"Shared", "Unique", "ShSSE", "UnSSE", "SlpTrls"}
-						writerslpres.Write(headers)
-					}
-					results := []string{strconv.FormatFloat(ss.EpcShPctCor, 'f', 6, 64),
-						strconv.FormatFloat(ss.EpcUnPctCor, 'f', 6, 64),
-						strconv.FormatFloat(ss.EpcShSSE, 'f', 6, 64),
-						strconv.FormatFloat(ss.EpcUnSSE, 'f', 6, 64)}
-
-					writerslpres.Write(results)
-					//fmt.Println([]string{strconv.FormatFloat(ss.EpcShPctCor, 'f', 6, 64),
-					//	strconv.FormatFloat(ss.EpcUnPctCor, 'f', 6, 64),
-					//	strconv.FormatFloat(ss.EpcShSSE, 'f', 6, 64),
-					//	strconv.FormatFloat(ss.EpcUnSSE, 'f', 6, 64)})
-
-					ss.SleepTrial()
-					ss.FinalTest = true
-					//fmt.Println(ss.EpcShPctCor, ss.EpcUnPctCor, ss.EpcShSSE, ss.EpcUnSSE)
-					ss.TestAll(true)
-					results = []string{strconv.FormatFloat(ss.EpcShPctCor, 'f', 6, 64),
-						strconv.FormatFloat(ss.EpcUnPctCor, 'f', 6, 64),
-						strconv.FormatFloat(ss.EpcShSSE, 'f', 6, 64),
-						strconv.FormatFloat(ss.EpcUnSSE, 'f', 6, 64), strconv.Itoa(ss.SlpTrls / 10)}
-
-					writerslpres.Write(results)
-					writerslpres.Flush()
-					fileslpres.Close()
-					ss.FinalTest = false
-					//fmt.Println(ss.EpcShPctCor, ss.EpcUnPctCor, ss.EpcShSSE, ss.EpcUnSSE)
-
-				} else if ss.ExecSleep {
-					ss.SleepTrial()
-					ss.FinalTest = true
-					ss.TestAll(true)
-					ss.FinalTest = false
+				ss.CritEpc = ss.TrainEnv.Epoch.Prv
+				ss.PreSlpRes = ss.LesionRes
+				ss.Milestone("PreSleep", "PreSleep")
+				if ss.ExecSleep {
+					ss.RunSlpConds()
 				}
-
 				ss.RunEnd()
 				if ss.TrainEnv.Run.Incr() {
 					ss.StopNow = true
@@ -945,6 +999,12 @@ func (ss *Sim) TrainTrial() {
 		}
 	}
 
+	ss.WakeTrial()
+}
+
+// WakeTrial runs the current wake training trial, with one of its features
+// hidden as the target
+func (ss *Sim) WakeTrial() {
 	// Setting up train trial layer input/target chnages in this block
 	f1 := ss.Net.LayerByName("F1").(leabra.LeabraLayer).AsLeabra()
 	f2 := ss.Net.LayerByName("F2").(leabra.LeabraLayer).AsLeabra()
@@ -1052,7 +1112,7 @@ func (ss *Sim) TrainTrial() {
 	ss.LogTrnTrl(ss.TrnTrlLog)
 }
 
-func (ss *Sim) SleepCyc(c [][]float64) {
+func (ss *Sim) SleepCyc(c [][]float64, cycles int) {
 
 	viewUpdt := ss.SleepUpdt
 
@@ -1078,56 +1138,56 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 	var minuscounts []int
 	var stablecounts []int
 
-	filetrnacts, _ := os.OpenFile("output/"+"slp_acts/"+fmt.Sprint(ss.DirSeed)+"/"+"acts"+
-		fmt.Sprint(ss.RndSeed)+"_"+"run"+fmt.Sprint(ss.TrainEnv.Run.Cur)+"epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur)+
-		".csv", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-
-	defer filetrnacts.Close()
-	writertrnacts := csv.NewWriter(filetrnacts)
-	defer writertrnacts.Flush()
+	// slpwrt is false if the sleep acts file could not be opened
+	slpwrt := ss.SlpWrtOut
+	var writertrnacts *csv.Writer
+	if slpwrt {
+		filetrnacts, _, err := ss.OpenOutput(ss.Out.RunFile(ss.TrainEnv.Run.Cur, "slp_acts",
+			ss.CondLabel("acts_epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur))+".csv"))
+		if err != nil {
+			log.Println(err)
+			slpwrt = false
+		} else {
+			defer filetrnacts.Close()
+			writertrnacts = csv.NewWriter(filetrnacts)
+			defer writertrnacts.Flush()
+		}
+	}
 
 	stablecount := 0
 	pluscount := 0
 	minuscount := 0
 	ss.SlpTrls = 0
+	stage := "Sleep"
+	block := ss.CondLabel(stage) // SlpTrlLog label
+	stab := ss.Stability[stage]
+	stab.Reset()
+	stablys := ss.StabilityLays(stage)
+	ss.SlpThr.Reset(ss.FixedSlpThresh(stage))
+	ss.Watchdog.Reset()
+	ss.SlpTest.Reset()
+	tmrClamps := ss.TMRStart()
+	defer ss.TMREnd(tmrClamps)
+
+	// Snapshot of the wake params (Gi, learning), restored however sleep ends
+	scope := ss.StartParamScope(block)
+	defer ss.EndParamScope(scope)
+	ss.StartSlpCycLog(block)
+	defer ss.EndSlpCycLog()
 
-	// Getting Current Inhibs
-	finhib := ss.Net.LayerByName("F1").(*leabra.Layer).Inhib.Layer.Gi
-	clinhib := ss.Net.LayerByName("ClassName").(*leabra.Layer).Inhib.Layer.Gi
-	coinhib := ss.Net.LayerByName("CodeName").(*leabra.Layer).Inhib.Layer.Gi
-	dginhib := ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi
-	ca3inhib := ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi
-	ctxinhib := ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi
-	pca1inhib := ss.Net.LayerByName("pCA1").(*leabra.Layer).Inhib.Layer.Gi
-	dca1inhib := ss.Net.LayerByName("dCA1").(*leabra.Layer).Inhib.Layer.Gi
-
-	ca3 := ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra()
 	pca1 := ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra()
 	dca1 := ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra()
 
-	perlys := []string{"F1", "F2", "F3", "F4", "F5", "ClassName", "CodeName"}
-	for _, ly := range perlys {
-		lyc := ss.Net.LayerByName(ly).(*leabra.Layer).AsLeabra()
-		lyc.SndPrjns.RecvName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.03
-		lyc.RcvPrjns.SendName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.03
-
-		lyc.SndPrjns.RecvName("DG").(*hip.CHLPrjn).Learn.Learn = false
-		lyc.SndPrjns.RecvName("CA3").(*hip.CHLPrjn).Learn.Learn = false
-		lyc.SndPrjns.RecvName("dCA1").(*hip.CHLPrjn).Learn.Learn = false
-		lyc.RcvPrjns.SendName("pCA1").(*hip.CHLPrjn).Learn.Learn = false
-		lyc.RcvPrjns.SendName("dCA1").(*hip.CHLPrjn).Learn.Learn = false
-
-	}
-	ca3.SndPrjns.RecvName("CA3").(*hip.CHLPrjn).Learn.Learn = false
-	ca3.SndPrjns.RecvName("pCA1").(*hip.CHLPrjn).Learn.Learn = false
+	ss.SleepParams(stage, block)
 
 	dca1.SetOff(false)
 	pca1.SetOff(false)
 
 	ss.Net.GScaleFmAvgAct() // update computed scaling factors
 	ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
+	ss.Downscale.Start(ss.Net)
 
-	for cyc := 0; cyc < 30000; cyc++ {
+	for cyc := 0; cyc < cycles; cyc++ {
 		ss.Net.WtFmDWt()
 
 		ss.Net.Cycle(&ss.Time, true)
@@ -1135,23 +1195,12 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 
 		// Taking the prepared slice of oscil inhib values and producing the oscils in all
 		// perlys := []string{"F1", "F2", "F3", "F4", "F5", "CodeName", "ClassName"}
-		ss.InhibOscil = true
 		if ss.InhibOscil {
 			inhibs := c
 			ss.InhibFactor = inhibs[0][cyc] // For sleep GUI counter and sleepcyclog
 
 			// Changing Inhibs back to default before next oscill cycle value so that the inhib values follow a sinwave
-			perlys := []string{"F1", "F2", "F3", "F4", "F5"}
-			for _, ly := range perlys {
-				ss.Net.LayerByName(ly).(*leabra.Layer).Inhib.Layer.Gi = finhib
-			}
-			ss.Net.LayerByName("ClassName").(*leabra.Layer).Inhib.Layer.Gi = clinhib
-			ss.Net.LayerByName("CodeName").(*leabra.Layer).Inhib.Layer.Gi = coinhib
-			ss.Net.LayerByName("pCA1").(*leabra.Layer).Inhib.Layer.Gi = pca1inhib
-			ss.Net.LayerByName("dCA1").(*leabra.Layer).Inhib.Layer.Gi = dca1inhib
-			ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi = dginhib
-			ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi = ctxinhib
-			ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi = ca3inhib
+			scope.RestoreGi()
 
 			lowlayers := []string{"ClassName", "CTX", "pCA1", "dCA1"}
 			highlayers := []string{"F1", "F2", "F3", "F4", "F5", "DG", "CA3"}
@@ -1164,28 +1213,26 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 				ly := ss.Net.LayerByName(layer).(*leabra.Layer)
 				ly.Inhib.Layer.Gi = ly.Inhib.Layer.Gi * float32(inhibs[1][cyc])
 			}
+		} else {
+			ss.InhibFactor = 1 // Gi stays at its sleep value
 		}
 
-		// Average network similarity
-		avesim := 0.0
-		tmpsim := 0.0
-		for _, lyc := range ss.Net.Layers {
-			ly := ss.Net.LayerByName(lyc.Name()).(*leabra.Layer)
-			tmpsim = ly.Sim
-			if math.IsNaN(tmpsim) {
-				tmpsim = 0
-			}
-			avesim = avesim + tmpsim
-		}
-		ss.AvgLaySim = avesim / 12 // no. of lys
+		ss.TMRCyc(stage, cyc, tmrClamps)
+
+		// Network stability
+		ss.AvgLaySim = stab.Stability(ss.Net, stablys)
+		ss.SlpThr.Update(ss.AvgLaySim)
+		ss.WatchCyc(block, cyc)
+		ss.SleepKick(block, cyc, stablys)
+		ss.DownscaleCyc(cyc)
 
 		// Logging the SlpCycLog
 		ss.LogSlpCyc(ss.SlpCycLog, ss.Time.Cycle)
 
 		// Mark plus or minus phase
 		if ss.SlpLearn {
-			plusthresh := 0.999965
-			minusthresh := plusthresh - 0.0025
+			plusthresh := ss.SlpThr.Plus
+			minusthresh := ss.SlpThr.Minus
 
 			// Checking if stable
 			if ss.PlusPhase == false && ss.MinusPhase == false {
@@ -1203,12 +1250,15 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 				minuscount = 0
 				ss.PlusPhase = true
 				pluscount++
+				ss.SlpTrl.Start(cyc, ss.OnsetInhib(), plusthresh, minusthresh)
+				ss.SlpTrl.AddPlus(ss.AvgLaySim)
 				for _, ly := range ss.Net.Layers {
 					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(true)
 				}
 
 			} else if pluscount > 0 && ss.AvgLaySim >= plusthresh && ss.PlusPhase == true {
 				pluscount++
+				ss.SlpTrl.AddPlus(ss.AvgLaySim)
 				for _, ly := range ss.Net.Layers {
 					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(false)
 				}
@@ -1217,6 +1267,7 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 				ss.PlusPhase = false
 				ss.MinusPhase = true
 				minuscount++
+				ss.SlpTrl.AddMinus(ss.AvgLaySim)
 
 				// Calculate final plusphase act avg for all synapses and store in syn var
 				for _, ly := range ss.Net.Layers {
@@ -1227,6 +1278,7 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 
 			} else if ss.AvgLaySim >= minusthresh && ss.MinusPhase == true {
 				minuscount++
+				ss.SlpTrl.AddMinus(ss.AvgLaySim)
 				for _, ly := range ss.Net.Layers {
 					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(false)
 				}
@@ -1242,19 +1294,16 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 
 				//Dwt here
 				if ss.SlpTrlOcc == false {
-					for _, lyc := range ss.Net.Layers {
-						ss.SlpTrls++
-						ly := ss.Net.LayerByName(lyc.Name()).(*leabra.Layer)
-						for _, p := range ly.SndPrjns {
-							if p.IsOff() {
-								continue
-							}
-							p.(*hip.CHLPrjn).SlpDWt("err")
-						}
+					if ss.SlpDWt {
+						ss.ApplySlpRules()
+						ss.SlpTrl.AbsDWt = ss.WtChg.AccumDWt(ss.Net)
 					}
+					ss.SlpTrls++
 				}
+				ss.LogSlpTrl(ss.SlpTrlLog, block, false)
 
 			} else if ss.AvgLaySim < minusthresh && ss.PlusPhase == true {
+				ss.LogSlpTrl(ss.SlpTrlLog, block, true) // aborted
 				ss.PlusPhase = false
 				pluscount = 0
 				stablecount = 0
@@ -1262,7 +1311,7 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 			}
 		}
 
-		if ss.SlpWrtOut {
+		if slpwrt {
 
 			var f1CycAct []float32
 			var f2CycAct []float32
@@ -1433,10 +1482,13 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 				}
 			}
 		}
+
+		ss.SlpTestCyc(block, cyc, cycles, scope)
 	}
 
 	dca1.SetOff(false)
 	pca1.SetOff(false)
+	ss.DownscaleBlock(block)
 
 	pluscount = 0
 	minuscount = 0
@@ -1444,7 +1496,7 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 	ss.PlusPhase = false
 	stablecount = 0
 
-	if ss.SlpWrtOut {
+	if slpwrt {
 
 		for i := 0; i < len(avglaysims); i++ {
 			valueStr := []string{}
@@ -1499,34 +1551,6 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 		}
 	}
 
-	perlys = []string{"F1", "F2", "F3", "F4", "F5"}
-	for _, ly := range perlys {
-		ss.Net.LayerByName(ly).(*leabra.Layer).Inhib.Layer.Gi = finhib
-	}
-
-	ss.Net.LayerByName("ClassName").(*leabra.Layer).Inhib.Layer.Gi = clinhib
-	ss.Net.LayerByName("CodeName").(*leabra.Layer).Inhib.Layer.Gi = coinhib
-	ss.Net.LayerByName("pCA1").(*leabra.Layer).Inhib.Layer.Gi = pca1inhib
-	ss.Net.LayerByName("dCA1").(*leabra.Layer).Inhib.Layer.Gi = dca1inhib
-	ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi = dginhib
-	ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi = ctxinhib
-	ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi = ca3inhib
-
-	perlys = []string{"F1", "F2", "F3", "F4", "F5", "ClassName", "CodeName"}
-	for _, ly := range perlys {
-		lyc := ss.Net.LayerByName(ly).(*leabra.Layer).AsLeabra()
-		lyc.SndPrjns.RecvName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.0001
-		lyc.RcvPrjns.SendName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.0001
-		lyc.SndPrjns.RecvName("DG").(*hip.CHLPrjn).Learn.Learn = true
-		lyc.SndPrjns.RecvName("CA3").(*hip.CHLPrjn).Learn.Learn = true
-		lyc.SndPrjns.RecvName("dCA1").(*hip.CHLPrjn).Learn.Learn = true
-		lyc.RcvPrjns.SendName("pCA1").(*hip.CHLPrjn).Learn.Learn = true
-		lyc.RcvPrjns.SendName("dCA1").(*hip.CHLPrjn).Learn.Learn = true
-	}
-
-	ca3.SndPrjns.RecvName("CA3").(*hip.CHLPrjn).Learn.Learn = true
-	ca3.SndPrjns.RecvName("pCA1").(*hip.CHLPrjn).Learn.Learn = true
-
 	ss.Net.GScaleFmAvgAct() // update computed scaling factors
 	ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
 
@@ -1535,7 +1559,8 @@ func (ss *Sim) SleepCyc(c [][]float64) {
 	}
 }
 
-func (ss *Sim) SleepTrial() {
+func (ss *Sim) SleepTrial(cycles int) {
+	ss.StartWtChg(ss.CondLabel("Sleep"))
 	ss.SleepCycInit()
 	ss.UpdateView("sleep")
 
@@ -1546,22 +1571,34 @@ func (ss *Sim) SleepTrial() {
 	OscillPeriod := 50.
 	OscillMidline := 1.0
 
-	for i := 0; i < 500000; i++ {
+	for i := 0; i < MaxSleepCycs; i++ {
 		c[0] = append(c[0], LowOscillAmp*math.Sin(2*3.14/OscillPeriod*float64(i))+OscillMidline)  // low
 		c[1] = append(c[1], HighOscillAmp*math.Sin(2*3.14/OscillPeriod*float64(i))+OscillMidline) // high
 	}
 
-	ss.SleepCyc(c)
+	ss.SleepCyc(c, cycles)
 	ss.SlpCycPlot.GoUpdate() // make sure up-to-date at end
 	ss.BackToWake()
+	ss.EndWtChg()
 }
 
 // RunEnd is called at the end of a run -- save weights, record final log, etc here
 func (ss *Sim) RunEnd() {
 	if ss.SaveWts {
-		fnm := ss.WeightsFileName()
+		fnm := ss.Out.RunFile(ss.TrainEnv.Run.Cur, "weights", ss.WeightsFileName())
 		fmt.Printf("Saving Weights to: %v\n", fnm)
-		ss.Net.SaveWtsJSON(gi.FileName(fnm))
+		if err := os.MkdirAll(filepath.Dir(fnm), os.ModePerm); err != nil {
+			log.Println(err)
+		} else if err := ss.Net.SaveWtsJSON(gi.FileName(fnm)); err != nil {
+			log.Println(err)
+		} else {
+			ss.Manifest.AddOutput(fnm)
+		}
+	}
+	ss.EndWtChg()
+	ss.LogRun(ss.RunLog)
+	if ss.Manifest != nil {
+		ss.Manifest.EndRun(ss)
 	}
 }
 
@@ -1579,6 +1616,13 @@ func (ss *Sim) NewRun() {
 	ss.TrnTrlLog.SetNumRows(0)
 	ss.TrnEpcLog.SetNumRows(0)
 	ss.TstEpcLog.SetNumRows(0)
+	ss.SlpTrlLog.SetNumRows(0)
+	ss.KickLog.SetNumRows(0)
+	ss.DownscaleLog.SetNumRows(0)
+	ss.ParamLog.SetNumRows(0)
+	ss.SlpTstLog.SetNumRows(0)
+	ss.StrucSlpLog.SetNumRows(0)
+	ss.SessLog.SetNumRows(0)
 	ss.NeedsNewRun = false
 
 	dg := ss.Net.LayerByName("DG").(*leabra.Layer)
@@ -1599,9 +1643,13 @@ func (ss *Sim) NewRun() {
 	}
 
 	ss.Net.InitWts()
+	ss.StartWtChg("Wake")
 
 	ss.TrainEnv.Trial.Max = ss.TrialPerEpc
 
+	if ss.Manifest != nil {
+		ss.Manifest.BeginRun(ss)
+	}
 }
 
 // InitStats initializes all the statistics, especially important for the
@@ -1634,6 +1682,12 @@ func (ss *Sim) InitStats() {
 	ss.EpcUnPctErr = 0
 	ss.EpcUnCosDiff = 0
 
+	ss.CritEpc = -1
+	ss.PreSlpRes = nil
+	ss.PostSlpRes = nil
+	ss.CondRes = nil
+	ss.Sess = 0
+	ss.TotSlpTrls = 0
 }
 
 // TrialStats computes the trial-level statistics and adds them to the epoch accumulators if
@@ -1652,7 +1706,7 @@ func (ss *Sim) TrialStats(accum bool, outlaynm string) (sse, avgsse, cosdiff flo
 	ss.TrlCosDiff = float64(outLay.CosDiff.Cos)
 	ss.TrlSSE, ss.TrlAvgSSE = outLay.MSE(0.5) // 0.5 = per-unit tolerance -- right side of .5
 	if accum {
-		if ss.HiddenType == "shared" && ss.TestEnv.Trial.Cur >= 0 && ss.TestEnv.Trial.Cur < 105 {
+		if ss.HiddenType == "shared" && ss.TestEnv.Trial.Cur >= 0 && ss.TestEnv.Trial.Cur < NStatTstTrls {
 			ss.ShSumSSE += ss.TrlSSE
 			ss.ShSumAvgSSE += ss.TrlAvgSSE
 			ss.ShSumCosDiff += ss.TrlCosDiff
@@ -1660,7 +1714,7 @@ func (ss *Sim) TrialStats(accum bool, outlaynm string) (sse, avgsse, cosdiff flo
 				ss.ShCntErr++
 			}
 		}
-		if ss.HiddenType == "unique" && ss.TestEnv.Trial.Cur >= 0 && ss.TestEnv.Trial.Cur < 105 {
+		if ss.HiddenType == "unique" && ss.TestEnv.Trial.Cur >= 0 && ss.TestEnv.Trial.Cur < NStatTstTrls {
 			ss.UnSumSSE += ss.TrlSSE
 			ss.UnSumAvgSSE += ss.TrlAvgSSE
 			ss.UnSumCosDiff += ss.TrlCosDiff
@@ -1791,18 +1845,16 @@ func (ss *Sim) TestTrial(returnOnChg bool, slptest bool) {
 	ss.TrialStats(true, outlay) // !accumulate
 
 	if slptest && ss.SlpTstWrtOut {
-		dirtrlstats := "output/" + "slp_tst/" + fmt.Sprint(ss.DirSeed) + "/"
-		if _, err := os.Stat(filepath.FromSlash(dirtrlstats)); os.IsNotExist(err) {
-			os.MkdirAll(filepath.FromSlash(dirtrlstats), os.ModePerm)
+		filetrlstats, isNew, err := ss.OpenOutput(ss.Out.RunFile(ss.TrainEnv.Run.Cur, "slp_tst", "trlststats.csv"))
+		if err != nil {
+			log.Println(err)
+			return
 		}
-
-		filetrlstats, _ := os.OpenFile(filepath.FromSlash(dirtrlstats+"/"+"trlststats"+fmt.Sprint(ss.RndSeed)+".csv"),
-			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		defer filetrlstats.Close()
 		writertrlstats := csv.NewWriter(filetrlstats)
 		defer writertrlstats.Flush()
 
-		if ss.TestEnv.Trial.Cur == 0 && ss.TrainEnv.Epoch.Cur == 1 {
+		if isNew {
 			headers := []string{"Seed", "TrialName", "TrialSSE", "TrialAvgSSE", "TrialCor", "TrialHidType", "TrialHiddenFeature"}
 			writertrlstats.Write(headers)
 		}
@@ -1855,12 +1907,14 @@ func (ss *Sim) TestAll(slptest bool) {
 
 	lesion := 1
 	if slptest {
-		lesion = 5
+		lesion = NSlpTstLesions
 	} else {
 		lesion = 1
 	}
 
+	ss.LesionRes = map[string]TstRes{}
 	for k := 0; k < lesion; k++ {
+		ss.Lesion = LesionNms[k]
 		if k == 1 {
 			ctx.SetOff(true)
 			ss.Net.GScaleFmAvgAct() // update computed scaling factors
@@ -2020,10 +2074,75 @@ func (ss *Sim) TestAll(slptest bool) {
 		ss.Net.GScaleFmAvgAct() // update computed scaling factors
 		ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
 
+		ss.LogTstEpc(ss.TstEpcLog) // one row per lesion condition
+		ss.LesionRes[ss.Lesion] = ss.EpcTstRes()
+	}
+
+	if lesion > 1 { // the Epc stats seen by the caller are those of the intact network
+		ss.SetEpcStats(ss.TstEpcLog, ss.TstEpcLog.Rows-lesion)
 	}
+}
+
+// LesionNms names the lesion conditions of TestAll, by condition index
+var LesionNms = []string{"Intact", "NoCTX", "NoHip", "NoPCA1CTX", "NoDCA1CTX", "NoDCA1PCA1Per", "NoPCA1DCA1Per"}
 
-	ss.LogTstEpc(ss.TstEpcLog)
+// NSlpTstLesions is the number of lesion conditions (from the start of
+// LesionNms) tested by a sleep test, TestAll(true)
+const NSlpTstLesions = 5
+
+// TstRes holds the shared and unique feature results of one test condition
+type TstRes struct {
+	ShPctCor float64
+	UnPctCor float64
+	ShSSE    float64
+	UnSSE    float64
+}
+
+// RunTstCols are the test results recorded in the RunLog, as named in TstEpcLog
+var RunTstCols = []string{"ShPctCor", "UnPctCor", "ShSSE", "UnSSE"}
+
+// Val returns the named result (one of RunTstCols)
+func (tr *TstRes) Val(nm string) float64 {
+	switch nm {
+	case "ShPctCor":
+		return tr.ShPctCor
+	case "UnPctCor":
+		return tr.UnPctCor
+	case "ShSSE":
+		return tr.ShSSE
+	case "UnSSE":
+		return tr.UnSSE
+	}
+	return math.NaN()
+}
+
+// LesionVal returns the named result of the given lesion condition in res,
+// or NaN if that condition was not tested (NaN is skipped by the aggregates)
+func LesionVal(res map[string]TstRes, lesion, nm string) float64 {
+	tr, ok := res[lesion]
+	if !ok {
+		return math.NaN()
+	}
+	return tr.Val(nm)
+}
+
+// EpcTstRes returns the results of the last test epoch
+func (ss *Sim) EpcTstRes() TstRes {
+	return TstRes{ShPctCor: ss.EpcShPctCor, UnPctCor: ss.EpcUnPctCor, ShSSE: ss.EpcShSSE, UnSSE: ss.EpcUnSSE}
+}
 
+// SetEpcStats sets the Epc stats from the given row of the TstEpcLog
+func (ss *Sim) SetEpcStats(dt *etable.Table, row int) {
+	ss.EpcShSSE = dt.CellFloat("ShSSE", row)
+	ss.EpcShAvgSSE = dt.CellFloat("ShAvgSSE", row)
+	ss.EpcShPctErr = dt.CellFloat("ShPctErr", row)
+	ss.EpcShPctCor = dt.CellFloat("ShPctCor", row)
+	ss.EpcShCosDiff = dt.CellFloat("ShCosDiff", row)
+	ss.EpcUnSSE = dt.CellFloat("UnSSE", row)
+	ss.EpcUnAvgSSE = dt.CellFloat("UnAvgSSE", row)
+	ss.EpcUnPctErr = dt.CellFloat("UnPctErr", row)
+	ss.EpcUnPctCor = dt.CellFloat("UnPctCor", row)
+	ss.EpcUnCosDiff = dt.CellFloat("UnCosDiff", row)
 }
 
 // RunTestAll runs through the full set of testing items, has stop running = false at end -- for gui
@@ -2100,6 +2219,9 @@ func (ss *Sim) OpenPat(dt *etable.Table, fname, name, desc string) {
 func (ss *Sim) OpenPats() {
 	ss.OpenPat(ss.TrainSat, "train_sats.txt", "TrainSat", "Training Patterns")
 	ss.OpenPat(ss.TestSat, "test_sats.txt", "TestSat", "Testing Patterns")
+	if ss.TestSat.Rows < NStatTstTrls {
+		log.Printf("test_sats.txt: %d test trials, fewer than the %d of the shared / unique and cued / uncued stats\n", ss.TestSat.Rows, NStatTstTrls)
+	}
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
@@ -2128,7 +2250,7 @@ func (ss *Sim) WeightsFileName() string {
 
 // LogFileName returns default log file name
 func (ss *Sim) LogFileName(lognm string) string {
-	return ss.Net.Nm + "_" + ss.RunName() + "_" + lognm + ".csv"
+	return ss.Net.Nm + "_" + ss.RunName() + "_" + lognm + LogExt(ss.LogDelim)
 }
 
 //////////////////////////////////////////////
@@ -2158,6 +2280,7 @@ func (ss *Sim) LogTrnTrl(dt *etable.Table) {
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TrnTrlPlot.GoUpdate()
+	ss.TrnTrlFile.WriteRow(dt, row)
 }
 
 func (ss *Sim) ConfigTrnTrlLog(dt *etable.Table) {
@@ -2211,11 +2334,15 @@ func (ss *Sim) LogSlpCyc(dt *etable.Table, cyc int) {
 	dt.SetCellFloat("Cycle", cyc, float64(cyc))
 	dt.SetCellFloat("InhibFactor", cyc, float64(ss.InhibFactor))
 	dt.SetCellFloat("AvgLaySim", cyc, float64(ss.AvgLaySim))
+	dt.SetCellFloat("PlusThr", cyc, ss.SlpThr.Plus)
+	dt.SetCellFloat("MinusThr", cyc, ss.SlpThr.Minus)
+	dt.SetCellString("Cue", cyc, strings.Join(ss.TMRActive, " "))
 
 	for _, ly := range ss.Net.Layers {
 		lyc := ss.Net.LayerByName(ly.Name()).(leabra.LeabraLayer).AsLeabra()
 		dt.SetCellFloat(ly.Name()+" Sim", row, float64(lyc.Sim))
 	}
+	ss.WriteSlpCyc(dt, row, cyc)
 
 	ss.SlpCycPlot.GoUpdate()
 
@@ -2237,6 +2364,9 @@ func (ss *Sim) ConfigSlpCycLog(dt *etable.Table) {
 		{"Cycle", etensor.INT64, nil, nil},
 		{"InhibFactor", etensor.FLOAT64, nil, nil},
 		{"AvgLaySim", etensor.FLOAT64, nil, nil},
+		{"PlusThr", etensor.FLOAT64, nil, nil},
+		{"MinusThr", etensor.FLOAT64, nil, nil},
+		{"Cue", etensor.STRING, nil, nil},
 	}
 
 	for _, ly := range ss.Net.Layers {
@@ -2254,6 +2384,8 @@ func (ss *Sim) ConfigSlpCycPlot(plt *eplot.Plot2D, dt *etable.Table) *eplot.Plot
 	// order of params: on, fixMin, min, fixMax, max
 	plt.SetColParams("Cycle", true, true, 0, false, 0)
 	plt.SetColParams("AvgLaySim", true, true, 0, true, 1)
+	plt.SetColParams("PlusThr", true, true, 0, true, 1)
+	plt.SetColParams("MinusThr", true, true, 0, true, 1)
 	return plt
 }
 
@@ -2318,6 +2450,7 @@ func (ss *Sim) LogTrnEpc(dt *etable.Table) {
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TrnEpcPlot.GoUpdate()
+	ss.TrnEpcFile.WriteRow(dt, row)
 
 	if ss.EpcUnSSE == 0 && ss.EpcShSSE == 0 {
 		ss.ZError++
@@ -2395,6 +2528,11 @@ func (ss *Sim) LogTstTrl(dt *etable.Table) {
 	}
 	dt.SetNumRows(row + 1)
 
+	cued := 0.0
+	if ss.TMRCued(ss.TestEnv.TrialName.Cur) {
+		cued = 1
+	}
+
 	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
 	dt.SetCellFloat("Epoch", row, float64(epc))
 	dt.SetCellString("TestNm", row, ss.TestNm)
@@ -2402,6 +2540,7 @@ func (ss *Sim) LogTstTrl(dt *etable.Table) {
 	dt.SetCellString("TrialName", row, ss.TestEnv.TrialName.Cur)
 	dt.SetCellString("HiddenType", row, ss.HiddenType)
 	dt.SetCellString("HiddenFeature", row, ss.HiddenFeature)
+	dt.SetCellFloat("Cued", row, cued)
 	dt.SetCellFloat("SSE", row, ss.TrlSSE)
 	dt.SetCellFloat("AvgSSE", row, ss.TrlAvgSSE)
 	dt.SetCellFloat("CosDiff", row, ss.TrlCosDiff)
@@ -2413,6 +2552,7 @@ func (ss *Sim) LogTstTrl(dt *etable.Table) {
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TstTrlPlot.GoUpdate()
+	ss.TstTrlFile.WriteRow(dt, row)
 }
 
 func (ss *Sim) ConfigTstTrlLog(dt *etable.Table) {
@@ -2431,6 +2571,7 @@ func (ss *Sim) ConfigTstTrlLog(dt *etable.Table) {
 		{"TrialName", etensor.STRING, nil, nil},
 		{"HiddenType", etensor.STRING, nil, nil},
 		{"HiddenFeature", etensor.STRING, nil, nil},
+		{"Cued", etensor.INT64, nil, nil},
 		{"SSE", etensor.FLOAT64, nil, nil},
 		{"AvgSSE", etensor.FLOAT64, nil, nil},
 		{"CosDiff", etensor.FLOAT64, nil, nil},
@@ -2505,6 +2646,7 @@ func (ss *Sim) LogTstEpc(dt *etable.Table) {
 	// data table, instead of incrementing on the Sim
 	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
 	dt.SetCellFloat("Epoch", row, float64(epc))
+	dt.SetCellString("Lesion", row, ss.Lesion)
 	dt.SetCellFloat("Total Trials", row, float64(nt))
 	dt.SetCellFloat("Shared Trials", row, float64(shnt))
 	dt.SetCellFloat("ShSSE", row, ss.EpcShSSE)
@@ -2519,8 +2661,20 @@ func (ss *Sim) LogTstEpc(dt *etable.Table) {
 	dt.SetCellFloat("UnPctCor", row, ss.EpcUnPctCor)
 	dt.SetCellFloat("UnCosDiff", row, ss.EpcUnCosDiff)
 
+	// cued vs. uncued items of the TMR schedule, over the same trials as above
+	tix := etable.NewIdxView(ss.TstTrlLog)
+	tix.Filter(func(et *etable.Table, row int) bool {
+		return et.CellString("HiddenType", row) != "" && et.CellFloat("Trial", row) < NStatTstTrls
+	})
+	cuedCor, uncuedCor, cuedSSE, uncuedSSE := TMRTstStats(tix)
+	dt.SetCellFloat("CuedPctCor", row, cuedCor)
+	dt.SetCellFloat("UncuedPctCor", row, uncuedCor)
+	dt.SetCellFloat("CuedSSE", row, cuedSSE)
+	dt.SetCellFloat("UncuedSSE", row, uncuedSSE)
+
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TstEpcPlot.GoUpdate()
+	ss.TstEpcFile.WriteRow(dt, row)
 }
 
 func (ss *Sim) ConfigTstEpcLog(dt *etable.Table) {
@@ -2532,6 +2686,7 @@ func (ss *Sim) ConfigTstEpcLog(dt *etable.Table) {
 	sch := etable.Schema{
 		{"Run", etensor.INT64, nil, nil},
 		{"Epoch", etensor.INT64, nil, nil},
+		{"Lesion", etensor.STRING, nil, nil},
 		{"Total Trials", etensor.INT64, nil, nil},
 		{"Shared Trials", etensor.INT64, nil, nil},
 		{"ShSSE", etensor.FLOAT64, nil, nil},
@@ -2545,6 +2700,10 @@ func (ss *Sim) ConfigTstEpcLog(dt *etable.Table) {
 		{"UnPctErr", etensor.FLOAT64, nil, nil},
 		{"UnPctCor", etensor.FLOAT64, nil, nil},
 		{"UnCosDiff", etensor.FLOAT64, nil, nil},
+		{"CuedPctCor", etensor.FLOAT64, nil, nil},
+		{"UncuedPctCor", etensor.FLOAT64, nil, nil},
+		{"CuedSSE", etensor.FLOAT64, nil, nil},
+		{"UncuedSSE", etensor.FLOAT64, nil, nil},
 	}
 
 	dt.SetFromSchema(sch, 0)
@@ -2627,50 +2786,76 @@ func (ss *Sim) ConfigTstCycPlot(plt *eplot.Plot2D, dt *etable.Table) *eplot.Plot
 //////////////////////////////////////////////
 //  RunLog
 
-// LogRun adds data from current run to the RunLog table.
+// LogRun adds data from current run to the RunLog table: epochs trained and
+// to the sleep criterion, the pre- and post-sleep test results of the intact
+// network and their difference, the number of sleep learning trials, and the
+// pre- and post-sleep results of each lesion condition -- one row per sleep
+// condition the protocol was run under (Cond is empty if the run did not
+// sleep).  Results of tests that were not run (e.g., no sleep) are NaN.
+// RunStats gets the mean and SEM of each column over all the runs with the
+// same Params and Cond.
 func (ss *Sim) LogRun(dt *etable.Table) {
 	run := ss.TrainEnv.Run.Cur // this is NOT triggered by increment yet -- use Cur
-	row := dt.Rows
-	dt.SetNumRows(row + 1)
-
-	epclog := ss.TstEpcLog
-	epcix := etable.NewIdxView(epclog)
-	// compute mean over last N epochs for run level
-	nlast := 1
-	if nlast > epcix.Len()-1 {
-		nlast = epcix.Len() - 1
-	}
-	epcix.Idxs = epcix.Idxs[epcix.Len()-nlast:]
-
-	params := ss.RunName() // includes tag
-
-	dt.SetCellFloat("Run", row, float64(run))
-	dt.SetCellString("Params", row, params)
-	//dt.SetCellFloat("FirstZero", row, float64(ss.FirstZero)) // DS: Commente out to temporarily get rid of errors
-	dt.SetCellFloat("ShSSE", row, agg.Mean(epcix, "SSE")[0])
-	dt.SetCellFloat("AvgSSE", row, agg.Mean(epcix, "AvgSSE")[0])
-	dt.SetCellFloat("PctErr", row, agg.Mean(epcix, "PctErr")[0])
-	dt.SetCellFloat("PctCor", row, agg.Mean(epcix, "PctCor")[0])
-	dt.SetCellFloat("CosDiff", row, agg.Mean(epcix, "CosDiff")[0])
-	dt.SetCellFloat("SSE", row, agg.Mean(epcix, "SSE")[0])
-	dt.SetCellFloat("AvgSSE", row, agg.Mean(epcix, "AvgSSE")[0])
-	dt.SetCellFloat("PctErr", row, agg.Mean(epcix, "PctErr")[0])
-	dt.SetCellFloat("PctCor", row, agg.Mean(epcix, "PctCor")[0])
-	dt.SetCellFloat("CosDiff", row, agg.Mean(epcix, "CosDiff")[0])
+	params := ss.RunName()     // includes tag
+
+	conds := ss.CondRes
+	if len(conds) == 0 {
+		conds = []CondRes{{}}
+	}
+	for _, cr := range conds {
+		row := dt.Rows
+		dt.SetNumRows(row + 1)
+
+		dt.SetCellFloat("Run", row, float64(run))
+		dt.SetCellString("Params", row, params)
+		dt.SetCellString("Cond", row, cr.Cond)
+		dt.SetCellFloat("Epochs", row, float64(ss.TrainEnv.Epoch.Cur))
+		critEpc := math.NaN()
+		if ss.CritEpc >= 0 {
+			critEpc = float64(ss.CritEpc)
+		}
+		dt.SetCellFloat("CritEpc", row, critEpc)
+		dt.SetCellFloat("SlpTrls", row, float64(cr.SlpTrls))
+		for _, cn := range RunTstCols {
+			pre := LesionVal(ss.PreSlpRes, LesionNms[0], cn)
+			post := LesionVal(cr.PostSlpRes, LesionNms[0], cn)
+			dt.SetCellFloat("Pre "+cn, row, pre)
+			dt.SetCellFloat("Post "+cn, row, post)
+			dt.SetCellFloat("Delta "+cn, row, post-pre)
+		}
+		for _, les := range LesionNms[1:NSlpTstLesions] {
+			for _, cn := range RunTstCols[:2] { // PctCor only
+				dt.SetCellFloat("Pre "+les+" "+cn, row, LesionVal(ss.PreSlpRes, les, cn))
+				dt.SetCellFloat("Post "+les+" "+cn, row, LesionVal(cr.PostSlpRes, les, cn))
+			}
+		}
+		ss.RunFile.WriteRow(dt, row)
+	}
 
 	runix := etable.NewIdxView(dt)
-	spl := split.GroupBy(runix, []string{"Params"})
-	for _, tn := range ss.TstNms {
-		nm := tn + " " + "Mem"
-		split.Desc(spl, nm)
+	spl := split.GroupBy(runix, []string{"Params", "Cond"})
+	for _, cn := range dt.ColNames[3:] { // skip Run, Params, Cond
+		split.Agg(spl, cn, agg.AggMean)
+		split.Agg(spl, cn, agg.AggSem)
 	}
-	split.Desc(spl, "FirstZero")
-	ss.RunStats = spl.AggsToTable(false)
+	ss.RunStats = spl.AggsToTable(etable.AddAggName)
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.RunPlot.GoUpdate()
 }
 
+// SaveRunStats saves the RunStats table alongside the run log, at the end of the batch
+func (ss *Sim) SaveRunStats() {
+	fnm := ss.Out.BatchFile(ss.LogFileName("runstats"))
+	if err := ss.RunStats.SaveCSV(gi.FileName(fnm), ss.LogDelim, etable.Headers); err != nil {
+		log.Println(err)
+		return
+	}
+	ss.Manifest.AddOutput(fnm)
+	ss.Manifest.Write()
+	fmt.Printf("Saved run stats to: %v\n", fnm)
+}
+
 func (ss *Sim) ConfigRunLog(dt *etable.Table) {
 	dt.SetMetaData("name", "RunLog")
 	dt.SetMetaData("desc", "Record of performance at end of training")
@@ -2680,12 +2865,21 @@ func (ss *Sim) ConfigRunLog(dt *etable.Table) {
 	sch := etable.Schema{
 		{"Run", etensor.INT64, nil, nil},
 		{"Params", etensor.STRING, nil, nil},
-		{"FirstZero", etensor.FLOAT64, nil, nil},
-		{"SSE", etensor.FLOAT64, nil, nil},
-		{"AvgSSE", etensor.FLOAT64, nil, nil},
-		{"PctErr", etensor.FLOAT64, nil, nil},
-		{"PctCor", etensor.FLOAT64, nil, nil},
-		{"CosDiff", etensor.FLOAT64, nil, nil},
+		{"Cond", etensor.STRING, nil, nil},
+		{"Epochs", etensor.FLOAT64, nil, nil},
+		{"CritEpc", etensor.FLOAT64, nil, nil},
+		{"SlpTrls", etensor.FLOAT64, nil, nil},
+	}
+	for _, ph := range []string{"Pre ", "Post ", "Delta "} {
+		for _, cn := range RunTstCols {
+			sch = append(sch, etable.Column{ph + cn, etensor.FLOAT64, nil, nil})
+		}
+	}
+	for _, les := range LesionNms[1:NSlpTstLesions] {
+		for _, cn := range RunTstCols[:2] {
+			sch = append(sch, etable.Column{"Pre " + les + " " + cn, etensor.FLOAT64, nil, nil})
+			sch = append(sch, etable.Column{"Post " + les + " " + cn, etensor.FLOAT64, nil, nil})
+		}
 	}
 
 	dt.SetFromSchema(sch, 0)
@@ -2697,12 +2891,21 @@ func (ss *Sim) ConfigRunPlot(plt *eplot.Plot2D, dt *etable.Table) *eplot.Plot2D
 	plt.SetTable(dt)
 	// order of params: on, fixMin, min, fixMax, max
 	plt.SetColParams("Run", false, true, 0, false, 0)
-	plt.SetColParams("FirstZero", false, true, 0, false, 0)
-	plt.SetColParams("SSE", false, true, 0, false, 0)
-	plt.SetColParams("AvgSSE", false, true, 0, false, 0)
-	plt.SetColParams("PctErr", false, true, 0, true, 1)
-	plt.SetColParams("PctCor", false, true, 0, true, 1)
-	plt.SetColParams("CosDiff", false, true, 0, true, 1)
+	plt.SetColParams("Epochs", false, true, 0, false, 0)
+	plt.SetColParams("CritEpc", false, true, 0, false, 0)
+	plt.SetColParams("SlpTrls", false, true, 0, false, 0)
+	for _, ph := range []string{"Pre ", "Post ", "Delta "} {
+		plt.SetColParams(ph+"ShPctCor", ph != "Delta ", false, 0, true, 1)
+		plt.SetColParams(ph+"UnPctCor", ph != "Delta ", false, 0, true, 1)
+		plt.SetColParams(ph+"ShSSE", false, true, 0, false, 0)
+		plt.SetColParams(ph+"UnSSE", false, true, 0, false, 0)
+	}
+	for _, les := range LesionNms[1:NSlpTstLesions] {
+		for _, cn := range RunTstCols[:2] {
+			plt.SetColParams("Pre "+les+" "+cn, false, true, 0, true, 1)
+			plt.SetColParams("Post "+les+" "+cn, false, true, 0, true, 1)
+		}
+	}
 
 	return plt
 }
@@ -2876,6 +3079,29 @@ func (ss *Sim) ConfigGui() *gi.Window {
 		}
 	})
 
+	tbar.AddSeparator("sleep")
+
+	tbar.AddAction(gi.ActOpts{Label: "Step StrucSleep Trial", Icon: "step-fwd", Tooltip: "Advances one structured sleep trial at a time.", UpdateFunc: func(act *gi.Action) {
+		act.SetActiveStateUpdt(!ss.IsRunning)
+	}}, win.This(), func(recv, send ki.Ki, sig int64, data interface{}) {
+		if !ss.IsRunning {
+			ss.IsRunning = true
+			ss.StrucSleepTrial("StrucSleep")
+			ss.IsRunning = false
+			vp.SetNeedsFullRender()
+		}
+	})
+
+	tbar.AddAction(gi.ActOpts{Label: "Step StrucSleep Epoch", Icon: "fast-fwd", Tooltip: "Advances one epoch of structured sleep trials at a time.", UpdateFunc: func(act *gi.Action) {
+		act.SetActiveStateUpdt(!ss.IsRunning)
+	}}, win.This(), func(recv, send ki.Ki, sig int64, data interface{}) {
+		if !ss.IsRunning {
+			ss.IsRunning = true
+			tbar.UpdateActions()
+			go ss.StrucSleepEpoch()
+		}
+	})
+
 	tbar.AddSeparator("log")
 
 	tbar.AddAction(gi.ActOpts{Label: "Reset RunLog", Icon: "reset", Tooltip: "Reset the accumulated log of all Runs, which are tagged with the ParamSet used"}, win.This(),
@@ -2972,48 +3198,265 @@ func (ss *Sim) CmdArgs() {
 	var nogui bool
 	var saveEpcLog bool
 	var saveRunLog bool
+	var saveTstEpcLog bool
+	var saveTrnTrlLog bool
+	var saveTstTrlLog bool
+	var logFmt string
+	var paramsFile string
+	var dumpParams string
+	var report string
+	var rsaAt string
+	var rsaLays string
+	var saveWtChgLog bool
+	var saveSlpTrlLog bool
+	var snapWts string
+	var wtsDiff string
+	var wtsDiffTop int
+	var stability string
+	var stabActThr float64
+	var slpThr string
+	var kick string
+	var watchdog string
+	var downscale string
+	var slpRules string
+	var slpMask string
+	var tmr string
+	var protocol string
+	var slpConds string
+	var strucSleep string
+	var saveSessLog bool
+	var saveKickLog bool
+	var saveDownscaleLog bool
+	var saveParamLog bool
+	var saveSlpTstLog bool
+	var slpTest string
+	var saveStrucSlpLog bool
+	flag.StringVar(&ss.Out.Root, "outdir", "output", "root directory for all output -- each batch of runs writes to its own subdirectory")
+	flag.StringVar(&ss.Out.BatchID, "batch", "", "batch ID naming the output subdirectory (default: the master random seed)")
 	flag.StringVar(&ss.ParamSet, "params", "", "ParamSet name to use -- must be valid name as listed in compiled-in params or loaded params")
 	flag.StringVar(&ss.Tag, "tag", "", "extra tag to add to file names saved from this run")
 	flag.IntVar(&ss.MaxRuns, "runs", 100, "number of runs to do (note that MaxEpcs is in paramset)")
 	flag.BoolVar(&ss.LogSetParams, "setparams", false, "if true, print a record of each parameter that is set")
 	flag.BoolVar(&ss.SaveWts, "wts", false, "if true, save final weights after each run")
+	flag.BoolVar(&ss.SlpResWrtOut, "slpres", true, "if true, save the pre- and post-sleep test results of each run to slpres.csv, which -report reads")
 	flag.BoolVar(&saveEpcLog, "epclog", true, "if true, save train epoch log to file")
-	flag.BoolVar(&saveRunLog, "runlog", false, "if true, save run epoch log to file")
+	flag.BoolVar(&saveTstEpcLog, "tstepclog", true, "if true, save test epoch log to file")
+	flag.BoolVar(&saveRunLog, "runlog", false, "if true, save run log to file")
+	flag.BoolVar(&saveTrnTrlLog, "trntrllog", false, "if true, save train trial log to file")
+	flag.BoolVar(&saveTstTrlLog, "tsttrllog", false, "if true, save test trial log to file")
+	flag.BoolVar(&saveKickLog, "kicklog", false, "if true, save the sleep kick log to file")
+	flag.BoolVar(&saveDownscaleLog, "downscalelog", false, "if true, save the synaptic downscaling log to file")
+	flag.IntVar(&ss.SlpCycEvery, "slpcyclog", 0, "if > 0, save the sleep cycle log (stability, inhibition and thresholds by cycle) of each sleep block to its own file, every this many cycles (1 for all)")
+	flag.BoolVar(&saveParamLog, "paramlog", false, "if true, save the log of the parameters changed by each sleep block to file")
+	flag.BoolVar(&saveSlpTstLog, "slptstlog", false, "if true, save the log of the interim tests during sleep (-slptest) to file")
+	flag.BoolVar(&saveStrucSlpLog, "strucslplog", false, "if true, save the structured sleep trial log to file")
+	flag.BoolVar(&saveSessLog, "sesslog", false, "if true, save the session log (test results at the end of each session of the protocol) to file")
+	flag.BoolVar(&saveSlpTrlLog, "slptrllog", false, "if true, save the sleep learning trial log to file")
+	flag.BoolVar(&saveWtChgLog, "wtchglog", false, "if true, save the per-projection weight change log of each wake and sleep phase to file")
+	flag.StringVar(&logFmt, "logfmt", "tsv", "format of the log files: tsv or csv")
 	flag.BoolVar(&nogui, "nogui", true, "if not passing any other args and want to run nogui, use nogui")
+	flag.StringVar(&paramsFile, "paramsfile", "", "comma-separated list of JSON params.Sets files to merge over the compiled-in params (later files override earlier ones)")
+	flag.StringVar(&dumpParams, "dumpparams", "", "if set, write the effective layer and projection params (wake and sleep) to this file and exit without running")
+	flag.StringVar(&report, "report", "", "if set, write a statistical report of the sleep benefit across the runs of this batch output directory and exit without running")
+	flag.StringVar(&rsaAt, "rsa", "", "comma-separated list of protocol milestones at which to run representational similarity analysis: PreSleep, PostSleep")
+	flag.StringVar(&rsaLays, "rsalays", "", "comma-separated list of layers compared by RSA (default: CTX,DG,CA3,pCA1,dCA1)")
+	flag.StringVar(&snapWts, "snapwts", "", "comma-separated list of protocol milestones at which to save a snapshot of the weights: PreSleep, PostSleep")
+	flag.StringVar(&wtsDiff, "wtsdiff", "", "if set to two weight files <A>,<B>, write the weight changes from A to B and exit without running")
+	flag.IntVar(&wtsDiffTop, "wtsdifftop", 20, "number of most changed synapses listed by -wtsdiff")
+	flag.StringVar(&stability, "stability", "mean", "network stability metric that marks the sleep plus and minus phases: mean, wmean:<layer>*<weight>:..., min, cos or win:<N>:<metric>, either for all sleep stages or as a comma-separated list of <stage>=<metric> (stages: Sleep)")
+	flag.StringVar(&slpThr, "slpthr", "fixed", "sleep plus / minus phase thresholds on the network stability: fixed (hand-tuned), pctile[:<plus>:<minus>] (percentiles of the recent stability, default 0.9:0.25) or zscore[:<plus>:<minus>] (mean + z * SD of the recent stability, default 1:-1)")
+	flag.IntVar(&ss.SlpThr.Win, "slpthrwin", ss.SlpThr.Win, "number of recent sleep cycles whose stability sets the adaptive sleep thresholds")
+	flag.IntVar(&ss.SlpThr.Interval, "slpthrint", ss.SlpThr.Interval, "the adaptive sleep thresholds are recomputed every this many cycles")
+	flag.StringVar(&kick, "kick", "off", "attractor escape policy during sleep: off, or a comma-separated list of <key>=<value> -- triggers: lowsim=<stability>, silent=<total layer activity>; when: warmup=<cycles> (1000), period=<cycles> (50), dur=<cycles> (5); action=randomize (default) or noise:<amp>; lays=<layer>:<layer>... (default all)")
+	flag.StringVar(&watchdog, "watchdog", "off", "NaN / runaway activity watchdog during sleep: off, on, or a comma-separated list of <key>=<value> -- every=<cycles> (10), silent=<total activity>:<cycles> (0.001:2000), sat=<mean activity>:<cycles> (0.95:2000), action=warn, kick or abort (warn), hist=<cycles of stability history dumped> (1000)")
+	flag.StringVar(&slpRules, "slprule", DefSlpRules, "sleep learning rule of each projection: a comma-separated list of [<selector>=]<rule>, the last that matches a projection applying -- selector: .<class>, #<name> or Prjn (all, default); rule: err (contrast of the plus and minus phase averages), hebb (plus phase averages) or mix:<hebbian fraction>, e.g. err,.PerCTXPrjn=mix:0.3")
+	flag.StringVar(&slpMask, "slpmask", DefSlpMask, "sleep-time learning mask: a comma-separated list of [<stage>:]<selector>=<setting>, the last that matches a projection applying -- stage: "+strings.Join(SlpMaskStages, ", ")+" (default all); selector: .<class>, #<name> or Prjn (all); setting: off, on or the learning rate (on); the projections none matches keep their wake learning")
+	flag.StringVar(&downscale, "downscale", "off", "synaptic downscaling during sleep: off, on, or a comma-separated list of <key>=<value> -- mode=mult (each weight loses rate of its value, default) or sub (rate of its projection's mean weight); rate=<fraction> (0.05); when=block (a step at the end of each sleep block, default) or cycle (a step every <every> sleep cycles); every=<cycles> (1000); prjns=<selector>:<selector>... (.<class>, #<name> or Prjn for all, default)")
+	flag.StringVar(&slpTest, "slptest", "off", "interim tests during each sleep step, for a sleep learning curve: off, or a comma-separated list of <key>=<value> -- cycles=<N> (a test every N sleep cycles), trials=<K> (a test every K sleep learning trials); the sleep state is restored after each test")
+	flag.StringVar(&protocol, "protocol", DefProtocol, "comma-separated list of the steps run once the network reaches the sleep criterion: sleep[:<cycles>] (30000), struc[:<epochs>] (1 epoch of structured sleep, see -strucsleep), wake:<epochs> (further wake training) or test (logged in the session log), e.g. sleep:10000,wake:5,sleep,test,wake:5,test")
+	flag.StringVar(&slpConds, "slpconds", DefSlpConds, "comma-separated list of the conditions the sleep steps of the protocol are run under, each from the weights at the sleep criterion: sleep, quiet (time-matched quiet-wake control: no inhibitory oscillation, synaptic depression or sleep learning) or quiet-<mechanism>-... (only the given mechanisms off: osc, syndep, learn, downscale), e.g. sleep,quiet,quiet-learn")
+	flag.StringVar(&strucSleep, "strucsleep", "", "structured sleep of the struc steps of the protocol: a comma-separated list of <key>=<value> -- src=sats (the training satellites, each once: the default) or tstsats (each satellite once with each of its 7 layers hidden in turn, as at test), plus=<cycles> (25), minus=<cycles> of oscillating inhibition (75)")
+	flag.StringVar(&tmr, "tmr", "", "if set, a targeted memory reactivation cue schedule file: tab-separated, with a header line and columns Item, Lay, Start, End and optionally Pat, Stage, Phase (up or down) and Gain")
+	flag.Float64Var(&stabActThr, "stabactthr", DefStabActThr, "layers with less total activity than this count as unstable in the per-layer stability metrics")
 	flag.Parse()
+	var err error
+	if ss.LogDelim, err = LogDelim(logFmt); err != nil {
+		log.Fatalln(err)
+	}
+	if ss.RSAAt, err = ParseMilestones(rsaAt, RSAMilestones); err != nil {
+		log.Fatalln("-rsa:", err)
+	}
+	if ss.SnapWtsAt, err = ParseMilestones(snapWts, SnapMilestones); err != nil {
+		log.Fatalln("-snapwts:", err)
+	}
+	ss.StabActThr = float32(stabActThr)
+	if err = ss.SetStability(stability); err != nil {
+		log.Fatalln("-stability:", err)
+	}
+	if err = ss.SlpThr.Set(slpThr); err != nil {
+		log.Fatalln("-slpthr:", err)
+	}
+	if ss.SlpThr.Win < 2 || ss.SlpThr.Interval < 1 {
+		log.Fatalln("-slpthrwin must be at least 2 and -slpthrint at least 1")
+	}
+	if err = ss.Kick.Set(kick); err != nil {
+		log.Fatalln("-kick:", err)
+	}
+	if err = ss.Watchdog.Set(watchdog); err != nil {
+		log.Fatalln("-watchdog:", err)
+	}
+	if err = ss.Downscale.Set(downscale); err != nil {
+		log.Fatalln("-downscale:", err)
+	}
+	if err = ss.SlpTest.Set(slpTest); err != nil {
+		log.Fatalln("-slptest:", err)
+	}
+	if ss.SlpRules, err = ParseSlpRules(slpRules); err != nil {
+		log.Fatalln("-slprule:", err)
+	}
+	if err = ss.SlpMask.Set(slpMask); err != nil {
+		log.Fatalln("-slpmask:", err)
+	}
+	if ss.Protocol, err = ParseProtocol(protocol); err != nil {
+		log.Fatalln("-protocol:", err)
+	}
+	if ss.SlpConds, err = ParseSlpConds(slpConds); err != nil {
+		log.Fatalln("-slpconds:", err)
+	}
+	if err = ss.StrucSlp.Set(strucSleep); err != nil {
+		log.Fatalln("-strucsleep:", err)
+	}
+	if rsaLays != "" {
+		ss.RSALays = strings.Split(rsaLays, ",")
+	}
+	if report != "" {
+		if err := ss.Report(report); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if wtsDiff != "" {
+		fnms := strings.Split(wtsDiff, ",")
+		if len(fnms) != 2 {
+			log.Fatalln("-wtsdiff: must be two weight files: <A>,<B>")
+		}
+		outs, err := WtsDiff(fnms[0], fnms[1], WtsDiffOut(fnms[0], fnms[1]), wtsDiffTop)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, fnm := range outs {
+			fmt.Printf("Saved weights diff to: %v\n", fnm)
+		}
+		return
+	}
+	if paramsFile != "" {
+		if err := ss.OpenParamsFiles(paramsFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
 	ss.Init()
+	for _, lnm := range append(ss.RSALays, ss.Kick.Lays...) {
+		if _, err := ss.Net.LayerByNameTry(lnm); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := ss.SlpRules.Check(ss.Net); err != nil {
+		log.Fatalln("-slprule:", err)
+	}
+	if err := ss.SlpMask.Check(ss.Net); err != nil {
+		log.Fatalln("-slpmask:", err)
+	}
+	if ss.Downscale.On {
+		if err := ss.Downscale.Check(ss.Net); err != nil {
+			log.Fatalln("-downscale:", err)
+		}
+	}
+	if tmr != "" {
+		cues, err := OpenTMR(tmr)
+		if err == nil {
+			err = ss.SetTMR(cues)
+		}
+		if err != nil {
+			log.Fatalln("-tmr:", err)
+		}
+	}
 
 	if ss.ParamSet != "" {
 		fmt.Printf("Using ParamSet: %s\n", ss.ParamSet)
 	}
 
-	if saveEpcLog {
-		var err error
-		fnm := ss.LogFileName("epc" + strconv.Itoa(int(ss.RndSeed)))
-		ss.TrnEpcFile, err = os.Create(fnm)
-		if err != nil {
-			log.Println(err)
-			ss.TrnEpcFile = nil
-		} else {
-			fmt.Printf("Saving epoch log to: %v\n", fnm)
-			defer ss.TrnEpcFile.Close()
+	if dumpParams != "" {
+		if err := ss.DumpParams(dumpParams); err != nil {
+			log.Fatalln(err)
 		}
+		fmt.Printf("Saved effective params to: %v\n", dumpParams)
+		return
+	}
+
+	ss.StartManifest()
+
+	if saveEpcLog {
+		ss.TrnEpcFile = ss.OpenLogFile("epc", "train epoch")
+		defer ss.TrnEpcFile.Close()
+	}
+	if saveTstEpcLog {
+		ss.TstEpcFile = ss.OpenLogFile("tstepc", "test epoch")
+		defer ss.TstEpcFile.Close()
 	}
 	if saveRunLog {
-		var err error
-		fnm := ss.LogFileName("run")
-		ss.RunFile, err = os.Create(fnm)
-		if err != nil {
-			log.Println(err)
-			ss.RunFile = nil
-		} else {
-			fmt.Printf("Saving run log to: %v\n", fnm)
-			defer ss.RunFile.Close()
-		}
+		ss.RunFile = ss.OpenLogFile("run", "run")
+		defer ss.RunFile.Close()
+	}
+	if saveTrnTrlLog {
+		ss.TrnTrlFile = ss.OpenLogFile("trntrl", "train trial")
+		defer ss.TrnTrlFile.Close()
+	}
+	if saveTstTrlLog {
+		ss.TstTrlFile = ss.OpenLogFile("tsttrl", "test trial")
+		defer ss.TstTrlFile.Close()
+	}
+	if saveKickLog {
+		ss.KickFile = ss.OpenLogFile("kick", "sleep kick")
+		defer ss.KickFile.Close()
+	}
+	if saveDownscaleLog {
+		ss.DownscaleFile = ss.OpenLogFile("downscale", "downscaling")
+		defer ss.DownscaleFile.Close()
+	}
+	if saveParamLog {
+		ss.ParamFile = ss.OpenLogFile("param", "parameter scope")
+		defer ss.ParamFile.Close()
+	}
+	if saveSlpTstLog {
+		ss.SlpTstFile = ss.OpenLogFile("slptst", "interim sleep test")
+		defer ss.SlpTstFile.Close()
+	}
+	if saveStrucSlpLog {
+		ss.StrucSlpFile = ss.OpenLogFile("strucslp", "structured sleep")
+		defer ss.StrucSlpFile.Close()
+	}
+	if saveSessLog {
+		ss.SessFile = ss.OpenLogFile("sess", "session")
+		defer ss.SessFile.Close()
+	}
+	if saveSlpTrlLog {
+		ss.SlpTrlFile = ss.OpenLogFile("slptrl", "sleep learning trial")
+		defer ss.SlpTrlFile.Close()
+	}
+	if saveWtChgLog {
+		ss.WtChgFile = ss.OpenLogFile("wtchg", "weight change")
+		defer ss.WtChgFile.Close()
+	}
+	if len(ss.RSAAt) > 0 {
+		ss.RSAFile = ss.OpenLogFile("rsa", "RSA")
+		defer ss.RSAFile.Close()
 	}
 	if ss.SaveWts {
 		fmt.Printf("Saving final weights per run\n")
 	}
 	fmt.Printf("Running %d Runs\n", ss.MaxRuns)
 	ss.Train()
+	if saveRunLog {
+		ss.SaveRunStats()
+	}
 }
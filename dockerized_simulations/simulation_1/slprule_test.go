@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSlpRules(t *testing.T) {
+	tests := []struct {
+		spec string
+		want SlpRules
+		err  bool
+	}{
+		{spec: DefSlpRules, want: SlpRules{{Sel: "Prjn", Rule: "err"}}},
+		{spec: "", want: nil},
+		{spec: "hebb", want: SlpRules{{Sel: "Prjn", Rule: "hebb"}}},
+		{spec: "err, .PerCTXPrjn = mix:0.3 ,#CA3ToCA3=hebb,", want: SlpRules{{Sel: "Prjn", Rule: "err"},
+			{Sel: ".PerCTXPrjn", Rule: "mix", Hebb: 0.3}, {Sel: "#CA3ToCA3", Rule: "hebb"}}},
+		{spec: "mix:0", want: SlpRules{{Sel: "Prjn", Rule: "mix", Hebb: 0}}},
+		{spec: "Prjn=mix:1", want: SlpRules{{Sel: "Prjn", Rule: "mix", Hebb: 1}}},
+		{spec: "oja", err: true},
+		{spec: "=err", err: true},
+		{spec: ".PerCTXPrjn=", err: true},
+		{spec: "mix", err: true},
+		{spec: "mix:", err: true},
+		{spec: "mix:x", err: true},
+		{spec: "mix:-0.1", err: true},
+		{spec: "mix:1.5", err: true},
+		{spec: "err,#CA3ToCA3=hebbian", err: true},
+	}
+	for _, tt := range tests {
+		rs, err := ParseSlpRules(tt.spec)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("ParseSlpRules(%q) = %+v, want an error", tt.spec, rs)
+		case !tt.err && err != nil:
+			t.Errorf("ParseSlpRules(%q): %v", tt.spec, err)
+		case !tt.err && !reflect.DeepEqual(rs, tt.want):
+			t.Errorf("ParseSlpRules(%q) = %+v, want %+v", tt.spec, rs, tt.want)
+		}
+	}
+}
+
+func TestSlpRuleSpec(t *testing.T) {
+	for _, spec := range []string{"err", "hebb", "mix:0.3", "mix:0", "mix:1"} {
+		rs, err := ParseSlpRules(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rs[0].Spec(); got != spec {
+			t.Errorf("Spec of %v = %v", spec, got)
+		}
+	}
+}
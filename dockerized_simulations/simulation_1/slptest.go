@@ -0,0 +1,235 @@
+// Interim tests during sleep (-slptest): the full TestAll battery is run
+// every so many sleep cycles or sleep learning trials, for a sleep learning
+// curve, with the sleep state of the network saved before each test and
+// restored after it, so that the tests do not change the course of sleep.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emer/emergent/emer"
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// SlpTestSched is the schedule of the interim tests of a sleep block
+type SlpTestSched struct {
+	Cycles int `desc:"a test every Cycles sleep cycles, 0 for none"`
+	Trials int `desc:"a test every Trials sleep learning trials, 0 for none"`
+
+	LastTrls int `view:"-" desc:"sleep learning trials at the last test of the current block"`
+}
+
+// Set sets the schedule from spec: off, or a comma-separated list of
+// <key>=<value> with keys cycles and trials
+func (st *SlpTestSched) Set(spec string) error {
+	*st = SlpTestSched{}
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil
+	}
+	for _, kv := range strings.Split(spec, ",") {
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			return fmt.Errorf("sleep tests %v: must be <key>=<value>: %v", spec, kv)
+		}
+		key, val := strings.TrimSpace(kv[:eq]), strings.TrimSpace(kv[eq+1:])
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("sleep tests %v: %v", spec, err)
+		}
+		if n < 1 {
+			return fmt.Errorf("sleep tests %v: %v must be at least 1", spec, key)
+		}
+		switch key {
+		case "cycles":
+			st.Cycles = n
+		case "trials":
+			st.Trials = n
+		default:
+			return fmt.Errorf("sleep tests %v: unknown key %v (must be cycles or trials)", spec, key)
+		}
+	}
+	return nil
+}
+
+// Reset resets the schedule at the start of a sleep block
+func (st *SlpTestSched) Reset() {
+	st.LastTrls = 0
+}
+
+// Due returns true if a test is due after cycle cyc of a sleep block of
+// cycles cycles, with slpTrls sleep learning trials so far.  No test is due
+// after the last cycle, as the block is followed by its own test.
+func (st *SlpTestSched) Due(cyc, cycles, slpTrls int) bool {
+	if cyc+1 >= cycles {
+		return false
+	}
+	if st.Cycles > 0 && (cyc+1)%st.Cycles == 0 {
+		return true
+	}
+	return st.Trials > 0 && slpTrls > st.LastTrls && slpTrls%st.Trials == 0
+}
+
+// LayState is the saved sleep state of a layer
+type LayState struct {
+	Lay     *leabra.Layer
+	Type    emer.LayerType
+	Off     bool
+	Neurons []leabra.Neuron
+	Pools   []leabra.Pool
+	CosDiff leabra.CosDiffStats
+	Sim     float64
+}
+
+// PrjnState is the saved sleep state of a projection: the synapses hold the
+// synaptic depression (Effwt, Cai) and the plus / minus phase averages
+type PrjnState struct {
+	Prjn   *leabra.Prjn
+	Syns   []leabra.Synapse
+	GScale float32
+	GInc   []float32
+}
+
+// SleepState is the saved state of the network and the sleep phase in the
+// middle of a sleep block
+type SleepState struct {
+	Lays        []LayState
+	Prjns       []PrjnState
+	Params      *ParamScope `desc:"the sleep parameters, with the current oscillating Gi"`
+	Time        leabra.Time
+	PlusPhase   bool
+	MinusPhase  bool
+	AvgLaySim   float64
+	InhibFactor float64
+	Lesion      string
+	LesionRes   map[string]TstRes
+}
+
+// SaveSleepState saves the sleep state of the network and the sim
+func (ss *Sim) SaveSleepState() *SleepState {
+	st := &SleepState{Params: NewParamScope("SlpTest", ss.Net), Time: ss.Time,
+		PlusPhase: ss.PlusPhase, MinusPhase: ss.MinusPhase, AvgLaySim: ss.AvgLaySim,
+		InhibFactor: ss.InhibFactor, Lesion: ss.Lesion, LesionRes: ss.LesionRes}
+	for _, lyi := range ss.Net.Layers {
+		ly := lyi.(leabra.LeabraLayer).AsLeabra()
+		st.Lays = append(st.Lays, LayState{Lay: ly, Type: ly.Type(), Off: ly.IsOff(),
+			Neurons: append([]leabra.Neuron(nil), ly.Neurons...), Pools: append([]leabra.Pool(nil), ly.Pools...),
+			CosDiff: ly.CosDiff, Sim: ly.Sim})
+		for _, p := range ly.SndPrjns {
+			pj := p.(leabra.LeabraPrjn).AsLeabra()
+			st.Prjns = append(st.Prjns, PrjnState{Prjn: pj, Syns: append([]leabra.Synapse(nil), pj.Syns...),
+				GScale: pj.GScale, GInc: append([]float32(nil), pj.GInc...)})
+		}
+	}
+	return st
+}
+
+// Restore returns the network and the sim to the saved sleep state
+func (st *SleepState) Restore(ss *Sim) {
+	for i := range st.Lays {
+		ls := &st.Lays[i]
+		ls.Lay.SetType(ls.Type)
+		ls.Lay.SetOff(ls.Off)
+		copy(ls.Lay.Neurons, ls.Neurons)
+		copy(ls.Lay.Pools, ls.Pools)
+		ls.Lay.CosDiff, ls.Lay.Sim = ls.CosDiff, ls.Sim
+	}
+	for i := range st.Prjns {
+		ps := &st.Prjns[i]
+		copy(ps.Prjn.Syns, ps.Syns)
+		copy(ps.Prjn.GInc, ps.GInc)
+		ps.Prjn.GScale = ps.GScale
+	}
+	st.Params.Restore()
+	ss.Time = st.Time
+	ss.PlusPhase, ss.MinusPhase = st.PlusPhase, st.MinusPhase
+	ss.AvgLaySim, ss.InhibFactor = st.AvgLaySim, st.InhibFactor
+	ss.Lesion, ss.LesionRes = st.Lesion, st.LesionRes
+}
+
+// SlpTestCyc runs an interim test after cycle cyc of sleep block block of
+// cycles cycles, if one is due (SlpTest).  wake is the parameter scope of the
+// block, which holds the wake parameters the test is run with.
+func (ss *Sim) SlpTestCyc(block string, cyc, cycles int, wake *ParamScope) {
+	if !ss.SlpTest.Due(cyc, cycles, ss.SlpTrls) {
+		return
+	}
+	ss.SlpTest.LastTrls = ss.SlpTrls
+	ss.InterimTest(block, cyc+1, wake)
+}
+
+// InterimTest runs the full test battery (TestAll) in the middle of sleep
+// block block, after cycles cycles, and logs it in the SlpTstLog.  The test
+// is run as the test that follows sleep: with the wake parameters, layer
+// types and no synaptic depression.  The sleep state is restored afterward,
+// and the test is left out of the test logs and outputs.
+func (ss *Sim) InterimTest(block string, cycles int, wake *ParamScope) {
+	st := ss.SaveSleepState()
+
+	tstEpcRows := ss.TstEpcLog.Rows
+	tstEpcFile, tstTrlFile := ss.TstEpcFile, ss.TstTrlFile
+	tstWrt, slpTstWrt := ss.TstWrtOut, ss.SlpTstWrtOut
+	ss.TstEpcFile, ss.TstTrlFile = nil, nil
+	ss.TstWrtOut, ss.SlpTstWrtOut = false, false
+
+	wake.Restore()
+	ss.BackToWake()
+	ss.Net.GScaleFmAvgAct() // update computed scaling factors
+	ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
+	ss.TestAll(true)
+	for _, lnm := range LesionNms[:NSlpTstLesions] {
+		ss.LogSlpTst(ss.SlpTstLog, block, cycles, lnm)
+	}
+
+	ss.TstEpcLog.SetNumRows(tstEpcRows)
+	ss.TstEpcFile, ss.TstTrlFile = tstEpcFile, tstTrlFile
+	ss.TstWrtOut, ss.SlpTstWrtOut = tstWrt, slpTstWrt
+	st.Restore(ss)
+	ss.UpdateView("sleep")
+}
+
+// LogSlpTst adds a row to the SlpTstLog for the results of lesion condition
+// lesion of the interim test of sleep block block after cycles cycles
+func (ss *Sim) LogSlpTst(dt *etable.Table, block string, cycles int, lesion string) {
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Block", row, block)
+	dt.SetCellFloat("Cycle", row, float64(cycles))
+	dt.SetCellFloat("SlpTrls", row, float64(ss.SlpTrls))
+	dt.SetCellString("Lesion", row, lesion)
+	for _, cn := range RunTstCols {
+		dt.SetCellFloat(cn, row, LesionVal(ss.LesionRes, lesion, cn))
+	}
+
+	ss.SlpTstFile.WriteRow(dt, row)
+}
+
+// ConfigSlpTstLog configures the SlpTstLog: one row per lesion condition of
+// each interim test during sleep
+func (ss *Sim) ConfigSlpTstLog(dt *etable.Table) {
+	dt.SetMetaData("name", "SlpTstLog")
+	dt.SetMetaData("desc", "Interim test results during sleep: the sleep learning curve")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	sch := etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Block", etensor.STRING, nil, nil},
+		{"Cycle", etensor.INT64, nil, nil},
+		{"SlpTrls", etensor.INT64, nil, nil},
+		{"Lesion", etensor.STRING, nil, nil},
+	}
+	for _, cn := range RunTstCols {
+		sch = append(sch, etable.Schema{{cn, etensor.FLOAT64, nil, nil}}...)
+	}
+	dt.SetFromSchema(sch, 0)
+}
@@ -0,0 +1,248 @@
+// Targeted memory reactivation (TMR): during sleep, weak partial patterns of
+// chosen items are applied as external input (soft clamped) to chosen layers,
+// following a cue schedule (-tmr), and the tests label each item as cued or
+// uncued so that the benefit of cueing can be measured.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// DefTMRGain is the default soft clamp gain of a cue
+const DefTMRGain = 0.1
+
+// TMRItemTables returns the tables of the items that can be cued: the
+// training satellites
+func (ss *Sim) TMRItemTables() []*etable.Table {
+	return []*etable.Table{ss.TrainSat}
+}
+
+// TMRCue is one entry of a TMR cue schedule: pattern Pat of item Item is
+// applied to layer Lay during cycles [Start, End) of each sleep block of
+// Stage, optionally only in one phase of the inhibitory oscillation
+type TMRCue struct {
+	Item  string         `desc:"name of the cued item"`
+	Pat   string         `desc:"column of the item's pattern that is applied"`
+	Lay   string         `desc:"layer the pattern is applied to"`
+	Stage string         `desc:"sleep stage the cue is applied in -- all if empty"`
+	Start int            `desc:"first cycle of each sleep block the cue is applied at"`
+	End   int            `desc:"cycle of each sleep block the cue stops at (exclusive)"`
+	Phase string         `desc:"oscillation phase the cue is applied in: up (inhibition above its midline), down (below) -- any if empty"`
+	Gain  float32        `desc:"soft clamp gain of the cue (Ge += Gain * Ext)"`
+	Ext   etensor.Tensor `view:"-" desc:"the cue pattern"`
+}
+
+// Active returns true if the cue is applied at cycle cyc of a block of sleep
+// stage, with inhibition oscillation factor inhib
+func (tc *TMRCue) Active(stage string, cyc int, inhib float64) bool {
+	if tc.Stage != "" && !strings.EqualFold(tc.Stage, stage) {
+		return false
+	}
+	if cyc < tc.Start || cyc >= tc.End {
+		return false
+	}
+	switch tc.Phase {
+	case "up":
+		return inhib > 1
+	case "down":
+		return inhib < 1
+	}
+	return true
+}
+
+// OpenTMR reads a TMR cue schedule: a tab-separated file with a header line
+// and one cue per line.  Columns Item, Lay, Start and End are required, Pat
+// (default: Lay), Stage (default: all), Phase (default: any) and Gain
+// (default: DefTMRGain) are optional.
+func OpenTMR(fnm string) ([]TMRCue, error) {
+	f, err := os.Open(fnm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd := csv.NewReader(f)
+	rd.Comma = '\t'
+	rd.Comment = '#'
+	rd.FieldsPerRecord = -1
+	recs, err := rd.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("%v: empty cue schedule", fnm)
+	}
+	cols := map[string]int{}
+	for ci, cn := range recs[0] {
+		cols[strings.TrimSpace(cn)] = ci
+	}
+	for _, cn := range []string{"Item", "Lay", "Start", "End"} {
+		if _, ok := cols[cn]; !ok {
+			return nil, fmt.Errorf("%v: missing column %v", fnm, cn)
+		}
+	}
+	var cues []TMRCue
+	for ri, rec := range recs[1:] {
+		val := func(cn string) string {
+			if ci, ok := cols[cn]; ok && ci < len(rec) {
+				return strings.TrimSpace(rec[ci])
+			}
+			return ""
+		}
+		cue := TMRCue{Item: val("Item"), Pat: val("Pat"), Lay: val("Lay"), Stage: val("Stage"), Phase: val("Phase"), Gain: DefTMRGain}
+		if cue.Pat == "" {
+			cue.Pat = cue.Lay
+		}
+		if cue.Stage == "*" {
+			cue.Stage = ""
+		}
+		if cue.Start, err = strconv.Atoi(val("Start")); err != nil {
+			return nil, fmt.Errorf("%v line %d: Start: %v", fnm, ri+2, err)
+		}
+		if cue.End, err = strconv.Atoi(val("End")); err != nil {
+			return nil, fmt.Errorf("%v line %d: End: %v", fnm, ri+2, err)
+		}
+		if g := val("Gain"); g != "" {
+			gain, err := strconv.ParseFloat(g, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%v line %d: Gain: %v", fnm, ri+2, err)
+			}
+			cue.Gain = float32(gain)
+		}
+		switch {
+		case cue.End <= cue.Start:
+			return nil, fmt.Errorf("%v line %d: End must be after Start", fnm, ri+2)
+		case cue.Phase != "" && cue.Phase != "up" && cue.Phase != "down":
+			return nil, fmt.Errorf("%v line %d: Phase must be up or down", fnm, ri+2)
+		case cue.Stage != "" && !HasMilestone(SleepStages, cue.Stage):
+			return nil, fmt.Errorf("%v line %d: unknown sleep stage %v (must be one of %v)", fnm, ri+2, cue.Stage, strings.Join(SleepStages, ", "))
+		}
+		cues = append(cues, cue)
+	}
+	return cues, nil
+}
+
+// SetTMR sets the TMR cue schedule to cues, looking up the pattern of each cue
+// in the TMRItemTables and checking its layer
+func (ss *Sim) SetTMR(cues []TMRCue) error {
+	for i := range cues {
+		cue := &cues[i]
+		for _, dt := range ss.TMRItemTables() {
+			if dt.ColIdx(cue.Pat) < 0 {
+				continue
+			}
+			if row := dt.RowsByString("Name", cue.Item, false, false); len(row) > 0 {
+				cue.Ext = dt.CellTensor(cue.Pat, row[0])
+				break
+			}
+		}
+		if cue.Ext == nil {
+			return fmt.Errorf("TMR cue: no item %v with pattern %v", cue.Item, cue.Pat)
+		}
+		if _, err := ss.Net.LayerByNameTry(cue.Lay); err != nil {
+			return fmt.Errorf("TMR cue: %v", err)
+		}
+	}
+	ss.TMR = cues
+	return nil
+}
+
+// TMRCued returns true if item is cued by the TMR schedule
+func (ss *Sim) TMRCued(item string) bool {
+	for i := range ss.TMR {
+		if ss.TMR[i].Item == item {
+			return true
+		}
+	}
+	return false
+}
+
+// TMRStart prepares the cued layers for a sleep block: the cues are soft
+// clamped, leaving the sleep-time layer types as they are.  Returns the
+// clamp params of the cued layers, to be restored by TMREnd.
+func (ss *Sim) TMRStart() map[string]leabra.ClampParams {
+	clamps := map[string]leabra.ClampParams{}
+	for i := range ss.TMR {
+		lnm := ss.TMR[i].Lay
+		if _, ok := clamps[lnm]; ok {
+			continue
+		}
+		ly := ss.Net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra()
+		clamps[lnm] = ly.Act.Clamp
+		ly.Act.Clamp.Hard = false
+		ly.Act.Clamp.Avg = false
+	}
+	ss.TMRActive = nil
+	return clamps
+}
+
+// TMRCyc applies the cues that are active at cycle cyc of a block of sleep
+// stage, for the next cycle, and clears the others
+func (ss *Sim) TMRCyc(stage string, cyc int, clamps map[string]leabra.ClampParams) {
+	if len(ss.TMR) == 0 {
+		return
+	}
+	for lnm := range clamps {
+		ss.Net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra().InitExt()
+	}
+	ss.TMRActive = ss.TMRActive[:0]
+	for i := range ss.TMR {
+		cue := &ss.TMR[i]
+		if !cue.Active(stage, cyc, ss.InhibFactor) {
+			continue
+		}
+		ly := ss.Net.LayerByName(cue.Lay).(leabra.LeabraLayer).AsLeabra()
+		ly.Act.Clamp.Gain = cue.Gain
+		ly.ApplyExt(cue.Ext)
+		ss.TMRActive = append(ss.TMRActive, cue.Item)
+	}
+}
+
+// TMREnd clears the cues at the end of a sleep block and restores the clamp
+// params of the cued layers
+func (ss *Sim) TMREnd(clamps map[string]leabra.ClampParams) {
+	for lnm, cp := range clamps {
+		ly := ss.Net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra()
+		ly.InitExt()
+		ly.Act.Clamp = cp
+	}
+	ss.TMRActive = nil
+}
+
+// TMRTstStats returns the proportion correct (SSE == 0) and the mean SSE of
+// the cued and uncued test trials of ix, a view of a TstTrlLog -- NaN if
+// there are no such trials
+func TMRTstStats(ix *etable.IdxView) (cuedCor, uncuedCor, cuedSSE, uncuedSSE float64) {
+	var n, ncor [2]int
+	var sse [2]float64
+	for _, row := range ix.Idxs {
+		ci := 0
+		if ix.Table.CellFloat("Cued", row) == 1 {
+			ci = 1
+		}
+		s := ix.Table.CellFloat("SSE", row)
+		n[ci]++
+		sse[ci] += s
+		if s == 0 {
+			ncor[ci]++
+		}
+	}
+	var cor, msse [2]float64
+	for ci := range n {
+		cor[ci], msse[ci] = math.NaN(), math.NaN()
+		if n[ci] > 0 {
+			cor[ci] = float64(ncor[ci]) / float64(n[ci])
+			msse[ci] = sse[ci] / float64(n[ci])
+		}
+	}
+	return cor[1], cor[0], msse[1], msse[0]
+}
@@ -0,0 +1,249 @@
+// Synaptic downscaling during sleep (-downscale): after the synaptic
+// homeostasis hypothesis, the weights of the target projections are scaled
+// down at the end of each sleep block, or continuously during sleep,
+// alongside the error-driven sleep learning.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/emer/emergent/params"
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// Downscale is synaptic downscaling: each step scales down the weights of
+// the target projections, multiplicatively or subtractively
+type Downscale struct {
+	On    bool     `desc:"if false, the weights are never downscaled"`
+	Mode  string   `desc:"mult: each weight loses Rate of its value; sub: each weight loses Rate of the mean weight of its projection, down to 0"`
+	Rate  float32  `desc:"strength of each downscaling step"`
+	When  string   `desc:"block: one step at the end of each sleep block; cycle: one step every Every cycles of sleep"`
+	Every int      `desc:"number of sleep cycles between steps when When is cycle"`
+	Prjns []string `desc:"projections downscaled, as params selectors: .<class>, #<name> or Prjn (all)"`
+
+	Stats []DownscaleStat `view:"-" desc:"accounting of each target projection over the current sleep block"`
+}
+
+// DownscaleStat is the accounting of the downscaling of one projection over
+// a sleep block
+type DownscaleStat struct {
+	Prjn        *leabra.Prjn `desc:"the projection"`
+	Steps       int          `desc:"number of downscaling steps"`
+	SumAbsChg   float64      `desc:"total |weight change| of the steps"`
+	WtNormStart float64      `desc:"L2 norm of the weights at the start of the block"`
+}
+
+// Defaults sets the default downscaling: off, and when on, multiplicative,
+// by 5% at the end of each sleep block, of all the projections
+func (ds *Downscale) Defaults() {
+	*ds = Downscale{Mode: "mult", Rate: 0.05, When: "block", Every: 1000, Prjns: []string{"Prjn"}}
+}
+
+// Set sets the downscaling from spec: off, on, or a comma-separated list of
+// <key>=<value> with keys mode (mult or sub), rate, when (block or cycle),
+// every and prjns (colon-separated selectors)
+func (ds *Downscale) Set(spec string) error {
+	ds.Defaults()
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil
+	}
+	ds.On = true
+	if spec == "on" {
+		return nil
+	}
+	for _, kv := range strings.Split(spec, ",") {
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			return fmt.Errorf("downscaling %v: must be <key>=<value>: %v", spec, kv)
+		}
+		key, val := strings.TrimSpace(kv[:eq]), strings.TrimSpace(kv[eq+1:])
+		var err error
+		switch key {
+		case "mode":
+			if val != "mult" && val != "sub" {
+				return fmt.Errorf("downscaling %v: mode must be mult or sub", spec)
+			}
+			ds.Mode = val
+		case "rate":
+			var rate float64
+			rate, err = strconv.ParseFloat(val, 32)
+			ds.Rate = float32(rate)
+		case "when":
+			if val != "block" && val != "cycle" {
+				return fmt.Errorf("downscaling %v: when must be block or cycle", spec)
+			}
+			ds.When = val
+		case "every":
+			ds.Every, err = strconv.Atoi(val)
+		case "prjns":
+			ds.Prjns = strings.Split(val, ":")
+		default:
+			return fmt.Errorf("downscaling %v: unknown key %v (must be mode, rate, when, every or prjns)", spec, key)
+		}
+		if err != nil {
+			return fmt.Errorf("downscaling %v: %v", spec, err)
+		}
+	}
+	if ds.Rate <= 0 || ds.Rate >= 1 {
+		return fmt.Errorf("downscaling %v: rate must be between 0 and 1", spec)
+	}
+	if ds.Every < 1 {
+		return fmt.Errorf("downscaling %v: every must be at least 1", spec)
+	}
+	return nil
+}
+
+// Targets returns true if projection pj is one of the Prjns
+func (ds *Downscale) Targets(pj *leabra.Prjn) bool {
+	for _, sel := range ds.Prjns {
+		if params.SelMatch(sel, pj.Name(), pj.Class(), "Prjn", "Prjn") {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error if any of the Prjns selects no projection of net
+func (ds *Downscale) Check(net *leabra.Network) error {
+	for _, sel := range ds.Prjns {
+		found := false
+		for _, ly := range net.Layers {
+			for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+				pj := p.(leabra.LeabraPrjn).AsLeabra()
+				found = found || params.SelMatch(sel, pj.Name(), pj.Class(), "Prjn", "Prjn")
+			}
+		}
+		if !found {
+			return fmt.Errorf("downscaling: no projection matches %v", sel)
+		}
+	}
+	return nil
+}
+
+// Start starts the accounting of a sleep block, for the target projections
+// of net that are not off
+func (ds *Downscale) Start(net *leabra.Network) {
+	ds.Stats = ds.Stats[:0]
+	if !ds.On {
+		return
+	}
+	for _, ly := range net.Layers {
+		for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+			pj := p.(leabra.LeabraPrjn).AsLeabra()
+			if p.IsOff() || !ds.Targets(pj) {
+				continue
+			}
+			ds.Stats = append(ds.Stats, DownscaleStat{Prjn: pj, WtNormStart: WtNorm(pj)})
+		}
+	}
+}
+
+// Step downscales the weights of the target projections once
+func (ds *Downscale) Step() {
+	for i := range ds.Stats {
+		st := &ds.Stats[i]
+		pj := st.Prjn
+		if len(pj.Syns) == 0 {
+			continue
+		}
+		sub := float32(0)
+		if ds.Mode == "sub" {
+			for si := range pj.Syns {
+				sub += pj.Syns[si].Wt
+			}
+			sub *= ds.Rate / float32(len(pj.Syns))
+		}
+		for si := range pj.Syns {
+			sy := &pj.Syns[si]
+			wt := sy.Wt
+			if ds.Mode == "sub" {
+				sy.Wt = wt - sub
+				if sy.Wt < 0 {
+					sy.Wt = 0
+				}
+			} else {
+				sy.Wt = wt * (1 - ds.Rate)
+			}
+			pj.Learn.LWtFmWt(sy)
+			sy.EffwtUpdt()
+			st.SumAbsChg += math.Abs(float64(wt - sy.Wt))
+		}
+		st.Steps++
+	}
+}
+
+// DownscaleCyc downscales the weights at sleep cycle cyc, when downscaling
+// is continuous
+func (ss *Sim) DownscaleCyc(cyc int) {
+	if ss.Downscale.On && ss.Downscale.When == "cycle" && (cyc+1)%ss.Downscale.Every == 0 {
+		ss.Downscale.Step()
+	}
+}
+
+// DownscaleBlock ends sleep block block: downscales the weights if it is
+// done per block, and logs the downscaling of the block
+func (ss *Sim) DownscaleBlock(block string) {
+	if !ss.Downscale.On {
+		return
+	}
+	if ss.Downscale.When == "block" {
+		ss.Downscale.Step()
+	}
+	ss.LogDownscale(ss.DownscaleLog, block)
+}
+
+// LogDownscale adds one row per target projection to the DownscaleLog, from
+// the accounting of sleep block block
+func (ss *Sim) LogDownscale(dt *etable.Table, block string) {
+	for _, st := range ss.Downscale.Stats {
+		row := dt.Rows
+		dt.SetNumRows(row + 1)
+
+		end := WtNorm(st.Prjn)
+		dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+		dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+		dt.SetCellString("Block", row, block)
+		dt.SetCellString("Prjn", row, st.Prjn.Name())
+		dt.SetCellString("Class", row, st.Prjn.Class())
+		dt.SetCellString("Mode", row, ss.Downscale.Mode)
+		dt.SetCellFloat("Rate", row, float64(ss.Downscale.Rate))
+		dt.SetCellFloat("Steps", row, float64(st.Steps))
+		dt.SetCellFloat("SumAbsChg", row, st.SumAbsChg)
+		dt.SetCellFloat("WtNormStart", row, st.WtNormStart)
+		dt.SetCellFloat("WtNormEnd", row, end)
+		dt.SetCellFloat("WtNormChg", row, end-st.WtNormStart)
+
+		ss.DownscaleFile.WriteRow(dt, row)
+	}
+}
+
+// ConfigDownscaleLog configures the DownscaleLog: one row per target
+// projection per sleep block
+func (ss *Sim) ConfigDownscaleLog(dt *etable.Table) {
+	dt.SetMetaData("name", "DownscaleLog")
+	dt.SetMetaData("desc", "Synaptic downscaling of each target projection over each sleep block")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	dt.SetFromSchema(etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Block", etensor.STRING, nil, nil},
+		{"Prjn", etensor.STRING, nil, nil},
+		{"Class", etensor.STRING, nil, nil},
+		{"Mode", etensor.STRING, nil, nil},
+		{"Rate", etensor.FLOAT64, nil, nil},
+		{"Steps", etensor.INT64, nil, nil},
+		{"SumAbsChg", etensor.FLOAT64, nil, nil},
+		{"WtNormStart", etensor.FLOAT64, nil, nil},
+		{"WtNormEnd", etensor.FLOAT64, nil, nil},
+		{"WtNormChg", etensor.FLOAT64, nil, nil},
+	}, 0)
+}
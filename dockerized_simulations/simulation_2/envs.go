@@ -0,0 +1,335 @@
+// Sequential environments (-envs): the environments are learned one after
+// the other, each from its own pattern file, until it meets its learning
+// criterion for its number of overtraining epochs, with the hippocampus on or
+// off.  Once the last one is learned, the network goes through the sleep
+// protocol, and every test covers all of the environments.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/emer/etable/etable"
+	"github.com/schapirolab/leabra-sleep/hip"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// DefEnvs is the default environment sequence: AB (Env 1) learned by the
+// cortex alone, overtrained for 30 epochs, then AC (Env 2) with the
+// hippocampus on
+const DefEnvs = "AB:env1_pats.txt:over=30:hip=off,AC:env2_pats.txt"
+
+// HipLays are the hippocampal layers turned off while an environment is
+// learned without the hippocampus, and for the tests around sleep
+var HipLays = []string{"DG", "CA3", "pCA1", "dCA1"}
+
+// HipCTXLrate is the learning rate of the cortical (CTX) projections while
+// an environment is learned with the hippocampus on
+const HipCTXLrate = 0.0001
+
+// SeqEnv is one of the environments learned in sequence
+type SeqEnv struct {
+	Name string        `desc:"name of the environment, which prefixes its test log columns"`
+	File string        `desc:"pattern file, with Name, EXT and Output columns"`
+	Crit float64       `desc:"learning criterion: the maximum training and test SSE"`
+	Over int           `desc:"number of consecutive test epochs the criterion must be met for the environment to be learned"`
+	Hip  bool          `desc:"whether the hippocampus is on while the environment is learned -- if it is on, the CTX learning rate is lowered to HipCTXLrate"`
+	Pats *etable.Table `view:"no-inline" desc:"training and testing patterns"`
+
+	TrainSSE float64 `inactive:"+" desc:"training SSE of the last epoch on the environment"`
+	TestSSE  float64 `inactive:"+" desc:"SSE of the last test"`
+	TestCor  float64 `inactive:"+" desc:"proportion correct of the last test"`
+	OverCnt  int     `inactive:"+" desc:"number of consecutive test epochs the criterion has been met for"`
+	Learned  bool    `inactive:"+" desc:"whether the environment has been learned this run"`
+	NearIn   int     `view:"-" desc:"pattern whose input is closest to the current Input activity during sleep"`
+	InMatch  float32 `view:"-" desc:"summed absolute difference of the NearIn input from the Input activity"`
+	NearOut  int     `view:"-" desc:"pattern whose output is closest to the current Output activity during sleep"`
+	OutMatch float32 `view:"-" desc:"summed absolute difference of the NearOut output from the Output activity"`
+}
+
+// Met returns true if the last training epoch and test meet the criterion
+func (en *SeqEnv) Met() bool {
+	return en.TrainSSE <= en.Crit && en.TestSSE <= en.Crit
+}
+
+// ParseEnvs parses an environment sequence spec: a comma-separated list of
+// <name>:<file>[:<key>=<value>...], in the order they are learned, with keys
+// crit (maximum SSE, default 0), over (epochs, default 1) and hip (on, the
+// default, or off), e.g. AB:env1_pats.txt:over=30:hip=off,AC:env2_pats.txt
+func ParseEnvs(spec string) ([]*SeqEnv, error) {
+	var envs []*SeqEnv
+	seen := map[string]bool{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		args := strings.Split(s, ":")
+		if len(args) < 2 || args[0] == "" || args[1] == "" {
+			return nil, fmt.Errorf("invalid environment: %v (must be <name>:<file>[:<key>=<value>...])", s)
+		}
+		en := &SeqEnv{Name: args[0], File: args[1], Over: 1, Hip: true}
+		if strings.ContainsAny(en.Name, " \t") {
+			return nil, fmt.Errorf("environment %v: name must not contain spaces", s)
+		}
+		if seen[en.Name] {
+			return nil, fmt.Errorf("environment %v: name %v is already used", s, en.Name)
+		}
+		seen[en.Name] = true
+		for _, kv := range args[2:] {
+			eq := strings.Index(kv, "=")
+			if eq < 0 {
+				return nil, fmt.Errorf("environment %v: must be <key>=<value>: %v", s, kv)
+			}
+			key, val := kv[:eq], kv[eq+1:]
+			var err error
+			switch key {
+			case "crit":
+				en.Crit, err = strconv.ParseFloat(val, 64)
+				if err == nil && en.Crit < 0 {
+					err = fmt.Errorf("crit must not be negative")
+				}
+			case "over":
+				en.Over, err = strconv.Atoi(val)
+				if err == nil && en.Over < 1 {
+					err = fmt.Errorf("over must be at least 1")
+				}
+			case "hip":
+				switch val {
+				case "on":
+					en.Hip = true
+				case "off":
+					en.Hip = false
+				default:
+					err = fmt.Errorf("hip must be on or off")
+				}
+			default:
+				err = fmt.Errorf("unknown key %v (must be crit, over or hip)", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("environment %v: %v", s, err)
+			}
+		}
+		envs = append(envs, en)
+	}
+	if len(envs) == 0 {
+		return nil, fmt.Errorf("no environments")
+	}
+	return envs, nil
+}
+
+// SetEnvs sets the environment sequence from spec (see ParseEnvs), and the
+// test names from their names.  The patterns are loaded by OpenPats.
+func (ss *Sim) SetEnvs(spec string) error {
+	envs, err := ParseEnvs(spec)
+	if err != nil {
+		return err
+	}
+	ss.Envs = envs
+	ss.TstNms = nil
+	for _, en := range envs {
+		ss.TstNms = append(ss.TstNms, en.Name)
+	}
+	return nil
+}
+
+// ReconfigEnvs loads the patterns of a new environment sequence and
+// reconfigures the logs that have columns for each environment
+func (ss *Sim) ReconfigEnvs() {
+	ss.OpenPats()
+	ss.ConfigPats()
+	ss.ConfigTstEpcLog(ss.TstEpcLog)
+	ss.ConfigRunLog(ss.RunLog)
+	ss.ConfigSessLog(ss.SessLog)
+}
+
+// CheckEnvs returns an error if the patterns of an environment could not be
+// loaded or do not have the Name, EXT and Output columns
+func (ss *Sim) CheckEnvs() error {
+	for _, en := range ss.Envs {
+		if en.Pats == nil || en.Pats.Rows == 0 {
+			return fmt.Errorf("environment %v: no patterns in %v", en.Name, en.File)
+		}
+		for _, cn := range []string{"Name", "EXT", "Output"} {
+			if _, err := en.Pats.ColByNameTry(cn); err != nil {
+				return fmt.Errorf("environment %v: %v: %v", en.Name, en.File, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CurEnv returns the environment being trained
+func (ss *Sim) CurEnv() *SeqEnv {
+	return ss.Envs[ss.EnvIdx]
+}
+
+// EnvByName returns the environment of the given name, nil if none
+func (ss *Sim) EnvByName(nm string) *SeqEnv {
+	for _, en := range ss.Envs {
+		if en.Name == nm {
+			return en
+		}
+	}
+	return nil
+}
+
+// AllEnvsLearned returns true once the last environment has been learned
+func (ss *Sim) AllEnvsLearned() bool {
+	return ss.Envs[len(ss.Envs)-1].Learned
+}
+
+// ResetEnvs resets the learning state of the environments at the start of
+// a run, and trains the first one
+func (ss *Sim) ResetEnvs() {
+	for _, en := range ss.Envs {
+		en.TrainSSE, en.TestSSE, en.TestCor = 0, 0, 0
+		en.OverCnt = 0
+		en.Learned = false
+	}
+	ss.SetEnv(0)
+}
+
+// SetEnv makes environment i the one trained.  Its hippocampus configuration
+// is applied by the next training trial.
+func (ss *Sim) SetEnv(i int) {
+	ss.EnvIdx = i
+	ss.EnvCfgd = false
+	ss.TrainEnv.Table = etable.NewIdxView(ss.Envs[i].Pats)
+}
+
+// CTXLratePrjns returns the cortical projections whose learning rate is
+// lowered while the hippocampus is on: Input to CTX and CTX to Output
+func (ss *Sim) CTXLratePrjns() []*hip.CHLPrjn {
+	ctx := ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra()
+	return []*hip.CHLPrjn{ctx.RcvPrjns.SendName("Input").(*hip.CHLPrjn),
+		ctx.SndPrjns.RecvName("Output").(*hip.CHLPrjn)}
+}
+
+// SaveCTXLrates saves the params learning rates of the CTXLratePrjns, which
+// an environment learned without the hippocampus is trained with
+func (ss *Sim) SaveCTXLrates() {
+	ss.CTXLrates = ss.CTXLrates[:0]
+	for _, pj := range ss.CTXLratePrjns() {
+		ss.CTXLrates = append(ss.CTXLrates, pj.Learn.Lrate)
+	}
+}
+
+// ConfigEnvHip applies the hippocampus configuration of the environment
+// being trained: hippocampus off with the params CTX learning rates
+// (CTXLrates), or all layers on with the CTX learning rate lowered to
+// HipCTXLrate.  An environment learned without the hippocampus has it turned
+// off again every trial, as sleep and the tests turn it on.
+func (ss *Sim) ConfigEnvHip() {
+	en := ss.CurEnv()
+	if en.Hip && ss.EnvCfgd {
+		return
+	}
+	ss.SetHip(en.Hip)
+	for i, pj := range ss.CTXLratePrjns() {
+		if en.Hip {
+			pj.Learn.Lrate = HipCTXLrate
+		} else {
+			pj.Learn.Lrate = ss.CTXLrates[i]
+		}
+	}
+	ss.EnvCfgd = true
+}
+
+// SetHip turns the hippocampal layers (HipLays) on or off, with CTX on
+func (ss *Sim) SetHip(on bool) {
+	ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
+	for _, lnm := range HipLays {
+		ss.Net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra().SetOff(!on)
+	}
+	ss.Net.GScaleFmAvgAct() // update computed scaling factors
+	ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
+}
+
+// CheckLearned updates the criterion count of the environment being trained
+// from the last training epoch and test, and moves on to the next
+// environment once it is learned.  Returns true once the last one is learned.
+func (ss *Sim) CheckLearned() bool {
+	en := ss.CurEnv()
+	if en.Met() {
+		en.OverCnt++
+	} else {
+		en.OverCnt = 0
+	}
+	if en.OverCnt < en.Over {
+		return false
+	}
+	en.Learned = true
+	if ss.EnvIdx == len(ss.Envs)-1 {
+		return true
+	}
+	ss.SetEnv(ss.EnvIdx + 1)
+	return false
+}
+
+// EnvTest is the last test result of an environment
+type EnvTest struct {
+	SSE float64
+	Cor float64
+}
+
+// EnvTests returns the last test results of the environments
+func (ss *Sim) EnvTests() []EnvTest {
+	tsts := make([]EnvTest, len(ss.Envs))
+	for i, en := range ss.Envs {
+		tsts[i] = EnvTest{SSE: en.TestSSE, Cor: en.TestCor}
+	}
+	return tsts
+}
+
+// SetEnvTests sets the last test results of the environments to tsts
+func (ss *Sim) SetEnvTests(tsts []EnvTest) {
+	for i, en := range ss.Envs {
+		en.TestSSE, en.TestCor = tsts[i].SSE, tsts[i].Cor
+	}
+}
+
+// NearestPat returns the row of dt whose col pattern is closest to act, in
+// summed absolute difference, and that difference.  Only the first of equally
+// close rows is returned.
+func NearestPat(dt *etable.Table, col string, act []float32) (int, float32) {
+	min, minDif := 0, math.Inf(1)
+	for r := 0; r < dt.Rows; r++ {
+		tsr := dt.CellTensor(col, r)
+		dif := 0.0
+		for i := 0; i < tsr.Len() && i < len(act); i++ {
+			dif += math.Abs(float64(float32(tsr.FloatVal1D(i)) - act[i]))
+		}
+		if dif < minDif {
+			min, minDif = r, dif
+		}
+	}
+	return min, float32(minDif)
+}
+
+// EnvSSECounters returns the training and test SSE of each environment, for
+// the train and test Counters
+func (ss *Sim) EnvSSECounters() string {
+	var s []string
+	for _, en := range ss.Envs {
+		s = append(s, fmt.Sprintf("Train%vSSE: %.2f", en.Name, en.TrainSSE))
+	}
+	for _, en := range ss.Envs {
+		s = append(s, fmt.Sprintf("Test%vSSE: %.2f", en.Name, en.TestSSE))
+	}
+	return " " + strings.Join(s, "\t")
+}
+
+// EnvMatchCounters returns the closest patterns of each environment to the
+// current activity, for the sleep Counters
+func (ss *Sim) EnvMatchCounters() string {
+	var s []string
+	for _, en := range ss.Envs {
+		s = append(s, fmt.Sprintf("%v NearIn: %d\tMatchIn: %.2f\tNearOut: %d\tMatchOut: %.2f", en.Name,
+			en.NearIn, en.InMatch, en.NearOut, en.OutMatch))
+	}
+	return strings.Join(s, "\t\t\t\n")
+}
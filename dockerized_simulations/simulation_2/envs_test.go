@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+// TestConfigEnvHipLrates checks that an environment learned without the
+// hippocampus after one learned with it is trained with the params CTX
+// learning rates, not the lowered HipCTXLrate
+func TestConfigEnvHipLrates(t *testing.T) {
+	ss := &Sim{}
+	ss.New()
+	if err := ss.SetEnvs("AB:env1_pats.txt:hip=on,AC:env2_pats.txt:hip=off"); err != nil {
+		t.Fatal(err)
+	}
+	ss.Config()
+	ss.ResetEnvs()
+	lrates := append([]float32(nil), ss.CTXLrates...)
+	if len(lrates) != 2 || lrates[0] == HipCTXLrate {
+		t.Fatalf("params CTX lrates not saved: %v", lrates)
+	}
+
+	ss.ConfigEnvHip()
+	for i, pj := range ss.CTXLratePrjns() {
+		if pj.Learn.Lrate != HipCTXLrate {
+			t.Errorf("hip=on prjn %d: Lrate = %v, want %v", i, pj.Learn.Lrate, HipCTXLrate)
+		}
+	}
+
+	ss.SetEnv(1)
+	ss.ConfigEnvHip()
+	for i, pj := range ss.CTXLratePrjns() {
+		if pj.Learn.Lrate != lrates[i] {
+			t.Errorf("hip=off prjn %d: Lrate = %v, want %v", i, pj.Learn.Lrate, lrates[i])
+		}
+	}
+}
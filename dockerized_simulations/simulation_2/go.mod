@@ -11,4 +11,5 @@ require (
 	github.com/goki/ki v1.0.1
 	github.com/goki/mat32 v1.0.1
 	github.com/schapirolab/leabra-sleep v0.0.0-20220221004328-da827864f6ba
+	gonum.org/v1/gonum v0.7.0
 )
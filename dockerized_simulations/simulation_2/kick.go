@@ -0,0 +1,252 @@
+// Attractor escape policy during sleep: when the network gets stuck (low
+// stability, silent layers, or replaying the same item for too long), its
+// activations are kicked to get it going again (-kick).
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// KickPolicy is the attractor escape policy during sleep: its triggers are
+// checked every cycle outside of sleep learning trials, and any of them
+// firing kicks the activations of the network
+type KickPolicy struct {
+	On        bool     `desc:"if false, the network is never kicked"`
+	LowSim    float64  `desc:"kick if AvgLaySim is at or below this -- 0 for no low stability trigger"`
+	SilentThr float32  `desc:"kick if the total activity of any of the stability layers is below this -- 0 for no silent layer trigger"`
+	StuckCycs int      `desc:"kick if the same item has been decoded for this many cycles -- 0 for no stuck item trigger (requires a decoder)"`
+	Warmup    int      `desc:"no kicks during the first Warmup cycles of a sleep block, to let the network settle into an attractor"`
+	Period    int      `desc:"the triggers are only checked on the first Dur cycles of every Period cycles"`
+	Dur       int      `desc:"the triggers are only checked on the first Dur cycles of every Period cycles"`
+	Action    string   `desc:"randomize: set the activations to random values; noise: add uniform noise of +/- Amp to the activations"`
+	Amp       float32  `desc:"noise amplitude"`
+	Lays      []string `desc:"layers that are kicked -- all if empty"`
+	Item      string   `view:"-" desc:"item decoded on the last cycle"`
+	ItemCycs  int      `view:"-" desc:"number of consecutive cycles Item has been decoded"`
+}
+
+// Defaults sets the default policy: off, and when on, randomize all layers,
+// checking the triggers on cycles 0-4 of every 50 after the first 1000
+func (kp *KickPolicy) Defaults() {
+	*kp = KickPolicy{Warmup: 1000, Period: 50, Dur: 5, Action: "randomize", Amp: 0.2}
+}
+
+// Set sets the policy from spec: off, or a comma-separated list of
+// <key>=<value> with keys lowsim, silent, stuck, warmup, period, dur,
+// action (randomize or noise:<amp>) and lays (colon-separated)
+func (kp *KickPolicy) Set(spec string) error {
+	kp.Defaults()
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil
+	}
+	kp.On = true
+	for _, kv := range strings.Split(spec, ",") {
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			return fmt.Errorf("kick policy %v: must be <key>=<value>: %v", spec, kv)
+		}
+		key, val := strings.TrimSpace(kv[:eq]), strings.TrimSpace(kv[eq+1:])
+		var err error
+		switch key {
+		case "lowsim":
+			kp.LowSim, err = strconv.ParseFloat(val, 64)
+		case "silent":
+			var thr float64
+			thr, err = strconv.ParseFloat(val, 32)
+			kp.SilentThr = float32(thr)
+		case "stuck":
+			kp.StuckCycs, err = strconv.Atoi(val)
+		case "warmup":
+			kp.Warmup, err = strconv.Atoi(val)
+		case "period":
+			kp.Period, err = strconv.Atoi(val)
+		case "dur":
+			kp.Dur, err = strconv.Atoi(val)
+		case "action":
+			args := strings.Split(val, ":")
+			kp.Action = args[0]
+			switch {
+			case kp.Action == "randomize" && len(args) == 1:
+			case kp.Action == "noise" && len(args) <= 2:
+				if len(args) == 2 {
+					var amp float64
+					amp, err = strconv.ParseFloat(args[1], 32)
+					kp.Amp = float32(amp)
+				}
+			default:
+				return fmt.Errorf("kick policy %v: action must be randomize or noise[:<amp>]", spec)
+			}
+		case "lays":
+			kp.Lays = strings.Split(val, ":")
+		default:
+			return fmt.Errorf("kick policy %v: unknown key %v (must be lowsim, silent, stuck, warmup, period, dur, action or lays)", spec, key)
+		}
+		if err != nil {
+			return fmt.Errorf("kick policy %v: %v", spec, err)
+		}
+	}
+	if kp.LowSim <= 0 && kp.SilentThr <= 0 && kp.StuckCycs <= 0 {
+		return fmt.Errorf("kick policy %v: needs at least one trigger: lowsim, silent or stuck", spec)
+	}
+	if kp.Period < 1 || kp.Dur < 1 {
+		return fmt.Errorf("kick policy %v: period and dur must be at least 1", spec)
+	}
+	return nil
+}
+
+// Reset resets the decoded item at the start of a sleep block
+func (kp *KickPolicy) Reset() {
+	kp.Item = ""
+	kp.ItemCycs = 0
+}
+
+// SeeItem records the item decoded on the current cycle -- empty if none
+func (kp *KickPolicy) SeeItem(item string) {
+	if item == "" || item != kp.Item {
+		kp.Item = item
+		kp.ItemCycs = 0
+	}
+	if item != "" {
+		kp.ItemCycs++
+	}
+}
+
+// Trigger returns the trigger that fires at sleep cycle cyc, with stability
+// sim over layers lays of net -- empty if none
+func (kp *KickPolicy) Trigger(net *leabra.Network, cyc int, sim float64, lays []string) string {
+	if !kp.On || cyc < kp.Warmup || cyc%kp.Period >= kp.Dur {
+		return ""
+	}
+	if kp.LowSim > 0 && sim <= kp.LowSim {
+		return "LowSim"
+	}
+	if kp.SilentThr > 0 {
+		for _, lnm := range lays {
+			ly := net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra()
+			if ly.IsOff() {
+				continue
+			}
+			actsum := float32(0)
+			for ni := range ly.Neurons {
+				actsum += ly.Neurons[ni].Act
+			}
+			if actsum < kp.SilentThr {
+				return "Silent:" + lnm
+			}
+		}
+	}
+	if kp.StuckCycs > 0 && kp.ItemCycs >= kp.StuckCycs {
+		return "Stuck:" + kp.Item
+	}
+	return ""
+}
+
+// Kicks returns true if layer lnm is kicked
+func (kp *KickPolicy) Kicks(lnm string) bool {
+	if len(kp.Lays) == 0 {
+		return true
+	}
+	for _, l := range kp.Lays {
+		if l == lnm {
+			return true
+		}
+	}
+	return false
+}
+
+// Kick applies the kick action to the layers of net
+func (kp *KickPolicy) Kick(net *leabra.Network) {
+	for _, lyi := range net.Layers {
+		ly := lyi.(leabra.LeabraLayer).AsLeabra()
+		if ly.IsOff() || !kp.Kicks(ly.Name()) {
+			continue
+		}
+		for ni := range ly.Neurons {
+			nrn := &ly.Neurons[ni]
+			if nrn.IsOff() {
+				continue
+			}
+			switch kp.Action {
+			case "randomize":
+				rnd := rand.Float32() - 0.5
+				if rnd < 0 {
+					rnd = 0
+				}
+				nrn.Act = rnd
+			case "noise":
+				act := nrn.Act + kp.Amp*(2*rand.Float32()-1)
+				if act < 0 {
+					act = 0
+				} else if act > 1 {
+					act = 1
+				}
+				nrn.Act = act
+			}
+		}
+	}
+	kp.ItemCycs = 0
+}
+
+// SleepKick checks the kick policy at sleep cycle cyc of block, and kicks the
+// network and logs the kick if a trigger fires.  Not during sleep learning
+// trials.
+func (ss *Sim) SleepKick(block string, cyc int, lays []string) {
+	if ss.PlusPhase || ss.MinusPhase {
+		return
+	}
+	trig := ss.Kick.Trigger(ss.Net, cyc, ss.AvgLaySim, lays)
+	if trig == "" {
+		return
+	}
+	ss.Kick.Kick(ss.Net)
+	ss.LogKick(ss.KickLog, block, cyc, trig)
+}
+
+// LogKick adds a kick at sleep cycle cyc of block, fired by trigger trig, to the KickLog
+func (ss *Sim) LogKick(dt *etable.Table, block string, cyc int, trig string) {
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	lays := "all"
+	if len(ss.Kick.Lays) > 0 {
+		lays = strings.Join(ss.Kick.Lays, ":")
+	}
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Block", row, block)
+	dt.SetCellFloat("Cycle", row, float64(cyc))
+	dt.SetCellString("Trigger", row, trig)
+	dt.SetCellFloat("AvgLaySim", row, ss.AvgLaySim)
+	dt.SetCellString("Action", row, ss.Kick.Action)
+	dt.SetCellString("Lays", row, lays)
+
+	ss.KickFile.WriteRow(dt, row)
+}
+
+// ConfigKickLog configures the KickLog: one row per kick
+func (ss *Sim) ConfigKickLog(dt *etable.Table) {
+	dt.SetMetaData("name", "KickLog")
+	dt.SetMetaData("desc", "Record of each attractor escape kick during sleep")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	dt.SetFromSchema(etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Block", etensor.STRING, nil, nil},
+		{"Cycle", etensor.INT64, nil, nil},
+		{"Trigger", etensor.STRING, nil, nil},
+		{"AvgLaySim", etensor.FLOAT64, nil, nil},
+		{"Action", etensor.STRING, nil, nil},
+		{"Lays", etensor.STRING, nil, nil},
+	}, 0)
+}
@@ -0,0 +1,231 @@
+// Multi-session protocols (-protocol): once the network reaches the sleep
+// criterion and has had its pre-sleep test, the run goes through a list of
+// steps -- sleep bouts, further wake training and tests -- and each test is
+// logged in the SessLog, tracking retention and sleep benefit across the
+// sessions of the run.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/emer/emergent/env"
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+)
+
+// DefProtocol is the default protocol: one night of DefSleepPairs SWS / REM
+// block pairs
+const DefProtocol = "sleep"
+
+// DefStrucEpcs is the default number of epochs of a structured sleep step
+const DefStrucEpcs = 1
+
+// DefSleepPairs is the default number of SWS / REM block pairs of a sleep step
+const DefSleepPairs = 5
+
+// SleepBlkCycs is the number of cycles of each SWS or REM block
+const SleepBlkCycs = 10000
+
+// ProtoStep is one step of a protocol
+type ProtoStep struct {
+	Kind string `desc:"sleep: a sleep bout of N SWS / REM block pairs, each block followed by a test; struc: N epochs of structured sleep; wake: N epochs of wake training; test: test and log the session"`
+	N    int    `desc:"SWS / REM block pairs of a sleep step, epochs of a struc or wake step"`
+}
+
+// String returns the step as it is written in a protocol spec
+func (ps ProtoStep) String() string {
+	if ps.Kind == "test" {
+		return ps.Kind
+	}
+	return fmt.Sprintf("%v:%d", ps.Kind, ps.N)
+}
+
+// ParseProtocol parses a protocol spec: a comma-separated list of steps,
+// each of which is sleep[:<pairs>] (default DefSleepPairs), struc[:<epochs>]
+// (default DefStrucEpcs), wake:<epochs> or test, e.g.
+// sleep:1,wake:5,sleep,wake:5,test
+func ParseProtocol(spec string) ([]ProtoStep, error) {
+	var steps []ProtoStep
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		args := strings.Split(s, ":")
+		st := ProtoStep{Kind: args[0]}
+		switch {
+		case st.Kind == "test" && len(args) == 1:
+		case st.Kind == "sleep" && len(args) == 1:
+			st.N = DefSleepPairs
+		case st.Kind == "struc" && len(args) == 1:
+			st.N = DefStrucEpcs
+		case (st.Kind == "sleep" || st.Kind == "struc" || st.Kind == "wake") && len(args) == 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("protocol step %v: %v", s, err)
+			}
+			st.N = n
+		default:
+			return nil, fmt.Errorf("invalid protocol step: %v (must be sleep[:<pairs>], struc[:<epochs>], wake:<epochs> or test)", s)
+		}
+		if st.Kind != "test" && st.N < 1 {
+			return nil, fmt.Errorf("protocol step %v: must be at least 1", s)
+		}
+		steps = append(steps, st)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty protocol")
+	}
+	return steps, nil
+}
+
+// RunProtocol runs the steps of the Protocol under the current sleep
+// condition, once all the environments have been learned and the network has
+// had its pre-sleep test.  Each test that
+// ends a session -- test steps, and the test after the last block of sleep
+// steps -- is a row of the SessLog.  Struc steps, like wake steps, are not
+// followed by a test: their trials count as sleep trials of the next session.
+func (ss *Sim) RunProtocol() {
+	ss.Sess = 0
+	ss.TotSlpTrls = 0
+	ss.LogSess(ss.SessLog, "Criterion", nil, 0)
+	var since []string
+	slpTrls := 0
+	for si, st := range ss.Protocol {
+		ss.Sess = si + 1
+		if st.Kind != "test" {
+			since = append(since, st.String())
+		}
+		switch st.Kind {
+		case "sleep":
+			slpTrls += ss.SleepBlocks(st.N)
+		case "struc":
+			slpTrls += ss.StrucSleepEpochs(st.N)
+			continue
+		case "wake":
+			ss.WakeEpochs(st.N)
+			continue
+		case "test":
+			ss.SlpTestAll()
+		}
+		ss.LogSess(ss.SessLog, st.String(), since, slpTrls)
+		since = nil
+		slpTrls = 0
+	}
+	ss.Milestone("PostSleep", ss.CondLabel("PostSleep"))
+}
+
+// WakeEpochs runs n epochs of wake training on the current training
+// environment, logging each epoch, as a step of the protocol.  The first
+// trial of the current epoch is pending when it is called (TrainTrial returns
+// right after the epoch counter changes), as it is when it returns.  The
+// wake params are those restored by the ParamScope of the last sleep block,
+// with the hippocampus configuration of the environment reapplied.
+func (ss *Sim) WakeEpochs(n int) {
+	ss.EnvCfgd = false // sleep and the tests around it turn layers on and off
+	ss.ConfigEnvHip()
+	ss.StartWtChg(ss.CondLabel(fmt.Sprintf("Wake-%d", ss.Sess)))
+	for done := 0; done < n; {
+		ss.WakeTrial()
+		ss.TrainEnv.Step()
+		if _, _, chg := ss.TrainEnv.Counter(env.Epoch); chg {
+			ss.LogTrnEpc(ss.TrnEpcLog)
+			done++
+		}
+	}
+}
+
+// SessStatNms returns the test results tracked across sessions in the
+// SessLog: the percent correct (<env>PctCor) of each environment, then its
+// SSE (<env>SSE)
+func (ss *Sim) SessStatNms() []string {
+	var nms []string
+	for _, en := range ss.Envs {
+		nms = append(nms, en.Name+"PctCor")
+	}
+	for _, en := range ss.Envs {
+		nms = append(nms, en.Name+"SSE")
+	}
+	return nms
+}
+
+// SessStat returns the named result (one of SessStatNms) of the last test
+func (ss *Sim) SessStat(nm string) float64 {
+	for _, en := range ss.Envs {
+		switch nm {
+		case en.Name + "PctCor":
+			return en.TestCor
+		case en.Name + "SSE":
+			return en.TestSSE
+		}
+	}
+	return math.NaN()
+}
+
+// LogSess adds the results of the test that ends session ss.Sess to the
+// SessLog: step is the step that ended with the test, since the steps run
+// since the previous test and slpTrls the number of sleep learning trials in
+// those steps.  Ret is the change of each result since the criterion
+// (pre-sleep) test of the sleep condition -- the retention -- and Chg since
+// the previous test -- the sleep benefit, when the steps since include sleep.
+func (ss *Sim) LogSess(dt *etable.Table, step string, since []string, slpTrls int) {
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Cond", row, ss.SlpCond.Name)
+	dt.SetCellFloat("Session", row, float64(ss.Sess))
+	dt.SetCellString("Step", row, step)
+	dt.SetCellString("Since", row, strings.Join(since, " "))
+	dt.SetCellFloat("SlpTrls", row, float64(slpTrls))
+	crit := row // criterion row of the condition
+	for crit > 0 && dt.CellString("Step", crit) != "Criterion" {
+		crit--
+	}
+	for _, cn := range ss.SessStatNms() {
+		val := ss.SessStat(cn)
+		ret, chg := 0.0, math.NaN()
+		if row > crit {
+			ret = val - dt.CellFloat(cn, crit)
+			chg = val - dt.CellFloat(cn, row-1)
+		}
+		dt.SetCellFloat(cn, row, val)
+		dt.SetCellFloat(cn+" Ret", row, ret)
+		dt.SetCellFloat(cn+" Chg", row, chg)
+	}
+
+	ss.SessFile.WriteRow(dt, row)
+}
+
+// ConfigSessLog configures the SessLog: one row per test that ends a
+// session of the protocol, starting with the criterion (pre-sleep) test, for
+// each sleep condition
+func (ss *Sim) ConfigSessLog(dt *etable.Table) {
+	dt.SetMetaData("name", "SessLog")
+	dt.SetMetaData("desc", "Test results at the end of each session of the protocol")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	sch := etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Cond", etensor.STRING, nil, nil},
+		{"Session", etensor.INT64, nil, nil},
+		{"Step", etensor.STRING, nil, nil},
+		{"Since", etensor.STRING, nil, nil},
+		{"SlpTrls", etensor.INT64, nil, nil},
+	}
+	for _, cn := range ss.SessStatNms() {
+		sch = append(sch, etable.Schema{
+			{cn, etensor.FLOAT64, nil, nil},
+			{cn + " Ret", etensor.FLOAT64, nil, nil},
+			{cn + " Chg", etensor.FLOAT64, nil, nil},
+		}...)
+	}
+	dt.SetFromSchema(sch, 0)
+}
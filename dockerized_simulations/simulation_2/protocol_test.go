@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// protoString returns steps as a normalized protocol spec
+func protoString(steps []ProtoStep) string {
+	var ss []string
+	for _, st := range steps {
+		ss = append(ss, st.String())
+	}
+	return strings.Join(ss, ",")
+}
+
+func TestParseProtocol(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string // normalized steps -- empty if the spec is rejected
+	}{
+		{DefProtocol, fmt.Sprintf("sleep:%d", DefSleepPairs)},
+		{"sleep:1,wake:5,sleep,wake:5,test", fmt.Sprintf("sleep:1,wake:5,sleep:%d,wake:5,test", DefSleepPairs)},
+		{" struc , struc:3 ,test,", fmt.Sprintf("struc:%d,struc:3,test", DefStrucEpcs)},
+		{"", ""},
+		{",", ""},
+		{"nap", ""},
+		{"wake", ""},
+		{"test:1", ""},
+		{"sleep:0", ""},
+		{"wake:-1", ""},
+		{"struc:x", ""},
+		{"sleep:1:2", ""},
+	}
+	for _, tt := range tests {
+		steps, err := ParseProtocol(tt.spec)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("ParseProtocol(%q) = %v, want an error", tt.spec, protoString(steps))
+		case tt.want != "" && err != nil:
+			t.Errorf("ParseProtocol(%q): %v", tt.spec, err)
+		case tt.want != "" && protoString(steps) != tt.want:
+			t.Errorf("ParseProtocol(%q) = %v, want %v", tt.spec, protoString(steps), tt.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Cross-run statistical report of the sleep benefit (-report): compares
+// the test results after each sleep block with those right before sleep,
+// for every run of a batch, and those of sleep with its control conditions
+// (-slpconds).
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emer/etable/etable"
+	"github.com/goki/gi/gi"
+)
+
+// TstEpcEnvs returns the names of the environments tested in a TstEpcLog:
+// those of its <env> Err columns, in order
+func TstEpcEnvs(dt *etable.Table) []string {
+	var envs []string
+	for _, cn := range dt.ColNames {
+		if strings.HasSuffix(cn, " Err") {
+			envs = append(envs, strings.TrimSuffix(cn, " Err"))
+		}
+	}
+	return envs
+}
+
+// EnvResCols returns the test results compared by the report: the percent
+// correct of each of the environments envs, then its SSE
+func EnvResCols(envs []string) []string {
+	var cols []string
+	for _, en := range envs {
+		cols = append(cols, en+" PctCor")
+	}
+	for _, en := range envs {
+		cols = append(cols, en+" SSE")
+	}
+	return cols
+}
+
+// RunBlks holds one run's test results right before sleep and after each
+// sleep block of each sleep condition, in the order of EnvResCols
+type RunBlks struct {
+	Run    int
+	Pre    []float64                    `desc:"results of the last test before sleep"`
+	Blks   map[string]map[int][]float64 `desc:"results of the test after each sleep block, by sleep condition and block number"`
+	Stages map[int]string               `desc:"sleep stage of each block"`
+}
+
+// EnvRes returns the EnvResCols results of environments envs of the given
+// row of a TstEpcLog
+func EnvRes(dt *etable.Table, row int, envs []string) []float64 {
+	var res []float64
+	for _, en := range envs {
+		res = append(res, 1-dt.CellFloat(en+" Err", row))
+	}
+	for _, en := range envs {
+		res = append(res, dt.CellFloat(en+" SSE", row))
+	}
+	return res
+}
+
+// OpenTstEpcRuns reads the per-run pre-sleep and sleep-block results from a
+// test epoch log file saved with -tstepclog, and returns them with the names
+// of the environments tested.  The pre-sleep result is the last test with
+// SlpBlk == 0 outside the protocol in each run.  Logs without the Cond column
+// are of the sleep condition only.
+func OpenTstEpcRuns(fnm string) ([]*RunBlks, []string, error) {
+	delim := etable.Tab
+	if filepath.Ext(fnm) == ".csv" {
+		delim = etable.Comma
+	}
+	dt := &etable.Table{}
+	if err := dt.OpenCSV(gi.FileName(fnm), delim); err != nil {
+		return nil, nil, err
+	}
+	envs := TstEpcEnvs(dt)
+	if len(envs) == 0 {
+		return nil, nil, fmt.Errorf("%v: no <env> Err columns", fnm)
+	}
+	for _, cn := range []string{"Run", "SlpBlk", "PostSlpStg"} {
+		if _, err := dt.ColByNameTry(cn); err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", fnm, err)
+		}
+	}
+	for _, en := range envs {
+		if _, err := dt.ColByNameTry(en + " SSE"); err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", fnm, err)
+		}
+	}
+	_, err := dt.ColByNameTry("Cond")
+	hasCond := err == nil
+	var runs []*RunBlks
+	var rb *RunBlks
+	for row := 0; row < dt.Rows; row++ {
+		run := int(dt.CellFloat("Run", row))
+		if rb == nil || rb.Run != run {
+			rb = &RunBlks{Run: run, Blks: map[string]map[int][]float64{}, Stages: map[int]string{}}
+			runs = append(runs, rb)
+		}
+		cond := ""
+		if hasCond {
+			cond = dt.CellString("Cond", row)
+		}
+		blk := int(dt.CellFloat("SlpBlk", row))
+		if blk == 0 {
+			if cond == "" {
+				rb.Pre = EnvRes(dt, row, envs)
+			}
+			continue
+		}
+		if cond == "" {
+			cond = SleepCond.Name
+		}
+		if rb.Blks[cond] == nil {
+			rb.Blks[cond] = map[int][]float64{}
+		}
+		rb.Blks[cond][blk] = EnvRes(dt, row, envs)
+		rb.Stages[blk] = dt.CellString("PostSlpStg", row)
+	}
+	return runs, envs, nil
+}
+
+// Report writes report.md and report.tsv, prefixed with the batch ID (see
+// OutputLayout), to batchDir, from its test epoch log(s): for each sleep condition and block, the percent correct and SSE of
+// each environment after the block vs. right before sleep, and the sleep
+// benefit (block - pre) of each later environment vs the first (Env1); and
+// for each block, the benefit of sleep vs each other condition.
+func (ss *Sim) Report(batchDir string) error {
+	fnms, _ := filepath.Glob(filepath.Join(batchDir, "*_tstepc.tsv"))
+	csvs, _ := filepath.Glob(filepath.Join(batchDir, "*_tstepc.csv"))
+	fnms = append(fnms, csvs...)
+	if len(fnms) == 0 {
+		return fmt.Errorf("report: no test epoch log (*_tstepc.tsv or .csv) in %v -- run with -tstepclog", batchDir)
+	}
+	var runs []*RunBlks
+	var envs []string
+	for _, fnm := range fnms {
+		rs, fenvs, err := OpenTstEpcRuns(fnm)
+		if err != nil {
+			return err
+		}
+		if envs == nil {
+			envs = fenvs
+		} else if strings.Join(fenvs, ",") != strings.Join(envs, ",") {
+			return fmt.Errorf("report: %v: environments %v differ from the %v of the other logs", fnm, strings.Join(fenvs, ", "), strings.Join(envs, ", "))
+		}
+		runs = append(runs, rs...)
+	}
+	cols := EnvResCols(envs)
+	nenv := len(envs)
+	rnd := rand.New(rand.NewSource(1)) // fixed, so the same batch gives the same report
+
+	stages := map[int]string{}
+	var conds []string
+	seen := map[string]bool{}
+	nslept := 0
+	for _, rb := range runs {
+		for blk, stg := range rb.Stages {
+			stages[blk] = stg
+		}
+		if len(rb.Blks) > 0 {
+			nslept++
+		}
+		for cond := range rb.Blks {
+			if !seen[cond] {
+				seen[cond] = true
+				conds = append(conds, cond)
+			}
+		}
+	}
+	sort.Slice(conds, func(i, j int) bool { // sleep first
+		if (conds[i] == SleepCond.Name) != (conds[j] == SleepCond.Name) {
+			return conds[i] == SleepCond.Name
+		}
+		return conds[i] < conds[j]
+	})
+	var blks []int
+	for blk := range stages {
+		blks = append(blks, blk)
+	}
+	sort.Ints(blks)
+
+	// pre and block values of result ci of each run -- NaN if missing
+	vals := func(cond string, blk, ci int) (pre, post []float64) {
+		for _, rb := range runs {
+			pv, bv := math.NaN(), math.NaN()
+			if b, ok := rb.Blks[cond][blk]; ok && rb.Pre != nil {
+				pv, bv = rb.Pre[ci], b[ci]
+			}
+			pre = append(pre, pv)
+			post = append(post, bv)
+		}
+		return
+	}
+	benefit := func(cond string, blk, ci int) []float64 {
+		pre, post := vals(cond, blk, ci)
+		for i := range pre {
+			post[i] -= pre[i]
+		}
+		return post
+	}
+
+	var secs []ReportSection
+	for _, cond := range conds {
+		sfx := ""
+		if len(conds) > 1 || cond != SleepCond.Name {
+			sfx = ", " + cond
+		}
+		for _, blk := range blks {
+			sec := ReportSection{Title: fmt.Sprintf("Sleep block %d (%v)%v", blk, stages[blk], sfx)}
+			for ci, cn := range cols {
+				pre, post := vals(cond, blk, ci)
+				sec.Rows = append(sec.Rows, PairedStats(cn+": after block vs pre-sleep", pre, post, rnd))
+			}
+			for ci := 0; ci < len(cols); ci += nenv {
+				cn := strings.TrimPrefix(cols[ci], envs[0]+" ")
+				for ei := 1; ei < nenv; ei++ {
+					sec.Rows = append(sec.Rows, PairedStats(fmt.Sprintf("%v benefit: Env%d (%v) vs Env1 (%v)", cn, ei+1, envs[ei], envs[0]),
+						benefit(cond, blk, ci), benefit(cond, blk, ci+ei), rnd))
+				}
+			}
+			secs = append(secs, sec)
+		}
+	}
+	if seen[SleepCond.Name] {
+		for _, cond := range conds {
+			if cond == SleepCond.Name {
+				continue
+			}
+			for _, blk := range blks {
+				sec := ReportSection{Title: fmt.Sprintf("Sleep vs %v, block %d (%v)", cond, blk, stages[blk])}
+				for ci, cn := range cols {
+					sec.Rows = append(sec.Rows, PairedStats(cn+" benefit", benefit(cond, blk, ci), benefit(SleepCond.Name, blk, ci), rnd))
+				}
+				secs = append(secs, sec)
+			}
+		}
+	}
+
+	notes := []string{
+		fmt.Sprintf("Batch: `%v` -- %d runs, %d with sleep blocks.", batchDir, len(runs), nslept),
+		"Pre-sleep is the last test before the first sleep block of each run. Benefit is (after block - pre-sleep). " +
+			"In the after vs pre rows, A = pre-sleep and B = after the block; in the env benefit rows, A = Env1 (" + envs[0] + ") and B = the later environment; " +
+			"in the sleep vs control rows, A = the control condition and B = sleep.",
+		fmt.Sprintf("Differences are B - A, paired by run. 95%% CI: percentile bootstrap of the mean difference (%d resamples). "+
+			"t: paired t test. W+: Wilcoxon signed-rank test (normal approximation). dz: mean difference / SD of the differences.", NBoot),
+	}
+	out := BatchOutput(batchDir)
+	outs, err := WriteReport(out.BatchFile("report"), "Sleep benefit: simulation_2", notes, secs)
+	if err != nil {
+		return err
+	}
+	for _, fnm := range outs {
+		fmt.Printf("Saved report to: %v\n", fnm)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Per-block sleep cycle logs (-slpcyclog): the SlpCycLog is overwritten by
+// each sleep block, so each block's is also streamed to its own file,
+// optionally downsampled, for the stability dynamics across sleep.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/emer/etable/etable"
+)
+
+// StartSlpCycLog opens the sleep cycle log file of sleep block block, if
+// the per-block logs are saved (SlpCycEvery > 0): slpcyc_<block>_sess<N>
+// in the run's slp_cyc directory, N being the session of the protocol
+func (ss *Sim) StartSlpCycLog(block string) {
+	if ss.SlpCycEvery <= 0 {
+		return
+	}
+	fnm := ss.Out.RunFile(ss.TrainEnv.Run.Cur, "slp_cyc", fmt.Sprintf("slpcyc_%v_sess%d%v", block, ss.Sess, LogExt(ss.LogDelim)))
+	lf, err := CreateLogFile(fnm, ss.LogDelim)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ss.Manifest.AddOutput(fnm)
+	ss.SlpCycFile = lf
+}
+
+// EndSlpCycLog closes the sleep cycle log file of the block
+func (ss *Sim) EndSlpCycLog() {
+	ss.SlpCycFile.Close()
+	ss.SlpCycFile = nil
+}
+
+// WriteSlpCyc writes the row of the SlpCycLog of cycle cyc to the sleep
+// cycle log file of the block, every SlpCycEvery cycles
+func (ss *Sim) WriteSlpCyc(dt *etable.Table, row, cyc int) {
+	if ss.SlpCycEvery > 0 && cyc%ss.SlpCycEvery == 0 {
+		ss.SlpCycFile.WriteRow(dt, row)
+	}
+}
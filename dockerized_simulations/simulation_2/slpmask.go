@@ -0,0 +1,146 @@
+// Sleep-time learning mask (-slpmask): which projections learn during each
+// sleep stage, and at which learning rate, chosen by params selector.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emer/emergent/params"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// SlpMaskStages are the sleep stages of the -slpmask spec
+var SlpMaskStages = []string{"SWS", "REM", "Struc"}
+
+// DefSlpMask is the default -slpmask: the Input / Output <-> CTX projections
+// learn at a faster rate, and the projections into and within the
+// hippocampus stop learning, except Input -> CA3
+const DefSlpMask = ".PerCTXPrjn=0.05,#InputToDG=off,#CA3ToCA3=off,#CA3TopCA1=off,#InputTodCA1=off,#dCA1ToOutput=off,#pCA1ToOutput=off,#OutputTopCA1=off,#OutputTodCA1=off"
+
+// SlpMaskEntry sets the learning of the projections matched by a params
+// selector during a sleep stage
+type SlpMaskEntry struct {
+	Stage string  `desc:"sleep stage of the entry, empty for all"`
+	Sel   string  `desc:"projections of the entry, as a params selector: .<class>, #<name> or Prjn (all)"`
+	Learn bool    `desc:"whether the projections learn"`
+	Lrate float32 `desc:"learning rate of the projections, 0 to leave it unchanged"`
+}
+
+// Spec returns the setting of the entry as in -slpmask
+func (me *SlpMaskEntry) Spec() string {
+	switch {
+	case !me.Learn:
+		return "off"
+	case me.Lrate == 0:
+		return "on"
+	}
+	return strconv.FormatFloat(float64(me.Lrate), 'g', -1, 32)
+}
+
+// SlpMask is the sleep-time learning mask: for each projection, the last
+// entry of the current stage that matches it applies, and the projections
+// none matches keep their wake learning.  The parameter scope of the sleep
+// phase restores the wake learning.
+type SlpMask struct {
+	Entries []SlpMaskEntry `desc:"entries of the mask, in order"`
+}
+
+// Set sets the mask from spec: a comma-separated list of
+// [<stage>:]<selector>=<setting>, where setting is off, on or the learning
+// rate (learning on), and the entries without a stage apply to all stages
+func (sm *SlpMask) Set(spec string) error {
+	sm.Entries = nil
+	for _, ent := range strings.Split(spec, ",") {
+		ent = strings.TrimSpace(ent)
+		if ent == "" {
+			continue
+		}
+		eq := strings.Index(ent, "=")
+		if eq < 0 {
+			return fmt.Errorf("sleep learning mask %v: must be [<stage>:]<selector>=<setting>", ent)
+		}
+		me := SlpMaskEntry{Sel: strings.TrimSpace(ent[:eq]), Learn: true}
+		if col := strings.Index(me.Sel, ":"); col >= 0 {
+			me.Stage, me.Sel = strings.TrimSpace(me.Sel[:col]), strings.TrimSpace(me.Sel[col+1:])
+			found := false
+			for _, stg := range SlpMaskStages {
+				found = found || stg == me.Stage
+			}
+			if !found {
+				return fmt.Errorf("sleep learning mask %v: unknown stage %v (must be one of %v)", ent, me.Stage, SlpMaskStages)
+			}
+		}
+		if me.Sel == "" {
+			return fmt.Errorf("sleep learning mask %v: empty selector", ent)
+		}
+		switch val := strings.TrimSpace(ent[eq+1:]); val {
+		case "off":
+			me.Learn = false
+		case "on":
+		default:
+			lrate, err := strconv.ParseFloat(val, 32)
+			if err != nil || lrate <= 0 {
+				return fmt.Errorf("sleep learning mask %v: setting must be off, on or a learning rate > 0", ent)
+			}
+			me.Lrate = float32(lrate)
+		}
+		sm.Entries = append(sm.Entries, me)
+	}
+	return nil
+}
+
+// For returns the entry of stage that applies to projection pj, nil if none
+func (sm *SlpMask) For(pj *leabra.Prjn, stage string) *SlpMaskEntry {
+	for i := len(sm.Entries) - 1; i >= 0; i-- {
+		me := &sm.Entries[i]
+		if me.Stage != "" && me.Stage != stage {
+			continue
+		}
+		if params.SelMatch(me.Sel, pj.Name(), pj.Class(), "Prjn", "Prjn") {
+			return me
+		}
+	}
+	return nil
+}
+
+// Check returns an error if any of the entries selects no projection of net
+func (sm *SlpMask) Check(net *leabra.Network) error {
+	for i := range sm.Entries {
+		me := &sm.Entries[i]
+		found := false
+		for _, ly := range net.Layers {
+			for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+				pj := p.(leabra.LeabraPrjn).AsLeabra()
+				found = found || params.SelMatch(me.Sel, pj.Name(), pj.Class(), "Prjn", "Prjn")
+			}
+		}
+		if !found {
+			return fmt.Errorf("sleep learning mask %v=%v: no projection matches %v", me.Sel, me.Spec(), me.Sel)
+		}
+	}
+	return nil
+}
+
+// Apply applies the mask of stage to the projections of net.  Returns the
+// setting of each projection it changed, as <projection>=<setting>.
+func (sm *SlpMask) Apply(net *leabra.Network, stage string) []string {
+	var sets []string
+	for _, ly := range net.Layers {
+		for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+			pj := p.(leabra.LeabraPrjn).AsLeabra()
+			me := sm.For(pj, stage)
+			if me == nil {
+				continue
+			}
+			pj.Learn.Learn = me.Learn
+			if me.Lrate > 0 {
+				pj.Learn.Lrate = me.Lrate
+			}
+			sets = append(sets, pj.Name()+"="+me.Spec())
+		}
+	}
+	return sets
+}
@@ -0,0 +1,290 @@
+// Network stability metrics for sleep: the stability of the network at each
+// sleep cycle (AvgLaySim) is what marks the plus and minus phases of sleep
+// learning.  The metric can be chosen per sleep stage (-stability).
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// SleepStages are the sleep stages that can each have their own stability metric
+var SleepStages = []string{"SWS", "REM"}
+
+// DefStabActThr is the default StabActThr
+const DefStabActThr = 1
+
+// StabilityLays returns the layers whose stability is measured during sleep
+// stage: the cortical layers during REM, when the hippocampus is off, and
+// all layers during SWS
+func (ss *Sim) StabilityLays(stage string) []string {
+	if stage == "REM" {
+		return []string{"Input", "Output", "CTX"}
+	}
+	return []string{"Input", "Output", "CTX", "DG", "CA3", "pCA1", "dCA1"}
+}
+
+// StabilityMetric measures the stability of the network at the current sleep
+// cycle, from 0 (changing) to 1 (stable)
+type StabilityMetric interface {
+	// Name returns the spec of the metric, as parsed by NewStabilityMetric
+	Name() string
+
+	// Reset resets any state kept across cycles, at the start of a sleep block
+	Reset()
+
+	// Stability returns the stability of layers lays of net at the current cycle
+	Stability(net *leabra.Network, lays []string) float64
+}
+
+// LaySim returns the cycle to cycle similarity (Sim) of layer ly, with NaN as
+// 0, and 0 if the total activity of the layer is below actThr
+func LaySim(ly *leabra.Layer, actThr float32) float64 {
+	sim := ly.Sim
+	if math.IsNaN(sim) {
+		return 0
+	}
+	if actThr > 0 {
+		actsum := float32(0)
+		for ni := range ly.Neurons {
+			actsum += ly.Neurons[ni].Act
+		}
+		if actsum < actThr {
+			return 0
+		}
+	}
+	return sim
+}
+
+// MeanSim is the mean LaySim over the layers
+type MeanSim struct {
+	ActThr float32 `desc:"layers with less total activity have a LaySim of 0"`
+}
+
+func (ms *MeanSim) Name() string { return "mean" }
+func (ms *MeanSim) Reset()       {}
+
+func (ms *MeanSim) Stability(net *leabra.Network, lays []string) float64 {
+	sum := 0.0
+	for _, lnm := range lays {
+		sum += LaySim(net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra(), ms.ActThr)
+	}
+	return sum / float64(len(lays))
+}
+
+// WtMeanSim is the weighted mean LaySim over the layers
+type WtMeanSim struct {
+	ActThr float32            `desc:"layers with less total activity have a LaySim of 0"`
+	Wts    map[string]float64 `desc:"weight of each layer -- 1 for layers not listed"`
+}
+
+func (ws *WtMeanSim) Name() string {
+	var wts []string
+	for lnm, wt := range ws.Wts {
+		wts = append(wts, lnm+"*"+strconv.FormatFloat(wt, 'g', -1, 64))
+	}
+	sort.Strings(wts)
+	return "wmean:" + strings.Join(wts, ":")
+}
+
+func (ws *WtMeanSim) Reset() {}
+
+func (ws *WtMeanSim) Stability(net *leabra.Network, lays []string) float64 {
+	sum, wsum := 0.0, 0.0
+	for _, lnm := range lays {
+		wt, ok := ws.Wts[lnm]
+		if !ok {
+			wt = 1
+		}
+		sum += wt * LaySim(net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra(), ws.ActThr)
+		wsum += wt
+	}
+	if wsum == 0 {
+		return 0
+	}
+	return sum / wsum
+}
+
+// MinSim is the minimum LaySim across the layers: the network is only as
+// stable as its least stable layer
+type MinSim struct {
+	ActThr float32 `desc:"layers with less total activity have a LaySim of 0"`
+}
+
+func (ms *MinSim) Name() string { return "min" }
+func (ms *MinSim) Reset()       {}
+
+func (ms *MinSim) Stability(net *leabra.Network, lays []string) float64 {
+	min := 0.0
+	for li, lnm := range lays {
+		sim := LaySim(net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra(), ms.ActThr)
+		if li == 0 || sim < min {
+			min = sim
+		}
+	}
+	return min
+}
+
+// CosSim is the cosine between the activity vectors of the whole network (all
+// the layers together) of consecutive cycles -- 0 on the first cycle after a
+// Reset
+type CosSim struct {
+	Prv []float32 `desc:"activities of the previous cycle"`
+	Cur []float32 `desc:"activities of the current cycle"`
+}
+
+func (cs *CosSim) Name() string { return "cos" }
+
+func (cs *CosSim) Reset() {
+	cs.Prv = cs.Prv[:0]
+}
+
+func (cs *CosSim) Stability(net *leabra.Network, lays []string) float64 {
+	cs.Cur = cs.Cur[:0]
+	for _, lnm := range lays {
+		ly := net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra()
+		for ni := range ly.Neurons {
+			cs.Cur = append(cs.Cur, ly.Neurons[ni].Act)
+		}
+	}
+	cos := 0.0
+	if len(cs.Prv) == len(cs.Cur) {
+		ab, aa, bb := 0.0, 0.0, 0.0
+		for i, a := range cs.Prv {
+			b := cs.Cur[i]
+			ab += float64(a * b)
+			aa += float64(a * a)
+			bb += float64(b * b)
+		}
+		if aa > 0 && bb > 0 {
+			cos = ab / math.Sqrt(aa*bb)
+		}
+	}
+	cs.Prv, cs.Cur = cs.Cur, cs.Prv
+	return cos
+}
+
+// WinSim is the average of another metric over the last N cycles
+type WinSim struct {
+	N      int             `desc:"number of cycles averaged"`
+	Metric StabilityMetric `desc:"metric that is averaged"`
+	Vals   []float64       `desc:"values of the last N cycles, as a ring buffer"`
+	Idx    int             `desc:"index of the next value in Vals"`
+}
+
+func (ws *WinSim) Name() string { return fmt.Sprintf("win:%d:%v", ws.N, ws.Metric.Name()) }
+
+func (ws *WinSim) Reset() {
+	ws.Metric.Reset()
+	ws.Vals = ws.Vals[:0]
+	ws.Idx = 0
+}
+
+func (ws *WinSim) Stability(net *leabra.Network, lays []string) float64 {
+	val := ws.Metric.Stability(net, lays)
+	if len(ws.Vals) < ws.N {
+		ws.Vals = append(ws.Vals, val)
+	} else {
+		ws.Vals[ws.Idx] = val
+	}
+	ws.Idx = (ws.Idx + 1) % ws.N
+	sum := 0.0
+	for _, v := range ws.Vals {
+		sum += v
+	}
+	return sum / float64(len(ws.Vals))
+}
+
+// NewStabilityMetric returns the stability metric of spec, one of:
+// mean, wmean:<layer>*<weight>:..., min, cos, or win:<N>:<metric> (the
+// average of another metric over the last N cycles).  actThr is the
+// activity threshold of the per-layer metrics (see LaySim).
+func NewStabilityMetric(spec string, actThr float32) (StabilityMetric, error) {
+	args := strings.Split(strings.TrimSpace(spec), ":")
+	switch args[0] {
+	case "mean":
+		if len(args) == 1 {
+			return &MeanSim{ActThr: actThr}, nil
+		}
+	case "min":
+		if len(args) == 1 {
+			return &MinSim{ActThr: actThr}, nil
+		}
+	case "cos":
+		if len(args) == 1 {
+			return &CosSim{}, nil
+		}
+	case "wmean":
+		ws := &WtMeanSim{ActThr: actThr, Wts: map[string]float64{}}
+		for _, a := range args[1:] {
+			lw := strings.Split(a, "*")
+			if len(lw) != 2 {
+				return nil, fmt.Errorf("stability metric %v: layer weight must be <layer>*<weight>: %v", spec, a)
+			}
+			wt, err := strconv.ParseFloat(lw[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("stability metric %v: %v", spec, err)
+			}
+			ws.Wts[lw[0]] = wt
+		}
+		return ws, nil
+	case "win":
+		if len(args) < 3 {
+			break
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("stability metric %v: window must be a number of cycles >= 1", spec)
+		}
+		m, err := NewStabilityMetric(strings.Join(args[2:], ":"), actThr)
+		if err != nil {
+			return nil, err
+		}
+		return &WinSim{N: n, Metric: m}, nil
+	}
+	return nil, fmt.Errorf("invalid stability metric: %v (must be mean, wmean:<layer>*<weight>:..., min, cos or win:<N>:<metric>)", spec)
+}
+
+// SetStability sets the stability metric of each of the SleepStages from
+// spec: a comma-separated list of <stage>=<metric>, or a single <metric> for
+// all stages.  Stages that are not listed use mean.
+func (ss *Sim) SetStability(spec string) error {
+	specs := map[string]string{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		stage := ""
+		if eq := strings.Index(s, "="); eq >= 0 {
+			stage, s = s[:eq], s[eq+1:]
+			if !HasMilestone(SleepStages, stage) {
+				return fmt.Errorf("unknown sleep stage: %v (must be one of %v)", stage, strings.Join(SleepStages, ", "))
+			}
+		}
+		for _, st := range SleepStages {
+			if stage == "" || strings.EqualFold(st, stage) {
+				specs[st] = s
+			}
+		}
+	}
+	ss.Stability = map[string]StabilityMetric{}
+	for _, st := range SleepStages {
+		s, ok := specs[st]
+		if !ok {
+			s = "mean"
+		}
+		m, err := NewStabilityMetric(s, ss.StabActThr)
+		if err != nil {
+			return err
+		}
+		ss.Stability[st] = m
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Paired statistics across runs, used by the -report command to test the
+// effect of sleep: each run contributes one (before, after) pair.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/mathext"
+)
+
+// NBoot is the number of bootstrap resamples for the confidence intervals
+const NBoot = 10000
+
+// Paired is the comparison of paired measurements A and B over runs, e.g.,
+// performance before (A) and after (B) sleep.  Differences are B - A.
+// Pairs with a NaN on either side are dropped.
+type Paired struct {
+	Measure  string  `desc:"what is being compared"`
+	N        int     `desc:"number of pairs"`
+	MeanA    float64 `desc:"mean of A"`
+	MeanB    float64 `desc:"mean of B"`
+	MeanDiff float64 `desc:"mean of the differences B - A"`
+	SDDiff   float64 `desc:"standard deviation of the differences"`
+	CILo     float64 `desc:"lower bound of the bootstrap 95% confidence interval of MeanDiff"`
+	CIHi     float64 `desc:"upper bound of the bootstrap 95% confidence interval of MeanDiff"`
+	T        float64 `desc:"paired t statistic, df = N-1"`
+	TP       float64 `desc:"two-tailed p value of T"`
+	W        float64 `desc:"Wilcoxon signed-rank statistic W+ (sum of the ranks of the positive differences)"`
+	WP       float64 `desc:"two-tailed p value of W, normal approximation with tie and continuity correction"`
+	Dz       float64 `desc:"effect size: Cohen's dz = MeanDiff / SDDiff"`
+}
+
+// PairedStats compares a and b, which must be the same length, using rnd
+// for the bootstrap
+func PairedStats(measure string, a, b []float64, rnd *rand.Rand) Paired {
+	ps := Paired{Measure: measure}
+	var as, bs, ds []float64
+	for i := range a {
+		if math.IsNaN(a[i]) || math.IsNaN(b[i]) {
+			continue
+		}
+		as = append(as, a[i])
+		bs = append(bs, b[i])
+		ds = append(ds, b[i]-a[i])
+	}
+	ps.N = len(ds)
+	ps.MeanA = Mean(as)
+	ps.MeanB = Mean(bs)
+	ps.MeanDiff = Mean(ds)
+	ps.SDDiff = SD(ds)
+	ps.CILo, ps.CIHi = BootCI(ds, NBoot, rnd)
+	ps.T, ps.TP = PairedT(ds)
+	ps.W, ps.WP = Wilcoxon(ds)
+	ps.Dz = ps.MeanDiff / ps.SDDiff
+	if ps.N < 2 {
+		ps.Dz = math.NaN()
+	}
+	return ps
+}
+
+// Mean returns the mean of vals, NaN if empty
+func Mean(vals []float64) float64 {
+	if len(vals) == 0 {
+		return math.NaN()
+	}
+	sum := 0.0
+	for _, v := range vals {
+		sum += v
+	}
+	return sum / float64(len(vals))
+}
+
+// SD returns the sample standard deviation of vals, NaN if fewer than 2
+func SD(vals []float64) float64 {
+	n := len(vals)
+	if n < 2 {
+		return math.NaN()
+	}
+	mn := Mean(vals)
+	ss := 0.0
+	for _, v := range vals {
+		ss += (v - mn) * (v - mn)
+	}
+	return math.Sqrt(ss / float64(n-1))
+}
+
+// BootCI returns the percentile bootstrap 95% confidence interval of the
+// mean of vals, from nboot resamples
+func BootCI(vals []float64, nboot int, rnd *rand.Rand) (lo, hi float64) {
+	n := len(vals)
+	if n < 2 {
+		return math.NaN(), math.NaN()
+	}
+	means := make([]float64, nboot)
+	for bi := range means {
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			sum += vals[rnd.Intn(n)]
+		}
+		means[bi] = sum / float64(n)
+	}
+	sort.Float64s(means)
+	return means[int(0.025*float64(nboot-1))], means[int(0.975*float64(nboot-1))]
+}
+
+// PairedT returns the one-sample t statistic of the differences ds against
+// zero and its two-tailed p value
+func PairedT(ds []float64) (t, p float64) {
+	n := len(ds)
+	if n < 2 {
+		return math.NaN(), math.NaN()
+	}
+	sd := SD(ds)
+	if sd == 0 {
+		return math.NaN(), math.NaN()
+	}
+	t = Mean(ds) / (sd / math.Sqrt(float64(n)))
+	df := float64(n - 1)
+	p = mathext.RegIncBeta(df/2, 0.5, df/(df+t*t))
+	return t, p
+}
+
+// Wilcoxon returns the Wilcoxon signed-rank statistic W+ of the differences
+// ds and its two-tailed p value from the normal approximation, corrected for
+// ties and continuity.  Zero differences are dropped.
+func Wilcoxon(ds []float64) (w, p float64) {
+	var nz []float64
+	for _, d := range ds {
+		if d != 0 {
+			nz = append(nz, d)
+		}
+	}
+	n := len(nz)
+	if n == 0 {
+		return math.NaN(), math.NaN()
+	}
+	sort.Slice(nz, func(i, j int) bool { return math.Abs(nz[i]) < math.Abs(nz[j]) })
+	tiecor := 0.0
+	for i := 0; i < n; {
+		j := i
+		for j < n && math.Abs(nz[j]) == math.Abs(nz[i]) {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // average of ranks i+1 .. j
+		for k := i; k < j; k++ {
+			if nz[k] > 0 {
+				w += rank
+			}
+		}
+		nt := float64(j - i)
+		tiecor += nt*nt*nt - nt
+		i = j
+	}
+	fn := float64(n)
+	mu := fn * (fn + 1) / 4
+	sigma := math.Sqrt(fn*(fn+1)*(2*fn+1)/24 - tiecor/48)
+	if sigma == 0 {
+		return w, math.NaN()
+	}
+	z := math.Max(math.Abs(w-mu)-0.5, 0) / sigma
+	p = math.Erfc(z / math.Sqrt2)
+	return w, p
+}
+
+// ReportSection is a titled group of comparisons in a report
+type ReportSection struct {
+	Title string
+	Rows  []Paired
+}
+
+// WriteReport writes the report sections to fnm + ".md" (Markdown tables,
+// one per section) and fnm + ".tsv" (one row per comparison), returning
+// the names of the files written.
+func WriteReport(fnm, title string, notes []string, secs []ReportSection) ([]string, error) {
+	if err := os.MkdirAll(filepath.Dir(fnm), os.ModePerm); err != nil {
+		return nil, err
+	}
+	var md, tsv strings.Builder
+	fmt.Fprintf(&md, "# %s\n\n", title)
+	for _, nt := range notes {
+		fmt.Fprintf(&md, "%s\n\n", nt)
+	}
+	fmt.Fprintf(&tsv, "Section\tMeasure\tN\tMeanA\tMeanB\tMeanDiff\tSDDiff\tCILo\tCIHi\tT\tTP\tW\tWP\tDz\n")
+	for _, sec := range secs {
+		fmt.Fprintf(&md, "## %s\n\n", sec.Title)
+		fmt.Fprintf(&md, "| Measure | N | Mean A | Mean B | Diff | 95%% CI | t | p (t) | W+ | p (W) | dz |\n")
+		fmt.Fprintf(&md, "| --- | ---: | ---: | ---: | ---: | --- | ---: | ---: | ---: | ---: | ---: |\n")
+		for _, ps := range sec.Rows {
+			fmt.Fprintf(&md, "| %s | %d | %.4g | %.4g | %.4g | [%.4g, %.4g] | %.3f | %.3g | %.1f | %.3g | %.3f |\n",
+				ps.Measure, ps.N, ps.MeanA, ps.MeanB, ps.MeanDiff, ps.CILo, ps.CIHi, ps.T, ps.TP, ps.W, ps.WP, ps.Dz)
+			fmt.Fprintf(&tsv, "%s\t%s\t%d\t%g\t%g\t%g\t%g\t%g\t%g\t%g\t%g\t%g\t%g\t%g\n",
+				sec.Title, ps.Measure, ps.N, ps.MeanA, ps.MeanB, ps.MeanDiff, ps.SDDiff, ps.CILo, ps.CIHi, ps.T, ps.TP, ps.W, ps.WP, ps.Dz)
+		}
+		fmt.Fprintf(&md, "\n")
+	}
+	fnms := []string{fnm + ".md", fnm + ".tsv"}
+	for i, s := range []string{md.String(), tsv.String()} {
+		if err := ioutil.WriteFile(fnms[i], []byte(s), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return fnms, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenTMR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stage := SleepStages[0]
+	tests := []struct {
+		name string
+		file string // tab-separated lines, joined by newlines
+		want []TMRCue
+		err  bool
+	}{
+		{name: "minimal", file: "Item\tLay\tStart\tEnd\nA\tF1\t0\t100",
+			want: []TMRCue{{Item: "A", Pat: "F1", Lay: "F1", Start: 0, End: 100, Gain: DefTMRGain}}},
+		{name: "full", file: "# cues\nItem\tPat\tLay\tStage\tStart\tEnd\tPhase\tGain\nA\tF2\tF1\t" + strings.ToLower(stage) + "\t10\t20\tup\t0.3\n# B\tF1\tF1\t*\t0\t1\t\t\nB\tF1\tF1\t*\t0\t1\tdown\t",
+			want: []TMRCue{{Item: "A", Pat: "F2", Lay: "F1", Stage: strings.ToLower(stage), Start: 10, End: 20, Phase: "up", Gain: 0.3},
+				{Item: "B", Pat: "F1", Lay: "F1", Start: 0, End: 1, Phase: "down", Gain: DefTMRGain}}},
+		{name: "columns in any order", file: "End\tStart\tLay\tItem\n5\t1\tF1\tA",
+			want: []TMRCue{{Item: "A", Pat: "F1", Lay: "F1", Start: 1, End: 5, Gain: DefTMRGain}}},
+		{name: "no cues", file: "Item\tLay\tStart\tEnd", want: nil},
+		{name: "empty", file: "", err: true},
+		{name: "missing column", file: "Item\tLay\tStart\nA\tF1\t0", err: true},
+		{name: "bad start", file: "Item\tLay\tStart\tEnd\nA\tF1\tx\t100", err: true},
+		{name: "bad end", file: "Item\tLay\tStart\tEnd\nA\tF1\t0\t", err: true},
+		{name: "end before start", file: "Item\tLay\tStart\tEnd\nA\tF1\t100\t100", err: true},
+		{name: "bad phase", file: "Item\tLay\tStart\tEnd\tPhase\nA\tF1\t0\t100\tmid", err: true},
+		{name: "bad stage", file: "Item\tLay\tStart\tEnd\tStage\nA\tF1\t0\t100\tNap", err: true},
+		{name: "bad gain", file: "Item\tLay\tStart\tEnd\tGain\nA\tF1\t0\t100\thigh", err: true},
+	}
+	for i, tt := range tests {
+		fnm := filepath.Join(dir, tt.name+".tsv")
+		if err := ioutil.WriteFile(fnm, []byte(tt.file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cues, err := OpenTMR(fnm)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%d %v: OpenTMR = %+v, want an error", i, tt.name, cues)
+		case !tt.err && err != nil:
+			t.Errorf("%d %v: %v", i, tt.name, err)
+		case !tt.err && len(cues) != len(tt.want):
+			t.Errorf("%d %v: OpenTMR = %+v, want %+v", i, tt.name, cues, tt.want)
+		case !tt.err:
+			for ci := range cues {
+				if cues[ci] != tt.want[ci] {
+					t.Errorf("%d %v: cue %d = %+v, want %+v", i, tt.name, ci, cues[ci], tt.want[ci])
+				}
+			}
+		}
+	}
+	if _, err := OpenTMR(filepath.Join(dir, "none.tsv")); err == nil {
+		t.Errorf("OpenTMR of a missing file: want an error")
+	}
+}
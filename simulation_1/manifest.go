@@ -0,0 +1,203 @@
+// Per-batch provenance manifest: records everything needed to reproduce or
+// audit a batch of runs, in place of copying the source files into output.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/emer/emergent/params"
+	"github.com/emer/emergent/prjn"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// Manifest records the provenance of one batch of runs.  It is written as
+// JSON when the batch starts and rewritten whenever a run starts or ends,
+// so a batch that dies part way through still has an accurate record.
+// It lives in the batch directory alongside every output file of the batch.
+type Manifest struct {
+	Sim        string            `desc:"name of the simulation"`
+	BatchID    string            `desc:"batch identifier -- names the batch output directory"`
+	CmdLine    []string          `desc:"full command line (os.Args)"`
+	Flags      map[string]string `desc:"value of every command-line flag, including defaults"`
+	MasterSeed int64             `desc:"random seed at the start of the batch"`
+	ParamSet   string            `desc:"ParamSet applied on top of Base"`
+	Tag        string            `desc:"extra tag string"`
+	Params     params.Sets       `desc:"effective param sets, after merging any -paramsfile"`
+	GoVersion  string            `desc:"Go version the binary was built with"`
+	Modules    map[string]string `desc:"module versions resolved from go.mod"`
+	Topology   []LayerTopo       `desc:"summary of the network layers and projections"`
+	Start      time.Time         `desc:"when the batch started"`
+	End        *time.Time        `desc:"when the last run ended -- nil while running"`
+	Runs       []*RunStatus      `desc:"status of each run"`
+	Outputs    []string          `desc:"output files written by the batch"`
+
+	Path   string          `json:"-" desc:"file the manifest is written to"`
+	outSet map[string]bool `json:"-"`
+}
+
+// LayerTopo summarizes one layer of the network
+type LayerTopo struct {
+	Name  string
+	Type  string
+	Class string
+	Shape []int
+	Prjns []PrjnTopo `desc:"receiving projections"`
+}
+
+// PrjnTopo summarizes one receiving projection
+type PrjnTopo struct {
+	Send    string
+	Class   string
+	Pattern string
+	NSyns   int
+	SlpRule string `desc:"sleep learning rule (-slprule)"`
+}
+
+// RunStatus records the seeds and state of one run
+type RunStatus struct {
+	Run       int              `desc:"run number"`
+	Seed      int64            `desc:"random seed at the start of the run"`
+	PrjnSeeds map[string]int64 `desc:"seeds of the random projection patterns, by Send->Recv"`
+	Start     time.Time        `desc:"when the run started"`
+	End       *time.Time       `desc:"when the run ended -- nil if still running or aborted"`
+	Status    string           `desc:"running, done or aborted: <reason>"`
+	Epochs    int              `desc:"epochs trained when the run ended"`
+}
+
+// StartManifest starts a new batch: fixes the master seed and the batch
+// ID used for all output paths, fills in the manifest and writes it out.
+func (ss *Sim) StartManifest() {
+	ss.DirSeed = ss.RndSeed
+	if ss.Out.BatchID == "" {
+		ss.Out.BatchID = fmt.Sprint(ss.DirSeed)
+	}
+	mf := &Manifest{Sim: "simulation_1", BatchID: ss.Out.BatchID}
+	mf.Path = ss.Out.ManifestPath()
+	mf.CmdLine = os.Args
+	mf.Flags = map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		mf.Flags[f.Name] = f.Value.String()
+	})
+	mf.MasterSeed = ss.RndSeed
+	mf.ParamSet = ss.ParamsName()
+	mf.Tag = ss.Tag
+	mf.Params = ss.Params
+	mf.GoVersion = runtime.Version()
+	mf.Modules = map[string]string{}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			ver := dep.Version
+			if dep.Replace != nil {
+				ver = dep.Replace.Path + " " + dep.Replace.Version
+			}
+			mf.Modules[dep.Path] = ver
+		}
+	}
+	mf.Topology = NetTopology(ss.Net, ss.SlpRules)
+	mf.Start = time.Now()
+	ss.Manifest = mf
+	mf.BeginRun(ss)
+	fmt.Printf("Saving manifest to: %v\n", mf.Path)
+}
+
+// NetTopology returns the layer and projection summary for net, with the
+// sleep learning rule rs gives each projection
+func NetTopology(net *leabra.Network, rs SlpRules) []LayerTopo {
+	var lts []LayerTopo
+	for _, lyi := range net.Layers {
+		ly := lyi.(leabra.LeabraLayer).AsLeabra()
+		lt := LayerTopo{Name: ly.Nm, Type: ly.Typ.String(), Class: ly.Cls, Shape: ly.Shp.Shp}
+		for _, p := range ly.RcvPrjns {
+			pj := p.(leabra.LeabraPrjn).AsLeabra()
+			lt.Prjns = append(lt.Prjns, PrjnTopo{Send: pj.SendLay().Name(), Class: pj.Class(),
+				Pattern: pj.Pattern().Name(), NSyns: len(pj.Syns), SlpRule: rs.For(pj).Spec()})
+		}
+		lts = append(lts, lt)
+	}
+	return lts
+}
+
+// BeginRun records the start of the current run, with the seeds just set by NewRun
+func (mf *Manifest) BeginRun(ss *Sim) {
+	rs := &RunStatus{Run: ss.TrainEnv.Run.Cur, Seed: ss.RndSeed, Start: time.Now(), Status: "running"}
+	rs.PrjnSeeds = map[string]int64{}
+	for _, lyi := range ss.Net.Layers {
+		for _, pj := range lyi.(leabra.LeabraLayer).AsLeabra().RcvPrjns {
+			if ur, ok := pj.Pattern().(*prjn.UnifRnd); ok {
+				rs.PrjnSeeds[pj.Name()] = ur.RndSeed
+			}
+		}
+	}
+	mf.Runs = append(mf.Runs, rs)
+	mf.Write()
+}
+
+// EndRun marks the current run as done, and the batch as ended if this was the last run
+func (mf *Manifest) EndRun(ss *Sim) {
+	now := time.Now()
+	if n := len(mf.Runs); n > 0 && mf.Runs[n-1].Run == ss.TrainEnv.Run.Cur {
+		rs := mf.Runs[n-1]
+		rs.End = &now
+		rs.Status = "done"
+		rs.Epochs = ss.TrainEnv.Epoch.Cur
+	}
+	if ss.TrainEnv.Run.Cur >= ss.TrainEnv.Run.Max-1 {
+		mf.End = &now
+	}
+	mf.Write()
+}
+
+// AbortRun marks the current run as aborted for reason, and writes the manifest
+func (mf *Manifest) AbortRun(ss *Sim, reason string) {
+	if mf == nil {
+		return
+	}
+	if n := len(mf.Runs); n > 0 && mf.Runs[n-1].Run == ss.TrainEnv.Run.Cur {
+		mf.Runs[n-1].Status = "aborted: " + reason
+		mf.Runs[n-1].Epochs = ss.TrainEnv.Epoch.Cur
+	}
+	mf.Write()
+}
+
+// AddOutput records an output file of the batch -- duplicates are ignored
+func (mf *Manifest) AddOutput(fnm string) {
+	if mf == nil {
+		return
+	}
+	if mf.outSet == nil {
+		mf.outSet = map[string]bool{}
+	}
+	if mf.outSet[fnm] {
+		return
+	}
+	mf.outSet[fnm] = true
+	mf.Outputs = append(mf.Outputs, fnm)
+	sort.Strings(mf.Outputs)
+}
+
+// Write writes the manifest to its Path, logging any error
+func (mf *Manifest) Write() {
+	if err := os.MkdirAll(filepath.Dir(mf.Path), os.ModePerm); err != nil {
+		log.Println(err)
+		return
+	}
+	b, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := ioutil.WriteFile(mf.Path, b, 0644); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,44 @@
+// Protocol milestones: named points of a run (e.g., right before sleep) at
+// which optional analyses (RSA, weight snapshots) are run.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseMilestones parses a comma-separated list of milestones, each of which
+// must be one of valid (case insensitive)
+func ParseMilestones(list string, valid []string) ([]string, error) {
+	var miles []string
+	for _, m := range strings.Split(list, ",") {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		if !HasMilestone(valid, m) {
+			return nil, fmt.Errorf("unknown milestone: %v (must be one of %v)", m, strings.Join(valid, ", "))
+		}
+		miles = append(miles, m)
+	}
+	return miles, nil
+}
+
+// HasMilestone returns true if mile is in miles (case insensitive)
+func HasMilestone(miles []string, mile string) bool {
+	for _, m := range miles {
+		if strings.EqualFold(m, mile) {
+			return true
+		}
+	}
+	return false
+}
+
+// Milestone runs everything that is to be done at milestone mile of the
+// protocol, labeling its output with label (e.g., the milestone and the
+// sleep block number)
+func (ss *Sim) Milestone(mile, label string) {
+	ss.SnapWts(mile, label)
+	ss.RSAMilestone(mile, label)
+}
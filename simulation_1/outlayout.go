@@ -0,0 +1,89 @@
+// Output directory layout: every file a batch writes goes under one
+// directory per batch, so paths are predictable and never collide.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// OutputLayout determines where a batch of runs writes its output:
+//
+//	<Root>/<BatchID>/manifest.json
+//	<Root>/<BatchID>/<BatchID>_<name>                    batch-level logs (epoch, run, ...)
+//	<Root>/<BatchID>/run_<NNN>/<phase>/<BatchID>_<name>  per-run files, by phase
+//
+// Every output file name starts with the batch ID, so a file copied out of
+// the batch directory still points back to the manifest of its batch.
+// Phases used here are "tst_acts", "slp_acts", "slp_tst", "slp_cyc" and "weights".
+type OutputLayout struct {
+	Root    string `desc:"root output directory (-outdir)"`
+	BatchID string `desc:"batch identifier -- fixed for the whole batch, defaults to the master random seed (-batch)"`
+}
+
+// BatchDir returns the directory holding all output of the batch
+func (ol *OutputLayout) BatchDir() string {
+	return filepath.Join(ol.Root, ol.BatchID)
+}
+
+// BatchOutput returns the layout of the batch whose output is in batchDir
+func BatchOutput(batchDir string) OutputLayout {
+	batchDir = filepath.Clean(batchDir)
+	return OutputLayout{Root: filepath.Dir(batchDir), BatchID: filepath.Base(batchDir)}
+}
+
+// FileName returns output file name name, prefixed with the batch ID
+func (ol *OutputLayout) FileName(name string) string {
+	return ol.BatchID + "_" + name
+}
+
+// ManifestPath returns the path of the batch manifest
+func (ol *OutputLayout) ManifestPath() string {
+	return filepath.Join(ol.BatchDir(), "manifest.json")
+}
+
+// BatchFile returns the path of a batch-level file
+func (ol *OutputLayout) BatchFile(name string) string {
+	return filepath.Join(ol.BatchDir(), ol.FileName(name))
+}
+
+// RunDir returns the directory for the given run and phase
+func (ol *OutputLayout) RunDir(run int, phase string) string {
+	return filepath.Join(ol.BatchDir(), fmt.Sprintf("run_%03d", run), phase)
+}
+
+// RunFile returns the path of a per-run file in the given phase
+func (ol *OutputLayout) RunFile(run int, phase, name string) string {
+	return filepath.Join(ol.RunDir(run, phase), ol.FileName(name))
+}
+
+// OpenAppend opens fnm for appending, creating it and its directory as
+// needed.  isNew is true if the file was empty, so headers should be written.
+func (ol *OutputLayout) OpenAppend(fnm string) (f *os.File, isNew bool, err error) {
+	if err = os.MkdirAll(filepath.Dir(fnm), os.ModePerm); err != nil {
+		return nil, false, err
+	}
+	f, err = os.OpenFile(fnm, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, st.Size() == 0, nil
+}
+
+// OpenOutput opens output file fnm for appending (see OutputLayout.OpenAppend)
+// and records it in the batch manifest.
+func (ss *Sim) OpenOutput(fnm string) (*os.File, bool, error) {
+	f, isNew, err := ss.Out.OpenAppend(fnm)
+	if err != nil {
+		return nil, false, err
+	}
+	ss.Manifest.AddOutput(fnm)
+	return f, isNew, nil
+}
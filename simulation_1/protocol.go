@@ -0,0 +1,240 @@
+// Multi-session protocols (-protocol): once the network reaches the sleep
+// criterion and has had its pre-sleep test, the run goes through a list of
+// steps -- sleep bouts, further wake training and tests -- and each test is
+// logged in the SessLog, tracking retention and sleep benefit across the
+// sessions of the run.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/emer/emergent/env"
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+)
+
+// DefProtocol is the default protocol: one sleep bout, then the post-sleep test
+const DefProtocol = "sleep,test"
+
+// DefStrucEpcs is the default number of epochs of a structured sleep step
+const DefStrucEpcs = 1
+
+// DefSleepCycs is the default number of cycles of a sleep step
+const DefSleepCycs = 30000
+
+// MaxSleepCycs is the maximum number of cycles of a sleep step (the length
+// of the inhibitory oscillation prepared by SleepTrial)
+const MaxSleepCycs = 500000
+
+// ProtoStep is one step of a protocol
+type ProtoStep struct {
+	Kind string `desc:"sleep: a sleep bout of N cycles; struc: N epochs of structured sleep; wake: N epochs of wake training; test: test and log the session"`
+	N    int    `desc:"cycles of a sleep step, epochs of a struc or wake step"`
+}
+
+// String returns the step as it is written in a protocol spec
+func (ps ProtoStep) String() string {
+	if ps.Kind == "test" {
+		return ps.Kind
+	}
+	return fmt.Sprintf("%v:%d", ps.Kind, ps.N)
+}
+
+// ParseProtocol parses a protocol spec: a comma-separated list of steps,
+// each of which is sleep[:<cycles>] (default DefSleepCycs), struc[:<epochs>]
+// (default DefStrucEpcs), wake:<epochs> or test, e.g.
+// sleep:10000,wake:5,sleep,test
+func ParseProtocol(spec string) ([]ProtoStep, error) {
+	var steps []ProtoStep
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		args := strings.Split(s, ":")
+		st := ProtoStep{Kind: args[0]}
+		switch {
+		case st.Kind == "test" && len(args) == 1:
+		case st.Kind == "sleep" && len(args) == 1:
+			st.N = DefSleepCycs
+		case st.Kind == "struc" && len(args) == 1:
+			st.N = DefStrucEpcs
+		case (st.Kind == "sleep" || st.Kind == "struc" || st.Kind == "wake") && len(args) == 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("protocol step %v: %v", s, err)
+			}
+			st.N = n
+		default:
+			return nil, fmt.Errorf("invalid protocol step: %v (must be sleep[:<cycles>], struc[:<epochs>], wake:<epochs> or test)", s)
+		}
+		switch {
+		case st.Kind != "test" && st.N < 1:
+			return nil, fmt.Errorf("protocol step %v: must be at least 1", s)
+		case st.Kind == "sleep" && st.N > MaxSleepCycs:
+			return nil, fmt.Errorf("protocol step %v: at most %d cycles", s, MaxSleepCycs)
+		}
+		steps = append(steps, st)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty protocol")
+	}
+	return steps, nil
+}
+
+// RunProtocol runs the steps of the Protocol under the current sleep
+// condition, once the network has reached the sleep criterion and had its
+// pre-sleep test.  The results of each test are the PostSlpRes (so the last
+// test is the final outcome of the condition), a row of the SessLog and, with
+// SlpResWrtOut, a row of slpres.csv.
+func (ss *Sim) RunProtocol() {
+	var slpres *csv.Writer
+	if ss.SlpResWrtOut {
+		f, isNew, err := ss.OpenOutput(ss.Out.BatchFile("slpres.csv"))
+		if err != nil {
+			log.Println(err)
+		} else {
+			defer f.Close()
+			slpres = csv.NewWriter(f)
+			defer slpres.Flush()
+			if isNew {
+				slpres.Write([]string{"Shared", "Unique", "ShSSE", "UnSSE", "SlpTrls", "Cond", "Run"})
+			}
+			slpres.Write(ss.slpresRow(""))
+		}
+	}
+
+	ss.Sess = 0
+	ss.TotSlpTrls = 0
+	ss.LogSess(ss.SessLog, "Criterion", nil, 0)
+	var since []string
+	slpTrls := 0
+	newEpc := false // wake training since the last test: its activity file is new
+	for si, st := range ss.Protocol {
+		ss.Sess = si + 1
+		switch st.Kind {
+		case "sleep":
+			ss.SleepTrial(st.N)
+			slpTrls += ss.SlpTrls
+			ss.TotSlpTrls += ss.SlpTrls
+		case "struc":
+			n := ss.StrucSleepEpochs(st.N)
+			slpTrls += n
+			ss.TotSlpTrls += n
+		case "wake":
+			ss.WakeEpochs(st.N)
+			newEpc = true
+		case "test":
+			ss.FinalTest = !newEpc
+			ss.TestAll(true)
+			ss.PostSlpRes = ss.LesionRes
+			if slpres != nil {
+				slpres.Write(ss.slpresRow(strconv.Itoa(slpTrls)))
+				slpres.Flush()
+			}
+			ss.LogSess(ss.SessLog, st.String(), since, slpTrls)
+			since = nil
+			slpTrls = 0
+			newEpc = false
+			continue
+		}
+		since = append(since, st.String())
+	}
+	ss.Milestone("PostSleep", ss.CondLabel("PostSleep"))
+	ss.FinalTest = false
+}
+
+// slpresRow returns the intact network's results of the last test as a row
+// of slpres.csv, with slpTrls empty for the pre-sleep row
+func (ss *Sim) slpresRow(slpTrls string) []string {
+	var row []string
+	for _, cn := range RunTstCols {
+		row = append(row, strconv.FormatFloat(LesionVal(ss.LesionRes, LesionNms[0], cn), 'f', 6, 64))
+	}
+	return append(row, slpTrls, ss.SlpCond.Name, strconv.Itoa(ss.TrainEnv.Run.Cur))
+}
+
+// WakeEpochs runs n epochs of wake training, logging each epoch, as a step
+// of the protocol.  The first trial of the current epoch is pending when it
+// is called (TrainTrial returns right after the epoch counter changes), as
+// it is when it returns.
+func (ss *Sim) WakeEpochs(n int) {
+	ss.StartWtChg(ss.CondLabel(fmt.Sprintf("Wake-%d", ss.Sess)))
+	for done := 0; done < n; {
+		ss.WakeTrial()
+		ss.TrainEnv.Step()
+		if _, _, chg := ss.TrainEnv.Counter(env.Epoch); chg {
+			ss.LogTrnEpc(ss.TrnEpcLog)
+			done++
+		}
+	}
+}
+
+// LogSess adds the results of the test that ends session ss.Sess to the
+// SessLog: step is the test step, since the steps run since the previous
+// test and slpTrls the number of sleep learning trials in those steps.  Ret
+// is the change of each result since the criterion (pre-sleep) test of the
+// sleep condition -- the retention -- and Chg since the previous test -- the
+// sleep benefit, when the steps since include sleep.
+func (ss *Sim) LogSess(dt *etable.Table, step string, since []string, slpTrls int) {
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Cond", row, ss.SlpCond.Name)
+	dt.SetCellFloat("Session", row, float64(ss.Sess))
+	dt.SetCellString("Step", row, step)
+	dt.SetCellString("Since", row, strings.Join(since, " "))
+	dt.SetCellFloat("SlpTrls", row, float64(slpTrls))
+	crit := row // criterion row of the condition
+	for crit > 0 && dt.CellString("Step", crit) != "Criterion" {
+		crit--
+	}
+	for _, cn := range RunTstCols {
+		val := LesionVal(ss.LesionRes, LesionNms[0], cn)
+		ret, chg := 0.0, math.NaN()
+		if row > crit {
+			ret = val - dt.CellFloat(cn, crit)
+			chg = val - dt.CellFloat(cn, row-1)
+		}
+		dt.SetCellFloat(cn, row, val)
+		dt.SetCellFloat(cn+" Ret", row, ret)
+		dt.SetCellFloat(cn+" Chg", row, chg)
+	}
+
+	ss.SessFile.WriteRow(dt, row)
+}
+
+// ConfigSessLog configures the SessLog: one row per test of the protocol,
+// starting with the criterion (pre-sleep) test, for each sleep condition
+func (ss *Sim) ConfigSessLog(dt *etable.Table) {
+	dt.SetMetaData("name", "SessLog")
+	dt.SetMetaData("desc", "Test results at the end of each session of the protocol")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	sch := etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Cond", etensor.STRING, nil, nil},
+		{"Session", etensor.INT64, nil, nil},
+		{"Step", etensor.STRING, nil, nil},
+		{"Since", etensor.STRING, nil, nil},
+		{"SlpTrls", etensor.INT64, nil, nil},
+	}
+	for _, cn := range RunTstCols {
+		sch = append(sch, etable.Schema{
+			{cn, etensor.FLOAT64, nil, nil},
+			{cn + " Ret", etensor.FLOAT64, nil, nil},
+			{cn + " Chg", etensor.FLOAT64, nil, nil},
+		}...)
+	}
+	dt.SetFromSchema(sch, 0)
+}
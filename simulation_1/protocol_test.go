@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// protoString returns steps as a normalized protocol spec
+func protoString(steps []ProtoStep) string {
+	var ss []string
+	for _, st := range steps {
+		ss = append(ss, st.String())
+	}
+	return strings.Join(ss, ",")
+}
+
+func TestParseProtocol(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string // normalized steps -- empty if the spec is rejected
+	}{
+		{DefProtocol, fmt.Sprintf("sleep:%d,test", DefSleepCycs)},
+		{"sleep:10000,wake:5,sleep,test", fmt.Sprintf("sleep:10000,wake:5,sleep:%d,test", DefSleepCycs)},
+		{" struc , struc:3 ,test,", fmt.Sprintf("struc:%d,struc:3,test", DefStrucEpcs)},
+		{fmt.Sprintf("sleep:%d", MaxSleepCycs), fmt.Sprintf("sleep:%d", MaxSleepCycs)},
+		{"", ""},
+		{",", ""},
+		{"nap", ""},
+		{"wake", ""},
+		{"test:1", ""},
+		{"sleep:0", ""},
+		{"wake:-1", ""},
+		{"struc:x", ""},
+		{"sleep:1:2", ""},
+		{fmt.Sprintf("sleep:%d", MaxSleepCycs+1), ""},
+	}
+	for _, tt := range tests {
+		steps, err := ParseProtocol(tt.spec)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("ParseProtocol(%q) = %v, want an error", tt.spec, protoString(steps))
+		case tt.want != "" && err != nil:
+			t.Errorf("ParseProtocol(%q): %v", tt.spec, err)
+		case tt.want != "" && protoString(steps) != tt.want:
+			t.Errorf("ParseProtocol(%q) = %v, want %v", tt.spec, protoString(steps), tt.want)
+		}
+	}
+}
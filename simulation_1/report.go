@@ -0,0 +1,187 @@
+// Cross-run statistical report of the sleep benefit (-report): compares
+// the pre- and post-sleep test results of every run of a batch, and those
+// of sleep with its control conditions (-slpconds).
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SlpRes is one run's row pair of slpres.csv for one sleep condition: the
+// intact network's test results right before and right after sleep
+type SlpRes struct {
+	Run  int        `desc:"run number -- -1 if not recorded"`
+	Cond string     `desc:"sleep condition"`
+	Pre  [4]float64 `desc:"Shared, Unique, ShSSE, UnSSE before sleep"`
+	Post [4]float64 `desc:"Shared, Unique, ShSSE, UnSSE after sleep"`
+}
+
+// SlpResCols are the result columns of slpres.csv, in order
+var SlpResCols = []string{"Shared", "Unique", "ShSSE", "UnSSE"}
+
+// OpenSlpRes reads the pre / post sleep results of each run and sleep
+// condition from a slpres.csv file.  Each condition of a run writes a
+// pre-sleep row (with an empty or no SlpTrls) followed by its post-sleep
+// row(s), the first of which is kept; a pre row not followed by a post row
+// (a run that died during sleep) is skipped.  Files without the Cond and Run
+// columns are of the sleep condition only.
+func OpenSlpRes(fnm string) ([]SlpRes, error) {
+	f, err := os.Open(fnm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd := csv.NewReader(f)
+	rd.FieldsPerRecord = -1
+	recs, err := rd.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	nc := len(SlpResCols)
+	var res []SlpRes
+	var pre []float64
+	for ri, rec := range recs {
+		if ri == 0 || len(rec) < nc {
+			continue // header
+		}
+		vals := make([]float64, nc)
+		for ci := range SlpResCols {
+			if vals[ci], err = strconv.ParseFloat(rec[ci], 64); err != nil {
+				return nil, fmt.Errorf("%v line %d: %v", fnm, ri+1, err)
+			}
+		}
+		if len(rec) == nc || rec[nc] == "" { // pre-sleep row
+			pre = vals
+			continue
+		}
+		if pre == nil {
+			continue
+		}
+		sr := SlpRes{Run: -1, Cond: SleepCond.Name}
+		if len(rec) > nc+2 {
+			sr.Cond = rec[nc+1]
+			if sr.Run, err = strconv.Atoi(rec[nc+2]); err != nil {
+				return nil, fmt.Errorf("%v line %d: %v", fnm, ri+1, err)
+			}
+		}
+		copy(sr.Pre[:], pre)
+		copy(sr.Post[:], vals)
+		res = append(res, sr)
+		pre = nil
+	}
+	return res, nil
+}
+
+// Report writes report.md and report.tsv to batchDir, from its slpres.csv
+// (all prefixed with the batch ID, see OutputLayout):
+// for each sleep condition, each of shared and unique percent correct and
+// SSE, post- vs pre-sleep, and the sleep benefit (post - pre) of unique vs
+// shared features; and the benefit of sleep vs each other condition, paired
+// by run.
+func (ss *Sim) Report(batchDir string) error {
+	out := BatchOutput(batchDir)
+	res, err := OpenSlpRes(out.BatchFile("slpres.csv"))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("report: %v -- run with -slpres", err)
+	} else if err != nil {
+		return err
+	}
+	rnd := rand.New(rand.NewSource(1)) // fixed, so the same batch gives the same report
+
+	var conds []string
+	byCond := map[string][]SlpRes{}
+	for _, sr := range res {
+		if _, ok := byCond[sr.Cond]; !ok {
+			conds = append(conds, sr.Cond)
+		}
+		byCond[sr.Cond] = append(byCond[sr.Cond], sr)
+	}
+
+	col := func(crs []SlpRes, post bool, ci int) []float64 {
+		vals := make([]float64, len(crs))
+		for i, sr := range crs {
+			if post {
+				vals[i] = sr.Post[ci]
+			} else {
+				vals[i] = sr.Pre[ci]
+			}
+		}
+		return vals
+	}
+	benefit := func(crs []SlpRes, ci int) []float64 {
+		vals := make([]float64, len(crs))
+		for i, sr := range crs {
+			vals[i] = sr.Post[ci] - sr.Pre[ci]
+		}
+		return vals
+	}
+
+	var secs []ReportSection
+	var nruns []string
+	for _, cond := range conds {
+		crs := byCond[cond]
+		sfx := ""
+		if len(conds) > 1 || cond != SleepCond.Name {
+			sfx = ", " + cond
+		}
+		prepost := ReportSection{Title: "Post- vs pre-sleep" + sfx + " (A = pre, B = post)"}
+		for ci, cn := range SlpResCols {
+			prepost.Rows = append(prepost.Rows, PairedStats(cn, col(crs, false, ci), col(crs, true, ci), rnd))
+		}
+		shun := ReportSection{Title: "Sleep benefit" + sfx + ", unique vs shared features (A = shared post - pre, B = unique post - pre)"}
+		shun.Rows = append(shun.Rows, PairedStats("PctCor benefit", benefit(crs, 0), benefit(crs, 1), rnd))
+		shun.Rows = append(shun.Rows, PairedStats("SSE benefit", benefit(crs, 2), benefit(crs, 3), rnd))
+		secs = append(secs, prepost, shun)
+		nruns = append(nruns, fmt.Sprintf("%d %v", len(crs), cond))
+	}
+
+	// benefit of sleep vs each control condition, paired by run
+	if sleep, ok := byCond[SleepCond.Name]; ok {
+		slpRun := map[int]SlpRes{}
+		for _, sr := range sleep {
+			slpRun[sr.Run] = sr
+		}
+		for _, cond := range conds {
+			if cond == SleepCond.Name {
+				continue
+			}
+			crs := byCond[cond]
+			sec := ReportSection{Title: fmt.Sprintf("Sleep vs %v benefit (A = %v post - pre, B = sleep post - pre)", cond, cond)}
+			for ci, cn := range SlpResCols {
+				a, b := benefit(crs, ci), make([]float64, len(crs))
+				for i, sr := range crs {
+					b[i] = math.NaN()
+					if slp, ok := slpRun[sr.Run]; ok {
+						b[i] = slp.Post[ci] - slp.Pre[ci]
+					}
+				}
+				sec.Rows = append(sec.Rows, PairedStats(cn+" benefit", a, b, rnd))
+			}
+			secs = append(secs, sec)
+		}
+	}
+
+	notes := []string{
+		fmt.Sprintf("Batch: `%v` -- %d runs with pre- and post-sleep tests.", batchDir, len(res)),
+		fmt.Sprintf("Differences are B - A, paired by run. 95%% CI: percentile bootstrap of the mean difference (%d resamples). "+
+			"t: paired t test. W+: Wilcoxon signed-rank test (normal approximation). dz: mean difference / SD of the differences.", NBoot),
+	}
+	if len(conds) > 1 {
+		notes[0] = fmt.Sprintf("Batch: `%v` -- runs with pre- and post-sleep tests, by sleep condition: %v.", batchDir, strings.Join(nruns, ", "))
+	}
+	fnms, err := WriteReport(out.BatchFile("report"), "Sleep benefit: simulation_1", notes, secs)
+	if err != nil {
+		return err
+	}
+	for _, fnm := range fnms {
+		fmt.Printf("Saved report to: %v\n", fnm)
+	}
+	return nil
+}
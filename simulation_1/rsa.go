@@ -0,0 +1,239 @@
+// Representational similarity analysis (RSA) of hidden layers: at chosen
+// protocol milestones, every item is presented with full cues, the settled
+// ActM of the RSA layers is recorded, and item x item similarity matrices
+// and their class / feature structure are computed and logged.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/emer/etable/metric"
+	"github.com/emer/etable/simat"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// RSAMilestones are the protocol milestones at which RSA can be run (-rsa)
+var RSAMilestones = []string{"PreSleep", "PostSleep"}
+
+// RSAPairTypes are the kinds of item pairs whose mean similarity is logged:
+// Within / Between class, and within class, a satellite vs its prototype
+// (differing only in the satellite's unique feature) and two satellites
+// (sharing the remaining shared features, each with its own unique feature)
+var RSAPairTypes = []string{"Within", "Between", "ProtoSat", "SatSat"}
+
+// RSAItem is one item presented for RSA
+type RSAItem struct {
+	Name  string
+	Class string `desc:"category of the item"`
+	Proto bool   `desc:"true if the item is its category prototype, with no unique feature"`
+}
+
+// RSAItemSets returns the item sets presented for RSA: each distinct item of TestSat
+func (ss *Sim) RSAItemSets() []*etable.IdxView {
+	ix := etable.NewIdxView(ss.TestSat)
+	seen := map[string]bool{}
+	ix.Filter(func(et *etable.Table, row int) bool {
+		nm := et.CellString("Name", row)
+		if seen[nm] {
+			return false
+		}
+		seen[nm] = true
+		return true
+	})
+	return []*etable.IdxView{ix}
+}
+
+// NewRSAItem returns the RSAItem for the item of the given name: its class is
+// its most frequent feature value, and any other value is a unique feature.
+func NewRSAItem(name string) RSAItem {
+	cnt := map[rune]int{}
+	for _, r := range name {
+		cnt[r]++
+	}
+	var cls rune
+	for r, n := range cnt {
+		if n > cnt[cls] || (n == cnt[cls] && r < cls) {
+			cls = r
+		}
+	}
+	return RSAItem{Name: name, Class: string(cls), Proto: cnt[cls] == len(name)}
+}
+
+// RSAPairTypesOf returns the RSAPairTypes that the pair a, b belongs to
+func RSAPairTypesOf(a, b RSAItem) []string {
+	if a.Class != b.Class {
+		return []string{"Between"}
+	}
+	switch {
+	case a.Proto != b.Proto:
+		return []string{"Within", "ProtoSat"}
+	case !a.Proto && !b.Proto:
+		return []string{"Within", "SatSat"}
+	}
+	return []string{"Within"}
+}
+
+// RSAMilestone runs RSA at milestone mile, if it is in RSAAt: presents the
+// items, saves the similarity matrix of each RSA layer to the run's rsa
+// directory and logs its summary, labeled with label.
+func (ss *Sim) RSAMilestone(mile, label string) {
+	if !HasMilestone(ss.RSAAt, mile) {
+		return
+	}
+	items, acts := ss.RSAPresent()
+	ix := etable.NewIdxView(acts)
+	for _, lnm := range ss.RSALays {
+		smat := &simat.SimMat{}
+		if err := smat.TableCol(ix, lnm, "Item", false, metric.Correlation64); err != nil {
+			log.Println(err)
+			continue
+		}
+		ss.SaveSimMat(smat, label, lnm)
+		ss.LogRSA(ss.RSALog, label, lnm, items, smat)
+	}
+}
+
+// RSAPresent presents every RSA item with full cues, without learning, and
+// returns the items and a table of the settled ActM of each RSA layer
+func (ss *Sim) RSAPresent() ([]RSAItem, *etable.Table) {
+	sch := etable.Schema{
+		{"Item", etensor.STRING, nil, nil},
+		{"Class", etensor.STRING, nil, nil},
+	}
+	for _, lnm := range ss.RSALays {
+		ly := ss.Net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra()
+		sch = append(sch, etable.Column{lnm, etensor.FLOAT32, ly.Shp.Shp, nil})
+	}
+	dt := &etable.Table{}
+	dt.SetFromSchema(sch, 0)
+	tsr := &etensor.Float32{}
+
+	var items []RSAItem
+	for _, set := range ss.RSAItemSets() {
+		ss.RSAEnv.Table = set
+		ss.RSAEnv.Sequential = true
+		ss.RSAEnv.Validate()
+		ss.RSAEnv.Init(0)
+		for i := 0; i < set.Len(); i++ {
+			ss.RSAEnv.Step()
+			it := NewRSAItem(ss.RSAEnv.TrialName.Cur)
+			ss.ApplyInputs(&ss.RSAEnv)
+			ss.RSASettle()
+
+			row := dt.Rows
+			dt.SetNumRows(row + 1)
+			dt.SetCellString("Item", row, it.Name)
+			dt.SetCellString("Class", row, it.Class)
+			for _, lnm := range ss.RSALays {
+				ly := ss.Net.LayerByName(lnm).(leabra.LeabraLayer).AsLeabra()
+				ly.UnitValsTensor(tsr, "ActM")
+				dt.SetCellTensor(lnm, row, tsr)
+			}
+			items = append(items, it)
+		}
+	}
+	return items, dt
+}
+
+// RSASettle runs one alpha cycle of settling, without learning, logging or
+// display updates
+func (ss *Sim) RSASettle() {
+	ss.Net.AlphaCycInit(false)
+	ss.Time.AlphaCycStart()
+	for qtr := 0; qtr < 4; qtr++ {
+		for cyc := 0; cyc < 25; cyc++ {
+			ss.Net.Cycle(&ss.Time, false)
+			ss.Time.CycleInc()
+		}
+		ss.Net.QuarterFinal(&ss.Time)
+		ss.Time.QuarterInc()
+	}
+}
+
+// SaveSimMat saves the similarity matrix of layer lnm at label to the run's rsa directory
+func (ss *Sim) SaveSimMat(smat *simat.SimMat, label, lnm string) {
+	fnm := ss.Out.RunFile(ss.TrainEnv.Run.Cur, "rsa", "simmat_"+label+"_"+lnm+".tsv")
+	if err := os.MkdirAll(filepath.Dir(fnm), os.ModePerm); err != nil {
+		log.Println(err)
+		return
+	}
+	f, err := os.Create(fnm)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "Item\t%s\n", strings.Join(smat.Rows, "\t"))
+	n := len(smat.Rows)
+	for ai := 0; ai < n; ai++ {
+		vals := make([]string, n)
+		for bi := 0; bi < n; bi++ {
+			vals[bi] = strconv.FormatFloat(smat.Mat.FloatVal([]int{ai, bi}), 'g', 6, 64)
+		}
+		fmt.Fprintf(f, "%s\t%s\n", smat.Rows[ai], strings.Join(vals, "\t"))
+	}
+	ss.Manifest.AddOutput(fnm)
+}
+
+// LogRSA adds the similarity summary of layer lnm at label to the RSALog:
+// the mean similarity of each of the RSAPairTypes, and the class index
+// Within - Between
+func (ss *Sim) LogRSA(dt *etable.Table, label, lnm string, items []RSAItem, smat *simat.SimMat) {
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	sum := map[string]float64{}
+	cnt := map[string]int{}
+	for ai := range items {
+		for bi := ai + 1; bi < len(items); bi++ {
+			sim := smat.Mat.FloatVal([]int{ai, bi})
+			for _, pt := range RSAPairTypesOf(items[ai], items[bi]) {
+				sum[pt] += sim
+				cnt[pt]++
+			}
+		}
+	}
+	mean := map[string]float64{}
+	for _, pt := range RSAPairTypes {
+		mean[pt] = sum[pt] / float64(cnt[pt]) // NaN if no such pairs
+	}
+
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Milestone", row, label)
+	dt.SetCellString("Layer", row, lnm)
+	for _, pt := range RSAPairTypes {
+		dt.SetCellFloat(pt, row, mean[pt])
+	}
+	dt.SetCellFloat("ClassIdx", row, mean["Within"]-mean["Between"])
+
+	ss.RSAFile.WriteRow(dt, row)
+}
+
+// ConfigRSALog configures the RSALog: one row per run, milestone and RSA layer
+func (ss *Sim) ConfigRSALog(dt *etable.Table) {
+	dt.SetMetaData("name", "RSALog")
+	dt.SetMetaData("desc", "Representational similarity summaries of the RSA layers at each milestone")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	sch := etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Milestone", etensor.STRING, nil, nil},
+		{"Layer", etensor.STRING, nil, nil},
+	}
+	for _, pt := range RSAPairTypes {
+		sch = append(sch, etable.Column{pt, etensor.FLOAT64, nil, nil})
+	}
+	sch = append(sch, etable.Column{"ClassIdx", etensor.FLOAT64, nil, nil})
+	dt.SetFromSchema(sch, 0)
+}
@@ -0,0 +1,134 @@
+// Plus / minus phase thresholds of sleep learning: either the hand-tuned
+// fixed thresholds, or adaptive thresholds set from the distribution of the
+// network stability (AvgLaySim) over the recent cycles of sleep (-slpthr).
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FixedSlpThresh returns the hand-tuned plus and minus thresholds of sleep stage
+func (ss *Sim) FixedSlpThresh(stage string) (plus, minus float64) {
+	plus = 0.999965
+	return plus, plus - 0.0025
+}
+
+// SlpThresh computes the plus and minus phase thresholds on AvgLaySim
+type SlpThresh struct {
+	Mode     string    `desc:"fixed: the hand-tuned thresholds of each sleep stage; pctile: percentiles of the recent AvgLaySim; zscore: mean + z * SD of the recent AvgLaySim"`
+	PlusArg  float64   `desc:"pctile: percentile (0-1) of the plus threshold; zscore: z of the plus threshold"`
+	MinusArg float64   `desc:"pctile: percentile (0-1) of the minus threshold; zscore: z of the minus threshold"`
+	Win      int       `desc:"number of recent cycles whose AvgLaySim sets the adaptive thresholds -- no learning until that many cycles of a sleep block have passed"`
+	Interval int       `desc:"the adaptive thresholds are recomputed every Interval cycles"`
+	Plus     float64   `inactive:"+" desc:"current plus threshold"`
+	Minus    float64   `inactive:"+" desc:"current minus threshold"`
+	Vals     []float64 `view:"-" desc:"AvgLaySim of the last Win cycles, as a ring buffer"`
+	N        int       `view:"-" desc:"number of cycles since Reset"`
+}
+
+// Defaults sets the fixed mode and the default adaptive parameters
+func (st *SlpThresh) Defaults() {
+	st.Mode = "fixed"
+	st.Win = 1000
+	st.Interval = 10
+}
+
+// Set sets the mode from spec: fixed, pctile[:<plus>:<minus>] (defaults 0.9
+// and 0.25) or zscore[:<plus>:<minus>] (defaults 1 and -1)
+func (st *SlpThresh) Set(spec string) error {
+	invalid := fmt.Errorf("invalid sleep thresholds: %v (must be fixed, pctile[:<plus>:<minus>] or zscore[:<plus>:<minus>])", spec)
+	args := strings.Split(strings.TrimSpace(spec), ":")
+	st.Mode = args[0]
+	switch st.Mode {
+	case "fixed":
+		if len(args) == 1 {
+			return nil
+		}
+		return invalid
+	case "pctile":
+		st.PlusArg, st.MinusArg = 0.9, 0.25
+	case "zscore":
+		st.PlusArg, st.MinusArg = 1, -1
+	default:
+		return invalid
+	}
+	switch len(args) {
+	case 1:
+		return nil
+	case 3:
+		var err error
+		if st.PlusArg, err = strconv.ParseFloat(args[1], 64); err != nil {
+			return fmt.Errorf("sleep thresholds %v: %v", spec, err)
+		}
+		if st.MinusArg, err = strconv.ParseFloat(args[2], 64); err != nil {
+			return fmt.Errorf("sleep thresholds %v: %v", spec, err)
+		}
+		if st.MinusArg >= st.PlusArg {
+			return fmt.Errorf("sleep thresholds %v: minus must be below plus", spec)
+		}
+		if st.Mode == "pctile" && (st.MinusArg < 0 || st.PlusArg > 1) {
+			return fmt.Errorf("sleep thresholds %v: percentiles must be between 0 and 1", spec)
+		}
+		return nil
+	}
+	return invalid
+}
+
+// Adaptive returns true if the thresholds are set from the recent AvgLaySim
+func (st *SlpThresh) Adaptive() bool {
+	return st.Mode != "fixed"
+}
+
+// Reset starts a new sleep block, with the given fixed thresholds.  The
+// adaptive thresholds are +Inf (no learning) until Win cycles have passed.
+func (st *SlpThresh) Reset(plus, minus float64) {
+	st.Vals = st.Vals[:0]
+	st.N = 0
+	if st.Adaptive() {
+		st.Plus, st.Minus = math.Inf(1), math.Inf(1)
+		return
+	}
+	st.Plus, st.Minus = plus, minus
+}
+
+// Update adds the AvgLaySim sim of the current cycle, and recomputes the
+// adaptive thresholds if due
+func (st *SlpThresh) Update(sim float64) {
+	if !st.Adaptive() {
+		return
+	}
+	if len(st.Vals) < st.Win {
+		st.Vals = append(st.Vals, sim)
+	} else {
+		st.Vals[st.N%st.Win] = sim
+	}
+	st.N++
+	if st.N < st.Win || (st.N-st.Win)%st.Interval != 0 {
+		return
+	}
+	switch st.Mode {
+	case "pctile":
+		srt := append([]float64(nil), st.Vals...)
+		sort.Float64s(srt)
+		st.Plus, st.Minus = Percentile(srt, st.PlusArg), Percentile(srt, st.MinusArg)
+	case "zscore":
+		mean, sd := Mean(st.Vals), SD(st.Vals)
+		st.Plus, st.Minus = mean+st.PlusArg*sd, mean+st.MinusArg*sd
+	}
+}
+
+// Percentile returns the p (0-1) percentile of the sorted values srt, by
+// linear interpolation between the closest ranks
+func Percentile(srt []float64, p float64) float64 {
+	pos := p * float64(len(srt)-1)
+	lo := int(math.Floor(pos))
+	if lo >= len(srt)-1 {
+		return srt[len(srt)-1]
+	}
+	return srt[lo] + (pos-float64(lo))*(srt[lo+1]-srt[lo])
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// sleepDiffs are the differences (group 2 - group 1) of the sleep data of
+// Student (1908), R's datasets::sleep, with a zero and a tie: in R,
+// t.test(extra ~ group, paired = TRUE) gives |t| = 4.0621, p = 0.002833, and
+// wilcox.test(extra ~ group, paired = TRUE, exact = FALSE) gives
+// p = 0.009091 (with ties and zeros warnings)
+var sleepDiffs = []float64{1.2, 2.4, 1.3, 1.3, 0, 1.0, 1.8, 0.8, 4.6, 1.4}
+
+// pairDiffs are the differences x - y of the paired example of R's
+// wilcox.test documentation (V = 40)
+var pairDiffs = []float64{0.952, -0.147, 1.022, 0.43, 0.62, 0.59, 0.49, -0.08, 0.01}
+
+// near returns true if got is within tol of want, or both are NaN
+func near(got, want, tol float64) bool {
+	if math.IsNaN(want) {
+		return math.IsNaN(got)
+	}
+	return math.Abs(got-want) <= tol
+}
+
+func TestPairedT(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name string
+		ds   []float64
+		t, p float64
+	}{
+		{"sleep", sleepDiffs, 4.062128, 0.002833},
+		{"pairs", pairDiffs, 3.035375, 0.016177},
+		{"negative", []float64{-1.2, -2.4, -1.3, -1.3, 0, -1.0, -1.8, -0.8, -4.6, -1.4}, -4.062128, 0.002833},
+		{"mixed", []float64{-1, 2, 2, -2, 3, 0, 4}, 1.378764, 0.217165},
+		{"empty", nil, nan, nan},
+		{"one", []float64{1}, nan, nan},
+		{"no variance", []float64{1, 1, 1}, nan, nan},
+		{"all zero", []float64{0, 0}, nan, nan},
+	}
+	for _, tt := range tests {
+		tv, p := PairedT(tt.ds)
+		if !near(tv, tt.t, 1e-6) || !near(p, tt.p, 1e-6) {
+			t.Errorf("%v: PairedT = %v, %v, want %v, %v", tt.name, tv, p, tt.t, tt.p)
+		}
+	}
+}
+
+func TestWilcoxon(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name string
+		ds   []float64
+		w, p float64
+	}{
+		{"sleep", sleepDiffs, 45, 0.009091},                       // zero dropped, tie corrected
+		{"pairs", pairDiffs, 40, 0.044011},                        // no ties
+		{"mixed", []float64{-1, 2, 2, -2, 3, 0, 4}, 17, 0.203374}, // ties across signs
+		{"one", []float64{2}, 1, 1},                               // continuity corrected to z = 0
+		{"empty", nil, nan, nan},
+		{"all zero", []float64{0, 0}, nan, nan},
+	}
+	for _, tt := range tests {
+		w, p := Wilcoxon(tt.ds)
+		if !near(w, tt.w, 1e-9) || !near(p, tt.p, 1e-6) {
+			t.Errorf("%v: Wilcoxon = %v, %v, want %v, %v", tt.name, w, p, tt.w, tt.p)
+		}
+	}
+}
+
+func TestBootCI(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	lo, hi := BootCI(sleepDiffs, NBoot, rnd)
+	if mn := Mean(sleepDiffs); !(lo < mn && mn < hi) {
+		t.Errorf("sleep: BootCI = [%v, %v] does not contain the mean %v", lo, hi, mn)
+	}
+	if lo <= 0 {
+		t.Errorf("sleep: BootCI = [%v, %v] should exclude 0", lo, hi)
+	}
+	lo, hi = BootCI([]float64{2, 2, 2}, NBoot, rnd)
+	if lo != 2 || hi != 2 {
+		t.Errorf("no variance: BootCI = [%v, %v], want [2, 2]", lo, hi)
+	}
+	for _, ds := range [][]float64{nil, {1}} {
+		lo, hi = BootCI(ds, NBoot, rnd)
+		if !math.IsNaN(lo) || !math.IsNaN(hi) {
+			t.Errorf("%v: BootCI = [%v, %v], want NaN", ds, lo, hi)
+		}
+	}
+}
+
+func TestPairedStats(t *testing.T) {
+	nan := math.NaN()
+	a := []float64{0.7, -1.6, -0.2, -1.2, -0.1, 3.4, 3.7, 0.8, 0.0, 2.0, nan, 1}
+	b := []float64{1.9, 0.8, 1.1, 0.1, -0.1, 4.4, 5.5, 1.6, 4.6, 3.4, 1, nan}
+	ps := PairedStats("sleep", a, b, rand.New(rand.NewSource(1)))
+	if ps.N != 10 {
+		t.Fatalf("N = %v, want 10: NaN pairs must be dropped", ps.N)
+	}
+	if !near(ps.MeanA, 0.75, 1e-9) || !near(ps.MeanB, 2.33, 1e-9) || !near(ps.MeanDiff, 1.58, 1e-9) {
+		t.Errorf("means = %v, %v, %v, want 0.75, 2.33, 1.58", ps.MeanA, ps.MeanB, ps.MeanDiff)
+	}
+	if !near(ps.T, 4.062128, 1e-5) || !near(ps.TP, 0.002833, 1e-5) {
+		t.Errorf("t = %v, p = %v, want 4.0621, 0.002833", ps.T, ps.TP)
+	}
+	if ps.W != 45 || !near(ps.WP, 0.009091, 1e-5) {
+		t.Errorf("W+ = %v, p = %v, want 45, 0.009091", ps.W, ps.WP)
+	}
+	if !near(ps.Dz, ps.MeanDiff/ps.SDDiff, 1e-12) {
+		t.Errorf("Dz = %v, want MeanDiff / SDDiff", ps.Dz)
+	}
+
+	ps = PairedStats("one", []float64{1, nan}, []float64{2, 3}, rand.New(rand.NewSource(1)))
+	if ps.N != 1 || !math.IsNaN(ps.T) || !math.IsNaN(ps.TP) || !math.IsNaN(ps.Dz) || !math.IsNaN(ps.CILo) {
+		t.Errorf("one pair: %+v, want N = 1 and NaN t, p, dz and CI", ps)
+	}
+	ps = PairedStats("none", []float64{1, 2}, []float64{1, 2}, rand.New(rand.NewSource(1)))
+	if ps.N != 2 || ps.MeanDiff != 0 || !math.IsNaN(ps.T) || !math.IsNaN(ps.W) {
+		t.Errorf("all zero differences: %+v, want N = 2, zero MeanDiff and NaN t and W+", ps)
+	}
+}
@@ -0,0 +1,313 @@
+// Sleep watchdog (-watchdog): checks the network for NaN / Inf activations,
+// net inputs and weights, and for layers that stay silent or saturated, and
+// on a violation dumps a diagnostic snapshot and warns, kicks or aborts.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goki/gi/gi"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// Watchdog checks the network every Every cycles of sleep
+type Watchdog struct {
+	On         bool    `desc:"if false, the network is not checked"`
+	Every      int     `desc:"the network is checked every Every cycles"`
+	SilentThr  float32 `desc:"a layer whose total activity is below this is silent"`
+	SilentCycs int     `desc:"a layer silent for this many cycles is a violation -- 0 for no check"`
+	SatThr     float32 `desc:"a layer whose mean activity is at or above this is saturated"`
+	SatCycs    int     `desc:"a layer saturated for this many cycles is a violation -- 0 for no check"`
+	Action     string  `desc:"what to do on a violation, after the diagnostic dump: warn, kick (with the Kick policy action, even if it is off) or abort the batch"`
+	Hist       int     `desc:"number of recent cycles of AvgLaySim kept for the diagnostic dump"`
+
+	SimHist []float64       `view:"-" desc:"AvgLaySim of the last Hist cycles, as a ring buffer"`
+	N       int             `view:"-" desc:"number of cycles since Reset"`
+	Silent  map[string]int  `view:"-" desc:"number of cycles each layer has been silent"`
+	Sat     map[string]int  `view:"-" desc:"number of cycles each layer has been saturated"`
+	Seen    map[string]bool `view:"-" desc:"violations already dumped and logged in this sleep block"`
+}
+
+// Defaults sets the default watchdog: off, and when on, checking every 10
+// cycles, with layers silent or saturated (mean activity >= 0.95) for 2000
+// cycles as violations, and warning
+func (wd *Watchdog) Defaults() {
+	*wd = Watchdog{Every: 10, SilentThr: 0.001, SilentCycs: 2000, SatThr: 0.95, SatCycs: 2000, Action: "warn", Hist: 1000}
+}
+
+// Set sets the watchdog from spec: off, on, or a comma-separated list of
+// <key>=<value> with keys every, silent (<thr>:<cycles>), sat
+// (<thr>:<cycles>), action (warn, kick or abort) and hist
+func (wd *Watchdog) Set(spec string) error {
+	wd.Defaults()
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil
+	}
+	wd.On = true
+	if spec == "on" {
+		return nil
+	}
+	thrCycs := func(val string) (float32, int, error) {
+		args := strings.Split(val, ":")
+		if len(args) != 2 {
+			return 0, 0, fmt.Errorf("must be <threshold>:<cycles>: %v", val)
+		}
+		thr, err := strconv.ParseFloat(args[0], 32)
+		if err != nil {
+			return 0, 0, err
+		}
+		cycs, err := strconv.Atoi(args[1])
+		return float32(thr), cycs, err
+	}
+	for _, kv := range strings.Split(spec, ",") {
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			return fmt.Errorf("watchdog %v: must be <key>=<value>: %v", spec, kv)
+		}
+		key, val := strings.TrimSpace(kv[:eq]), strings.TrimSpace(kv[eq+1:])
+		var err error
+		switch key {
+		case "every":
+			wd.Every, err = strconv.Atoi(val)
+		case "silent":
+			wd.SilentThr, wd.SilentCycs, err = thrCycs(val)
+		case "sat":
+			wd.SatThr, wd.SatCycs, err = thrCycs(val)
+		case "action":
+			if val != "warn" && val != "kick" && val != "abort" {
+				return fmt.Errorf("watchdog %v: action must be warn, kick or abort", spec)
+			}
+			wd.Action = val
+		case "hist":
+			wd.Hist, err = strconv.Atoi(val)
+		default:
+			return fmt.Errorf("watchdog %v: unknown key %v (must be every, silent, sat, action or hist)", spec, key)
+		}
+		if err != nil {
+			return fmt.Errorf("watchdog %v: %v", spec, err)
+		}
+	}
+	if wd.Every < 1 || wd.Hist < 1 {
+		return fmt.Errorf("watchdog %v: every and hist must be at least 1", spec)
+	}
+	return nil
+}
+
+// Reset starts a new sleep block
+func (wd *Watchdog) Reset() {
+	wd.SimHist = wd.SimHist[:0]
+	wd.N = 0
+	wd.Silent = map[string]int{}
+	wd.Sat = map[string]int{}
+	wd.Seen = map[string]bool{}
+}
+
+// AddSim records the AvgLaySim of the current cycle
+func (wd *Watchdog) AddSim(sim float64) {
+	if len(wd.SimHist) < wd.Hist {
+		wd.SimHist = append(wd.SimHist, sim)
+	} else {
+		wd.SimHist[wd.N%wd.Hist] = sim
+	}
+	wd.N++
+}
+
+// RecentSims returns the recorded AvgLaySim history, oldest first
+func (wd *Watchdog) RecentSims() []float64 {
+	if len(wd.SimHist) < wd.Hist {
+		return wd.SimHist
+	}
+	st := wd.N % wd.Hist
+	return append(append([]float64(nil), wd.SimHist[st:]...), wd.SimHist[:st]...)
+}
+
+// BadVal returns true if v is NaN or Inf
+func BadVal(v float32) bool {
+	return math.IsNaN(float64(v)) || math.IsInf(float64(v), 0)
+}
+
+// Check checks net and returns its violations: NaN / Inf activations (Act),
+// net inputs (Ge, Inet) and weights, and layers silent for SilentCycs or
+// saturated for SatCycs cycles.  Must be called every Every cycles.
+func (wd *Watchdog) Check(net *leabra.Network) []string {
+	var viols []string
+	for _, lyi := range net.Layers {
+		ly := lyi.(leabra.LeabraLayer).AsLeabra()
+		if ly.IsOff() {
+			continue
+		}
+		nbadAct, nbadGe := 0, 0
+		actsum := float32(0)
+		for ni := range ly.Neurons {
+			nrn := &ly.Neurons[ni]
+			if nrn.IsOff() {
+				continue
+			}
+			if BadVal(nrn.Act) {
+				nbadAct++
+				continue
+			}
+			if BadVal(nrn.Ge) || BadVal(nrn.Inet) {
+				nbadGe++
+			}
+			actsum += nrn.Act
+		}
+		if nbadAct > 0 {
+			viols = append(viols, "NaNAct:"+ly.Name())
+		}
+		if nbadGe > 0 {
+			viols = append(viols, "NaNGe:"+ly.Name())
+		}
+		for _, p := range ly.SndPrjns {
+			if p.IsOff() {
+				continue
+			}
+			pj := p.(leabra.LeabraPrjn).AsLeabra()
+			for si := range pj.Syns {
+				if BadVal(pj.Syns[si].Wt) {
+					viols = append(viols, "NaNWt:"+pj.Name())
+					break
+				}
+			}
+		}
+		wd.Silent[ly.Name()] = wd.runLen(wd.Silent[ly.Name()], actsum < wd.SilentThr)
+		if wd.SilentCycs > 0 && wd.Silent[ly.Name()] >= wd.SilentCycs {
+			viols = append(viols, "Silent:"+ly.Name())
+			wd.Silent[ly.Name()] = 0
+		}
+		sat := len(ly.Neurons) > 0 && actsum/float32(len(ly.Neurons)) >= wd.SatThr
+		wd.Sat[ly.Name()] = wd.runLen(wd.Sat[ly.Name()], sat)
+		if wd.SatCycs > 0 && wd.Sat[ly.Name()] >= wd.SatCycs {
+			viols = append(viols, "Saturated:"+ly.Name())
+			wd.Sat[ly.Name()] = 0
+		}
+	}
+	return viols
+}
+
+// runLen returns the number of cycles a condition has held, from the
+// previous number n and whether it holds now
+func (wd *Watchdog) runLen(n int, holds bool) int {
+	if !holds {
+		return 0
+	}
+	return n + wd.Every
+}
+
+// WatchCyc runs the watchdog at sleep cycle cyc of block: records AvgLaySim,
+// checks the network if due, dumps a diagnostic snapshot of and logs any new
+// violation, and on every violation kicks or aborts, per the Action
+func (ss *Sim) WatchCyc(block string, cyc int) {
+	wd := &ss.Watchdog
+	if !wd.On {
+		return
+	}
+	wd.AddSim(ss.AvgLaySim)
+	if cyc%wd.Every != 0 {
+		return
+	}
+	viols := wd.Check(ss.Net)
+	if len(viols) == 0 {
+		return
+	}
+	var news []string // only new violations are dumped and logged
+	for _, v := range viols {
+		if !wd.Seen[v] {
+			wd.Seen[v] = true
+			news = append(news, v)
+		}
+	}
+	msg := ""
+	if len(news) > 0 {
+		msg = fmt.Sprintf("watchdog: run %d, %v cycle %d: %v", ss.TrainEnv.Run.Cur, block, cyc, strings.Join(news, ", "))
+		if dir := ss.WatchDump(block, cyc); dir != "" {
+			msg += " -- diagnostics saved to: " + dir
+		}
+	}
+	switch wd.Action {
+	case "warn":
+		if msg != "" {
+			log.Println(msg)
+		}
+	case "kick":
+		if msg != "" {
+			log.Println(msg + " -- kicking")
+		}
+		ss.Kick.Kick(ss.Net)
+		ss.LogKick(ss.KickLog, block, cyc, "Watchdog:"+viols[0])
+	case "abort":
+		ss.Manifest.AbortRun(ss, msg)
+		log.Fatalln(msg + " -- aborting")
+	}
+}
+
+// WatchDump saves a diagnostic snapshot of the network at sleep cycle cyc of
+// block to the run's watchdog directory: per layer activity and net input
+// stats, the recent AvgLaySim history and the weights.  Returns the prefix
+// of the files saved, empty on error.
+func (ss *Sim) WatchDump(block string, cyc int) string {
+	pfx := ss.Out.RunFile(ss.TrainEnv.Run.Cur, "watchdog", fmt.Sprintf("%v_cyc%d", block, cyc))
+	if err := os.MkdirAll(filepath.Dir(pfx), os.ModePerm); err != nil {
+		log.Println(err)
+		return ""
+	}
+	wd := &ss.Watchdog
+
+	var lays strings.Builder
+	fmt.Fprintf(&lays, "Layer\tOff\tN\tActMean\tActMax\tGeMean\tGeMax\tNBad\tSim\tSilentCycs\tSatCycs\n")
+	for _, lyi := range ss.Net.Layers {
+		ly := lyi.(leabra.LeabraLayer).AsLeabra()
+		n, nbad := 0, 0
+		asum, amax, gsum, gmax := 0.0, 0.0, 0.0, 0.0
+		for ni := range ly.Neurons {
+			nrn := &ly.Neurons[ni]
+			if BadVal(nrn.Act) || BadVal(nrn.Ge) || BadVal(nrn.Inet) {
+				nbad++
+				continue
+			}
+			n++
+			asum += float64(nrn.Act)
+			amax = math.Max(amax, float64(nrn.Act))
+			gsum += float64(nrn.Ge)
+			gmax = math.Max(gmax, float64(nrn.Ge))
+		}
+		amean, gmean := math.NaN(), math.NaN()
+		if n > 0 {
+			amean, gmean = asum/float64(n), gsum/float64(n)
+		}
+		fmt.Fprintf(&lays, "%s\t%v\t%d\t%.6g\t%.6g\t%.6g\t%.6g\t%d\t%.6g\t%d\t%d\n", ly.Name(), ly.IsOff(), len(ly.Neurons),
+			amean, amax, gmean, gmax, nbad, ly.Sim, wd.Silent[ly.Name()], wd.Sat[ly.Name()])
+	}
+
+	var sims strings.Builder
+	fmt.Fprintf(&sims, "Cycle\tAvgLaySim\n")
+	hist := wd.RecentSims()
+	for i, sim := range hist {
+		fmt.Fprintf(&sims, "%d\t%.6g\n", cyc-len(hist)+1+i, sim)
+	}
+
+	fnms := []string{pfx + "_layers.tsv", pfx + "_avglaysim.tsv"}
+	for i, s := range []string{lays.String(), sims.String()} {
+		if err := ioutil.WriteFile(fnms[i], []byte(s), 0644); err != nil {
+			log.Println(err)
+			return ""
+		}
+		ss.Manifest.AddOutput(fnms[i])
+	}
+	wfnm := pfx + ".wts.gz"
+	if err := ss.Net.SaveWtsJSON(gi.FileName(wfnm)); err != nil {
+		log.Println(err)
+	} else {
+		ss.Manifest.AddOutput(wfnm)
+	}
+	return pfx
+}
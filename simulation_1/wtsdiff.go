@@ -0,0 +1,190 @@
+// Weights diff tool (-wtsdiff): compares two weight files, e.g., snapshots
+// saved with -snapwts before and after sleep, and reports the weight changes
+// per projection, per receiving unit and for the most changed synapses.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emer/emergent/weights"
+	"github.com/emer/etable/metric"
+	"github.com/emer/etable/norm"
+)
+
+// OpenWts reads the weights file fnm (gzip compressed if it ends in .gz)
+func OpenWts(fnm string) (*weights.Network, error) {
+	f, err := os.Open(fnm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if filepath.Ext(fnm) == ".gz" {
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", fnm, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	nw := &weights.Network{}
+	if err := json.NewDecoder(r).Decode(nw); err != nil {
+		return nil, fmt.Errorf("%v: %v", fnm, err)
+	}
+	return nw, nil
+}
+
+// SynDiff is the change of one synapse between two weight files
+type SynDiff struct {
+	Prjn string
+	Ri   int     `desc:"receiving unit index"`
+	Si   int     `desc:"sending unit index"`
+	WtA  float64 `desc:"weight in file A"`
+	WtB  float64 `desc:"weight in file B"`
+}
+
+// DWt returns the weight change B - A
+func (sd *SynDiff) DWt() float64 {
+	return sd.WtB - sd.WtA
+}
+
+// PairSyns returns the synapses of projection pa (in A) that are also in pb
+// (in B), matched by receiving and sending unit
+func PairSyns(prjn string, pa, pb *weights.Prjn) []SynDiff {
+	bwts := map[[2]int]float32{}
+	for _, rb := range pb.Rs {
+		for i, si := range rb.Si {
+			bwts[[2]int{rb.Ri, si}] = rb.Wt[i]
+		}
+	}
+	var syns []SynDiff
+	for _, ra := range pa.Rs {
+		for i, si := range ra.Si {
+			wb, ok := bwts[[2]int{ra.Ri, si}]
+			if !ok {
+				continue
+			}
+			syns = append(syns, SynDiff{Prjn: prjn, Ri: ra.Ri, Si: si, WtA: float64(ra.Wt[i]), WtB: float64(wb)})
+		}
+	}
+	return syns
+}
+
+// WtsDiff compares weight files afnm (A) and bfnm (B) and writes the changes
+// B - A to out + "_prjns.tsv" (per projection: mean and max |dWt|, net
+// change, weight norms and the correlation of the A and B weight vectors),
+// out + "_units.tsv" (per projection and receiving unit) and out + "_top.tsv"
+// (the ntop synapses with the largest |dWt|).  Returns the names of the
+// files written.
+func WtsDiff(afnm, bfnm, out string, ntop int) ([]string, error) {
+	na, err := OpenWts(afnm)
+	if err != nil {
+		return nil, err
+	}
+	nb, err := OpenWts(bfnm)
+	if err != nil {
+		return nil, err
+	}
+	blays := map[string]*weights.Layer{}
+	for li := range nb.Layers {
+		blays[nb.Layers[li].Layer] = &nb.Layers[li]
+	}
+
+	var prjns, units strings.Builder
+	fmt.Fprintf(&prjns, "Prjn\tN\tMeanAbsDWt\tMaxAbsDWt\tNetDWt\tNormA\tNormB\tCorr\n")
+	fmt.Fprintf(&units, "Prjn\tUnit\tN\tSumAbsDWt\tNetDWt\n")
+	var all []SynDiff
+	for li := range na.Layers {
+		la := &na.Layers[li]
+		lb, ok := blays[la.Layer]
+		if !ok {
+			continue
+		}
+		for pi := range la.Prjns {
+			pa := &la.Prjns[pi]
+			var pb *weights.Prjn
+			for pj := range lb.Prjns {
+				if lb.Prjns[pj].From == pa.From {
+					pb = &lb.Prjns[pj]
+				}
+			}
+			if pb == nil {
+				continue
+			}
+			prjn := pa.From + "To" + la.Layer
+			syns := PairSyns(prjn, pa, pb)
+			if len(syns) == 0 {
+				continue
+			}
+			wa := make([]float64, len(syns))
+			wb := make([]float64, len(syns))
+			sabs, smax, net := 0.0, 0.0, 0.0
+			for i := range syns {
+				sd := &syns[i]
+				wa[i], wb[i] = sd.WtA, sd.WtB
+				adw := math.Abs(sd.DWt())
+				sabs += adw
+				smax = math.Max(smax, adw)
+				net += sd.DWt()
+			}
+			fmt.Fprintf(&prjns, "%s\t%d\t%.6g\t%.6g\t%.6g\t%.6g\t%.6g\t%.6g\n", prjn, len(syns), sabs/float64(len(syns)), smax, net,
+				norm.L264(wa), norm.L264(wb), metric.Correlation64(wa, wb))
+
+			// synapses are grouped by receiving unit
+			for st := 0; st < len(syns); {
+				ed := st
+				uabs, unet := 0.0, 0.0
+				for ed < len(syns) && syns[ed].Ri == syns[st].Ri {
+					uabs += math.Abs(syns[ed].DWt())
+					unet += syns[ed].DWt()
+					ed++
+				}
+				fmt.Fprintf(&units, "%s\t%d\t%d\t%.6g\t%.6g\n", prjn, syns[st].Ri, ed-st, uabs, unet)
+				st = ed
+			}
+			all = append(all, syns...)
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool { return math.Abs(all[i].DWt()) > math.Abs(all[j].DWt()) })
+	if ntop < len(all) {
+		all = all[:ntop]
+	}
+	var top strings.Builder
+	fmt.Fprintf(&top, "Prjn\tRecvUnit\tSendUnit\tWtA\tWtB\tDWt\n")
+	for i := range all {
+		sd := &all[i]
+		fmt.Fprintf(&top, "%s\t%d\t%d\t%.6g\t%.6g\t%.6g\n", sd.Prjn, sd.Ri, sd.Si, sd.WtA, sd.WtB, sd.DWt())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+		return nil, err
+	}
+	fnms := []string{out + "_prjns.tsv", out + "_units.tsv", out + "_top.tsv"}
+	for i, s := range []string{prjns.String(), units.String(), top.String()} {
+		if err := ioutil.WriteFile(fnms[i], []byte(s), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return fnms, nil
+}
+
+// WtsDiffOut returns the default output prefix of the diff of weight files
+// afnm and bfnm: wtsdiff_<A>_<B> in the directory of bfnm
+func WtsDiffOut(afnm, bfnm string) string {
+	base := func(fnm string) string {
+		fnm = strings.TrimSuffix(filepath.Base(fnm), ".gz")
+		return strings.TrimSuffix(fnm, ".wts")
+	}
+	return filepath.Join(filepath.Dir(bfnm), "wtsdiff_"+base(afnm)+"_"+base(bfnm))
+}
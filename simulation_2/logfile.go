@@ -0,0 +1,95 @@
+// Streaming of etable logs to disk, one row at a time, so that partial
+// runs (and runs that crash) still leave usable log files behind.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/emer/etable/etable"
+)
+
+// LogFile streams the rows of one etable log to a file as they are added.
+// Headers are written before the first row, and every row is written
+// straight through to the file.  All methods are safe on a nil *LogFile,
+// which means the log is not being saved.
+type LogFile struct {
+	File  *os.File      `desc:"the open log file"`
+	Delim etable.Delims `desc:"column delimiter -- Tab or Comma"`
+	Hdrs  bool          `desc:"true once the headers have been written"`
+}
+
+// LogDelim returns the etable delimiter for a -logfmt value (tsv or csv)
+func LogDelim(fmtnm string) (etable.Delims, error) {
+	switch fmtnm {
+	case "tsv":
+		return etable.Tab, nil
+	case "csv":
+		return etable.Comma, nil
+	}
+	return etable.Tab, fmt.Errorf("unknown log format: %v (must be tsv or csv)", fmtnm)
+}
+
+// LogExt returns the file extension for the given delimiter
+func LogExt(delim etable.Delims) string {
+	if delim == etable.Comma {
+		return ".csv"
+	}
+	return ".tsv"
+}
+
+// CreateLogFile creates fnm (and its directory) for streaming a log
+func CreateLogFile(fnm string, delim etable.Delims) (*LogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(fnm), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(fnm)
+	if err != nil {
+		return nil, err
+	}
+	return &LogFile{File: f, Delim: delim}, nil
+}
+
+// OpenLogFile creates the batch-level log file for log lognm, in the
+// -logfmt format, and records it in the manifest.  desc names the log in the
+// message printed.  Returns nil (no logging) if the file can't be created.
+func (ss *Sim) OpenLogFile(lognm, desc string) *LogFile {
+	fnm := ss.Out.BatchFile(ss.LogFileName(lognm))
+	lf, err := CreateLogFile(fnm, ss.LogDelim)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	ss.Manifest.AddOutput(fnm)
+	fmt.Printf("Saving %v log to: %v\n", desc, fnm)
+	return lf
+}
+
+// WriteRow writes the given row of dt, preceded by the headers if this is
+// the first row written.  Errors are logged, not returned, as a failed log
+// write should not stop the simulation.
+func (lf *LogFile) WriteRow(dt *etable.Table, row int) {
+	if lf == nil {
+		return
+	}
+	if !lf.Hdrs {
+		if _, err := dt.WriteCSVHeaders(lf.File, lf.Delim); err != nil {
+			log.Println(err)
+		}
+		lf.Hdrs = true
+	}
+	if err := dt.WriteCSVRow(lf.File, row, lf.Delim); err != nil {
+		log.Println(err)
+	}
+}
+
+// Close closes the file
+func (lf *LogFile) Close() {
+	if lf == nil {
+		return
+	}
+	lf.File.Close()
+}
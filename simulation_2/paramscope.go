@@ -0,0 +1,240 @@
+// Parameter scopes: the parameters of every layer and projection are
+// snapshot before a phase that changes them by hand (sleep), and restored
+// exactly afterward, logging the parameters the phase changed.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/schapirolab/leabra-sleep/hip"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// LayScope is the snapshot of the parameters of a layer
+type LayScope struct {
+	Lay   *leabra.Layer
+	Act   leabra.ActParams
+	Inhib leabra.InhibParams
+	Learn leabra.LearnNeurParams
+	Vals  map[string]string `desc:"the parameters as <struct>.<field>... -> value, for the diffs"`
+}
+
+// PrjnScope is the snapshot of the parameters of a projection
+type PrjnScope struct {
+	Prjn    leabra.LeabraPrjn
+	WtInit  leabra.WtInitParams
+	WtScale leabra.WtScaleParams
+	Learn   leabra.LearnSynParams
+	CHL     *hip.CHLParams `desc:"CHL params of the projection, nil if it is not a CHLPrjn"`
+	CHLVal  hip.CHLParams
+	Vals    map[string]string `desc:"the parameters as <struct>.<field>... -> value, for the diffs"`
+}
+
+// ParamDiff is a parameter whose value differs from its snapshot
+type ParamDiff struct {
+	Obj    string
+	Param  string
+	Before string
+	After  string
+}
+
+// ParamScope is a snapshot of the parameters of all the layers and
+// projections of a network
+type ParamScope struct {
+	Name  string
+	Lays  []LayScope
+	Prjns []PrjnScope
+}
+
+// NewParamScope snapshots the parameters of net in a scope named name
+func NewParamScope(name string, net *leabra.Network) *ParamScope {
+	ps := &ParamScope{Name: name}
+	for _, lyi := range net.Layers {
+		ly := lyi.(leabra.LeabraLayer).AsLeabra()
+		ps.Lays = append(ps.Lays, LayScope{Lay: ly, Act: ly.Act, Inhib: ly.Inhib, Learn: ly.Learn, Vals: LayParamVals(ly)})
+		for _, p := range ly.SndPrjns {
+			lp := p.(leabra.LeabraPrjn)
+			pj := lp.AsLeabra()
+			psc := PrjnScope{Prjn: lp, WtInit: pj.WtInit, WtScale: pj.WtScale, Learn: pj.Learn, Vals: PrjnParamVals(lp)}
+			if cp, ok := p.(*hip.CHLPrjn); ok {
+				psc.CHL, psc.CHLVal = &cp.CHL, cp.CHL
+			}
+			ps.Prjns = append(ps.Prjns, psc)
+		}
+	}
+	return ps
+}
+
+// Restore returns all the parameters of the scope to their snapshot
+func (ps *ParamScope) Restore() {
+	for i := range ps.Lays {
+		ls := &ps.Lays[i]
+		ls.Lay.Act, ls.Lay.Inhib, ls.Lay.Learn = ls.Act, ls.Inhib, ls.Learn
+	}
+	for i := range ps.Prjns {
+		psc := &ps.Prjns[i]
+		pj := psc.Prjn.AsLeabra()
+		pj.WtInit, pj.WtScale, pj.Learn = psc.WtInit, psc.WtScale, psc.Learn
+		if psc.CHL != nil {
+			*psc.CHL = psc.CHLVal
+		}
+	}
+}
+
+// RestoreGi returns the layer inhibition (Inhib.Layer.Gi) of all the layers
+// to its snapshot, as the base of the inhibitory oscillation
+func (ps *ParamScope) RestoreGi() {
+	for i := range ps.Lays {
+		ps.Lays[i].Lay.Inhib.Layer.Gi = ps.Lays[i].Inhib.Layer.Gi
+	}
+}
+
+// Diffs returns the parameters whose current value differs from their
+// snapshot
+func (ps *ParamScope) Diffs() []ParamDiff {
+	var dfs []ParamDiff
+	for i := range ps.Lays {
+		ls := &ps.Lays[i]
+		dfs = append(dfs, ValDiffs(ls.Lay.Name(), ls.Vals, LayParamVals(ls.Lay))...)
+	}
+	for i := range ps.Prjns {
+		psc := &ps.Prjns[i]
+		dfs = append(dfs, ValDiffs(psc.Prjn.Name(), psc.Vals, PrjnParamVals(psc.Prjn))...)
+	}
+	return dfs
+}
+
+// LayParamVals returns the parameters of layer ly, as
+// <struct>.<field>... -> value
+func LayParamVals(ly *leabra.Layer) map[string]string {
+	vals := map[string]string{}
+	FlatParams("Act", &ly.Act, vals)
+	FlatParams("Inhib", &ly.Inhib, vals)
+	FlatParams("Learn", &ly.Learn, vals)
+	return vals
+}
+
+// PrjnParamVals returns the parameters of projection p, as
+// <struct>.<field>... -> value
+func PrjnParamVals(p leabra.LeabraPrjn) map[string]string {
+	pj := p.AsLeabra()
+	vals := map[string]string{}
+	FlatParams("WtInit", &pj.WtInit, vals)
+	FlatParams("WtScale", &pj.WtScale, vals)
+	FlatParams("Learn", &pj.Learn, vals)
+	if cp, ok := p.(*hip.CHLPrjn); ok {
+		FlatParams("CHL", &cp.CHL, vals)
+	}
+	return vals
+}
+
+// FlatParams adds the fields of params struct v to vals, by their JSON path
+// under pfx
+func FlatParams(pfx string, v interface{}, vals map[string]string) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var jv interface{}
+	if err := json.Unmarshal(b, &jv); err != nil {
+		log.Println(err)
+		return
+	}
+	flatJSON(pfx, jv, vals)
+}
+
+func flatJSON(pfx string, jv interface{}, vals map[string]string) {
+	if m, ok := jv.(map[string]interface{}); ok {
+		for k, sv := range m {
+			flatJSON(pfx+"."+k, sv, vals)
+		}
+		return
+	}
+	vals[pfx] = fmt.Sprint(jv)
+}
+
+// ValDiffs returns the parameters of obj whose value in after differs from
+// before, sorted by name
+func ValDiffs(obj string, before, after map[string]string) []ParamDiff {
+	var nms []string
+	for nm, bv := range before {
+		if after[nm] != bv {
+			nms = append(nms, nm)
+		}
+	}
+	sort.Strings(nms)
+	dfs := make([]ParamDiff, len(nms))
+	for i, nm := range nms {
+		dfs[i] = ParamDiff{Obj: obj, Param: nm, Before: before[nm], After: after[nm]}
+	}
+	return dfs
+}
+
+// StartParamScope snapshots the parameters of the network before the phase
+// (sleep block) block
+func (ss *Sim) StartParamScope(block string) *ParamScope {
+	return NewParamScope(block, ss.Net)
+}
+
+// EndParamScope restores the parameters of the network to the snapshot of
+// ps at the end of its phase, logging the parameters the phase changed as
+// restored, and any that still differ afterward as differs, with a warning.
+// It is deferred, so that the parameters are restored however the phase ends.
+func (ss *Sim) EndParamScope(ps *ParamScope) {
+	chg := ps.Diffs()
+	ps.Restore()
+	for i := range chg {
+		ss.LogParamScope(ss.ParamLog, ps.Name, "restored", &chg[i])
+	}
+	for _, df := range ps.Diffs() {
+		log.Printf("param scope %v: %v %v differs after restore: %v, was %v\n", ps.Name, df.Obj, df.Param, df.After, df.Before)
+		ss.LogParamScope(ss.ParamLog, ps.Name, "differs", &df)
+	}
+}
+
+// LogParamScope adds a row to the ParamLog for parameter diff df of scope
+// scope: restored (changed by the phase and restored) or differs (different
+// from the snapshot after the restore)
+func (ss *Sim) LogParamScope(dt *etable.Table, scope, kind string, df *ParamDiff) {
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Scope", row, scope)
+	dt.SetCellString("Kind", row, kind)
+	dt.SetCellString("Obj", row, df.Obj)
+	dt.SetCellString("Param", row, df.Param)
+	dt.SetCellString("Before", row, df.Before)
+	dt.SetCellString("After", row, df.After)
+
+	ss.ParamFile.WriteRow(dt, row)
+}
+
+// ConfigParamLog configures the ParamLog: one row per parameter changed by a
+// parameter scope
+func (ss *Sim) ConfigParamLog(dt *etable.Table) {
+	dt.SetMetaData("name", "ParamLog")
+	dt.SetMetaData("desc", "Parameters changed by each sleep block, restored at its end")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	dt.SetFromSchema(etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Scope", etensor.STRING, nil, nil},
+		{"Kind", etensor.STRING, nil, nil},
+		{"Obj", etensor.STRING, nil, nil},
+		{"Param", etensor.STRING, nil, nil},
+		{"Before", etensor.STRING, nil, nil},
+		{"After", etensor.STRING, nil, nil},
+	}, 0)
+}
@@ -11,7 +11,6 @@ import (
 	"encoding/csv"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
@@ -19,10 +18,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/emer/emergent/patgen"
-
 	"github.com/goki/ki/bitflag"
 
 	"github.com/schapirolab/leabra-sleep/hip"
@@ -76,12 +74,13 @@ const LogPrec = 4
 // for the fields which provide hints to how things should be displayed).
 type Sim struct {
 	Net  *leabra.Network `view:"no-inline"`
-	Pats *etable.Table   `view:"no-inline" desc:"the training patterns to use"` // ra25
+	Pats *etable.Table   `view:"no-inline" desc:"the training patterns of all the environments, the mixed source of structured sleep"`
+
+	Envs    []*SeqEnv `desc:"the environments learned in sequence, each with its training and testing patterns (-envs)"`
+	EnvIdx  int       `inactive:"+" desc:"index in Envs of the environment being trained"`
+	EnvCfgd bool      `view:"-" desc:"whether the hippocampus configuration of the environment being trained has been applied"`
 
-	TrainAB *etable.Table `view:"no-inline" desc:"AB training patterns to use"`
-	TrainAC *etable.Table `view:"no-inline" desc:"AC training patterns to use"`
-	TestAB  *etable.Table `view:"no-inline" desc:"AB testing patterns to use"`
-	TestAC  *etable.Table `view:"no-inline" desc:"AC testing patterns to use"`
+	CTXLrates []float32 `view:"-" desc:"params learning rates of the CTXLratePrjns, restored for the environments learned without the hippocampus"`
 
 	TrnTrlLog    *etable.Table     `view:"no-inline" desc:"training trial-level log data"`
 	TrnEpcLog    *etable.Table     `view:"no-inline" desc:"training epoch-level log data"`
@@ -92,6 +91,14 @@ type Sim struct {
 	TstCycLog    *etable.Table     `view:"no-inline" desc:"testing cycle-level log data"`
 	RunLog       *etable.Table     `view:"no-inline" desc:"summary log of each run"`
 	RunStats     *etable.Table     `view:"no-inline" desc:"aggregate stats on all runs"`
+	RSALog       *etable.Table     `view:"no-inline" desc:"representational similarity summaries of the RSA layers at each milestone"`
+	WtChgLog     *etable.Table     `view:"no-inline" desc:"weight changes of each projection over each wake and sleep phase"`
+	SlpTrlLog    *etable.Table     `view:"no-inline" desc:"record of each sleep learning trial (plus / minus contrast)"`
+	KickLog      *etable.Table     `view:"no-inline" desc:"record of each attractor escape kick during sleep"`
+	DownscaleLog *etable.Table     `view:"no-inline" desc:"synaptic downscaling of each target projection over each sleep block"`
+	ParamLog     *etable.Table     `view:"no-inline" desc:"parameters changed by each sleep block, restored at its end"`
+	StrucSlpLog  *etable.Table     `view:"no-inline" desc:"record of each structured sleep trial"`
+	SessLog      *etable.Table     `view:"no-inline" desc:"test results at the end of each session of the protocol"`
 	TstStats     *etable.Table     `view:"no-inline" desc:"testing stats"`
 	Params       params.Sets       `view:"no-inline" desc:"full collection of param sets"`
 	ParamSet     string            `desc:"which set of *additional* parameters to use -- always applies Base and optionaly this next if set"`
@@ -109,6 +116,7 @@ type Sim struct {
 	TestInterval int               `desc:"how often to run through all the test patterns, in terms of training epochs -- can use 0 or -1 for no testing"`
 
 	// StructSleep Implementation vars
+	StrucSlp        StrucSleep        `desc:"structured sleep parameters (-strucsleep)"`
 	StrucSleepUpdt  leabra.TimeScales `desc:"at what time scale to update the display during strucsleep?  Anything longer than Epoch updates at Epoch in this model"`
 	OscillStartCyc  int               `desc:"Structured sleep oscillation start cycle in minus phase -- 1 is default and means starting on the first minus phase cycle"`
 	OscillStopCyc   int               `desc:"Structured sleep oscillation stop cycle in minus phase -- 75 is default and means stopping on the last minus phase cycle"`
@@ -122,6 +130,7 @@ type Sim struct {
 	SlpCycLog         *etable.Table     `view:"no-inline" desc:"sleeping cycle-level log data"`
 	SlpCycPlot        *eplot.Plot2D     `view:"-" desc:"the sleeping cycle plot"`
 	MaxSlpCyc         int               `desc:"maximum number of cycle to sleep for a trial"`
+	SlpCycEvery       int               `desc:"if > 0, the SlpCycLog of each sleep block is saved to its own file, every this many cycles (-slpcyclog)"`
 	Sleep             bool              `desc:"Sleep or not"`
 	LrnDrgSlp         bool              `desc:"Learning during sleep?"`
 	SlpPlusThr        float32           `desc:"The threshold for entering a sleep plus phase"`
@@ -132,6 +141,9 @@ type Sim struct {
 	AvgLaySim         float64           `desc:"Average layer similaity between this cycle and last cycle"`
 	SynDep            bool              `desc:"Syn Dep during sleep?"`
 	SlpLearn          bool              `desc:"Learn during sleep?"`
+	SlpDWt            bool              `desc:"apply the sleep learning rule (SlpDWt) at the end of each sleep trial -- if false, sleep trials are detected and logged without learning"`
+	SlpRules          SlpRules          `desc:"sleep learning rule of each projection (-slprule)"`
+	SlpMask           SlpMask           `desc:"sleep-time learning mask and learning rates of each sleep stage (-slpmask)"`
 	PlusPhase         bool              `desc:"Sleep Plusphase on/off"`
 	MinusPhase        bool              `desc:"Sleep Minusphase on/off"`
 	ZError            int               `desc:"Consec Zero error epochs"`
@@ -154,61 +166,83 @@ type Sim struct {
 	FirstZero     int     `inactive:"+" desc:"epoch at when SSE first went to zero"`
 	NZero         int     `inactive:"+" desc:"number of epochs in a row with zero SSE"`
 
-	ABZero       bool     `inactive:"+" desc:"AB Testing Zero SSE"`
-	ACZero       bool     `inactive:"+" desc:"AC Testing Zero SSE"`
-	TrainABSSE   float64  `inactive:"+" desc:"AB Training SSE"`
-	TrainACSSE   float64  `inactive:"+" desc:"AC Training SSE"`
-	TestABSSE    float64  `inactive:"+" desc:"AB Testing SSE"`
-	TestACSSE    float64  `inactive:"+" desc:"AC Testing SSE"`
 	TestNm       string   `desc:"Which Test"`
-	TstStatNms   []string `view:"-" desc:"Stats to split between AB, AC"`
-	TestABCor    float64  `inactive:"+" desc:"AB Training Cor"` // For Sleep Thresh
-	TestACCor    float64  `inactive:"+" desc:"AC Training Cor"`
+	TstStatNms   []string `view:"-" desc:"Stats to split between the environments"`
 	SleepStage   string   `inactive:"+" desc:"Stage of Sleep being run"`
 	SWSCounter   int      `inactive:"+" desc:"Number of SWS blocks run"`
 	REMCounter   int      `inactive:"+" desc:"Number of REM blocks run"`
 	SleepCounter int      `inactive:"+" desc:"Number Sleep blocks run"`
 
-	ClosestABA      int     `view:"-" desc:"Closest A"`
-	ClosestABAMatch float32 `view:"-" desc:"Closest A Match %"`
-	ClosestABB      int     `view:"-" desc:"Closest B"`
-	ClosestABBMatch float32 `view:"-" desc:"Closest B Match %"`
-	ClosestACA      int     `view:"-" desc:"Closest A''"`
-	ClosestACAMatch float32 `view:"-" desc:"Closest A Match %"`
-	ClosestACC      int     `view:"-" desc:"Closest C"`
-	ClosestACCMatch float32 `view:"-" desc:"Closest B Match %"`
+	Protocol    []ProtoStep `desc:"steps run once all the environments have been learned (-protocol)"`
+	SlpConds    []SlpCond   `desc:"conditions the protocol is run under, each from the weights once all the environments have been learned (-slpconds)"`
+	SlpCond     SlpCond     `inactive:"+" desc:"sleep condition of the protocol in progress -- empty outside the protocol"`
+	SlpCondsRun []string    `view:"-" desc:"sleep conditions the protocol was run under this run"`
+	Sess        int         `inactive:"+" desc:"current session of the protocol: the number of the step in progress, 0 before the protocol"`
+	TotSlpTrls  int         `inactive:"+" desc:"number of sleep learning trials over all the sleep steps of the protocol so far"`
+
+	// RSA
+	RSAAt   []string       `desc:"protocol milestones at which to run representational similarity analysis -- any of RSAMilestones (-rsa)"`
+	RSALays []string       `desc:"layers whose settled ActM patterns are compared by RSA (-rsalays)"`
+	RSAEnv  env.FixedTable `view:"-" desc:"environment presenting the RSA items"`
+
+	SnapWtsAt []string `desc:"protocol milestones at which to save a snapshot of the weights -- any of SnapMilestones (-snapwts)"`
+
+	WtChg  WtChgAcct `view:"-" desc:"per-projection weight change accounting of the current wake or sleep phase"`
+	SlpTrl SlpTrl    `view:"-" desc:"the sleep learning trial in progress"`
+
+	Stability  map[string]StabilityMetric `view:"-" desc:"network stability metric (AvgLaySim) of each of the SleepStages (-stability)"`
+	StabActThr float32                    `desc:"layers with less total activity than this count as unstable in the per-layer stability metrics (-stabactthr)"`
+	SlpThr     SlpThresh                  `desc:"plus / minus phase thresholds of sleep learning on AvgLaySim (-slpthr)"`
+	Kick       KickPolicy                 `desc:"attractor escape policy during sleep (-kick)"`
+	Watchdog   Watchdog                   `desc:"NaN / runaway activity watchdog during sleep (-watchdog)"`
+	Downscale  Downscale                  `desc:"synaptic downscaling during sleep (-downscale)"`
+	TMR        []TMRCue                   `desc:"targeted memory reactivation cue schedule during sleep (-tmr)"`
+	TMRActive  []string                   `view:"-" desc:"items cued on the current sleep cycle"`
 
 	// internal state - view:"-"
-	SumErr       float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
-	SumSSE       float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
-	SumAvgSSE    float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
-	SumCosDiff   float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
-	Win          *gi.Window                  `view:"-" desc:"main GUI window"`
-	NetView      *netview.NetView            `view:"-" desc:"the network viewer"`
-	ToolBar      *gi.ToolBar                 `view:"-" desc:"the master toolbar"`
-	TrnTrlPlot   *eplot.Plot2D               `view:"-" desc:"the training trial plot"`
-	TrnEpcPlot   *eplot.Plot2D               `view:"-" desc:"the training epoch plot"`
-	TstEpcPlot   *eplot.Plot2D               `view:"-" desc:"the testing epoch plot"`
-	TstTrlPlot   *eplot.Plot2D               `view:"-" desc:"the test-trial plot"`
-	TstCycPlot   *eplot.Plot2D               `view:"-" desc:"the test-cycle plot"`
-	RunPlot      *eplot.Plot2D               `view:"-" desc:"the run plot"`
-	TrnEpcFile   *os.File                    `view:"-" desc:"log file"`
-	RunFile      *os.File                    `view:"-" desc:"log file"`
-	ValsTsrs     map[string]*etensor.Float32 `view:"-" desc:"for holding layer values"`
-	TmpVals      []float32                   `view:"-" desc:"temp slice for holding values -- prevent mem allocs"`
-	LayStatNms   []string                    `view:"-" desc:"names of layers to collect more detailed stats on (avg act, etc)"`
-	TstNms       []string                    `view:"-" desc:"names of test tables"`
-	SaveWts      bool                        `view:"-" desc:"for command-line run only, auto-save final weights after each run"`
-	NoGui        bool                        `view:"-" desc:"if true, runing in no GUI mode"`
-	LogSetParams bool                        `view:"-" desc:"if true, print message for all params that are set"`
-	IsRunning    bool                        `view:"-" desc:"true if sim is running"`
-	StopNow      bool                        `view:"-" desc:"flag to stop running"`
-	NeedsNewRun  bool                        `view:"-" desc:"flag to initialize NewRun if last one finished"`
-	RndSeed      int64                       `view:"-" desc:"the current random seed"`
-	DirSeed      int64                       `view:"-" desc:"the current random seed for dir"`
-	LastEpcTime  time.Time                   `view:"-" desc:"timer for last epoch"`
-	ABover       int                         `view:"-" desc:"Overtrain counter AB"`
-	ACover       int                         `view:"-" desc:"Overtrain counter AC"`
+	SumErr        float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
+	SumSSE        float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
+	SumAvgSSE     float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
+	SumCosDiff    float64                     `view:"-" inactive:"+" desc:"sum to increment as we go through epoch"`
+	Win           *gi.Window                  `view:"-" desc:"main GUI window"`
+	NetView       *netview.NetView            `view:"-" desc:"the network viewer"`
+	ToolBar       *gi.ToolBar                 `view:"-" desc:"the master toolbar"`
+	TrnTrlPlot    *eplot.Plot2D               `view:"-" desc:"the training trial plot"`
+	TrnEpcPlot    *eplot.Plot2D               `view:"-" desc:"the training epoch plot"`
+	TstEpcPlot    *eplot.Plot2D               `view:"-" desc:"the testing epoch plot"`
+	TstTrlPlot    *eplot.Plot2D               `view:"-" desc:"the test-trial plot"`
+	TstCycPlot    *eplot.Plot2D               `view:"-" desc:"the test-cycle plot"`
+	RunPlot       *eplot.Plot2D               `view:"-" desc:"the run plot"`
+	TrnEpcFile    *LogFile                    `view:"-" desc:"training epoch log file"`
+	TstEpcFile    *LogFile                    `view:"-" desc:"testing epoch log file"`
+	RunFile       *LogFile                    `view:"-" desc:"run log file"`
+	TrnTrlFile    *LogFile                    `view:"-" desc:"training trial log file"`
+	TstTrlFile    *LogFile                    `view:"-" desc:"testing trial log file"`
+	RSAFile       *LogFile                    `view:"-" desc:"RSA log file"`
+	WtChgFile     *LogFile                    `view:"-" desc:"weight change log file"`
+	SlpTrlFile    *LogFile                    `view:"-" desc:"sleep learning trial log file"`
+	KickFile      *LogFile                    `view:"-" desc:"sleep kick log file"`
+	DownscaleFile *LogFile                    `view:"-" desc:"downscaling log file"`
+	ParamFile     *LogFile                    `view:"-" desc:"parameter scope log file"`
+	SlpCycFile    *LogFile                    `view:"-" desc:"sleep cycle log file of the current sleep block"`
+	StrucSlpFile  *LogFile                    `view:"-" desc:"structured sleep log file"`
+	SessFile      *LogFile                    `view:"-" desc:"session log file"`
+	LogDelim      etable.Delims               `view:"-" desc:"column delimiter of the log files (-logfmt)"`
+	ValsTsrs      map[string]*etensor.Float32 `view:"-" desc:"for holding layer values"`
+	TmpVals       []float32                   `view:"-" desc:"temp slice for holding values -- prevent mem allocs"`
+	LayStatNms    []string                    `view:"-" desc:"names of layers to collect more detailed stats on (avg act, etc)"`
+	TstNms        []string                    `view:"-" desc:"names of test tables"`
+	SaveWts       bool                        `view:"-" desc:"for command-line run only, auto-save final weights after each run"`
+	NoGui         bool                        `view:"-" desc:"if true, runing in no GUI mode"`
+	LogSetParams  bool                        `view:"-" desc:"if true, print message for all params that are set"`
+	IsRunning     bool                        `view:"-" desc:"true if sim is running"`
+	StopNow       bool                        `view:"-" desc:"flag to stop running"`
+	NeedsNewRun   bool                        `view:"-" desc:"flag to initialize NewRun if last one finished"`
+	RndSeed       int64                       `view:"-" desc:"the current random seed"`
+	DirSeed       int64                       `view:"-" desc:"master random seed of the current batch -- the default batch ID"`
+	Manifest      *Manifest                   `view:"-" desc:"provenance manifest of the current batch of runs"`
+	Out           OutputLayout                `view:"-" desc:"where output files of the batch are written"`
+	LastEpcTime   time.Time                   `view:"-" desc:"timer for last epoch"`
 }
 
 // this registers this Sim Type and gives it properties that e.g.,
@@ -224,9 +258,6 @@ func (ss *Sim) New() {
 	ss.MaxEpcs = 120
 	ss.MaxRuns = 100
 	ss.Net = &leabra.Network{}
-	ss.Pats = &etable.Table{} // ra25
-	ss.TrainAB = &etable.Table{}
-	ss.TrainAC = &etable.Table{}
 	ss.TrnTrlLog = &etable.Table{}
 	ss.TrnEpcLog = &etable.Table{}
 	ss.TstEpcLog = &etable.Table{}
@@ -234,17 +265,38 @@ func (ss *Sim) New() {
 	ss.TstCycLog = &etable.Table{}
 	ss.RunLog = &etable.Table{}
 	ss.RunStats = &etable.Table{}
+	ss.RSALog = &etable.Table{}
+	ss.WtChgLog = &etable.Table{}
+	ss.SlpTrlLog = &etable.Table{}
+	ss.KickLog = &etable.Table{}
+	ss.DownscaleLog = &etable.Table{}
+	ss.ParamLog = &etable.Table{}
+	ss.StrucSlpLog = &etable.Table{}
+	ss.SessLog = &etable.Table{}
 	ss.Params = SavedParamsSets
 	ss.ViewOn = true
 	ss.TrainUpdt = leabra.AlphaCycle
 	ss.TestUpdt = leabra.AlphaCycle
 	ss.StrucSleepUpdt = leabra.AlphaCycle
+	ss.StrucSlp.Defaults()
 	ss.TestInterval = 1
 	ss.LogSetParams = false
 	ss.LayStatNms = []string{"Input", "Output"}
+	ss.RSALays = []string{"CTX", "DG", "CA3", "pCA1", "dCA1"}
+	ss.StabActThr = DefStabActThr
+	ss.SetStability("")
+	ss.SlpThr.Defaults()
+	ss.Kick.Defaults()
+	ss.Watchdog.Defaults()
+	ss.Downscale.Defaults()
+	ss.SlpMask.Set(DefSlpMask)
+	ss.Protocol, _ = ParseProtocol(DefProtocol)
+	ss.SlpConds, _ = ParseSlpConds(DefSlpConds)
 	ss.TrialPerEpc = 10
 	ss.TstWrtOut = true         // true to output tst trl acts
 	ss.SlpPatMatchWrtOut = true // true to output sleep pattern deecoding
+	ss.Out.Root = "output"
+	ss.LogDelim = etable.Tab
 
 	ss.SlpCycLog = &etable.Table{}
 	ss.Sleep = false
@@ -253,6 +305,7 @@ func (ss *Sim) New() {
 	ss.MaxSlpCyc = 50000
 	ss.SynDep = true
 	ss.SlpLearn = true
+	ss.SlpDWt = true
 	ss.PlusPhase = false
 	ss.MinusPhase = false
 	ss.ExecSleep = true
@@ -268,14 +321,10 @@ func (ss *Sim) New() {
 	ss.SWSCounter = 0
 	ss.REMCounter = 0
 
-	ss.ABZero = false
-	ss.TestNm = "AB"
-	ss.TstNms = []string{"AB", "AC"}
+	ss.SetEnvs(DefEnvs)
+	ss.TestNm = ss.Envs[0].Name
 	ss.TstStatNms = []string{"Err", "SSE", "AvgSSE"}
 
-	ss.ABover = 0
-	ss.ACover = 0
-
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
@@ -285,6 +334,7 @@ func (ss *Sim) New() {
 func (ss *Sim) Config() {
 
 	ss.OpenPats()        // done
+	ss.ConfigPats()      // mixed source of structured sleep
 	ss.ConfigEnv()       // done except sleep
 	ss.ConfigNet(ss.Net) // done
 	ss.ConfigTrnTrlLog(ss.TrnTrlLog)
@@ -293,6 +343,14 @@ func (ss *Sim) Config() {
 	ss.ConfigTstTrlLog(ss.TstTrlLog)
 	ss.ConfigTstCycLog(ss.TstCycLog)
 	ss.ConfigRunLog(ss.RunLog)
+	ss.ConfigRSALog(ss.RSALog)
+	ss.ConfigWtChgLog(ss.WtChgLog)
+	ss.ConfigSlpTrlLog(ss.SlpTrlLog)
+	ss.ConfigKickLog(ss.KickLog)
+	ss.ConfigDownscaleLog(ss.DownscaleLog)
+	ss.ConfigParamLog(ss.ParamLog)
+	ss.ConfigStrucSlpLog(ss.StrucSlpLog)
+	ss.ConfigSessLog(ss.SessLog)
 
 	ss.ConfigSlpCycLog(ss.SlpCycLog)
 }
@@ -308,20 +366,19 @@ func (ss *Sim) ConfigEnv() {
 
 	ss.TrainEnv.Nm = "TrainEnv"
 	ss.TrainEnv.Dsc = "training params and state"
-	ss.TrainEnv.Table = etable.NewIdxView(ss.TrainAB)
+	ss.TrainEnv.Table = etable.NewIdxView(ss.Envs[0].Pats)
 	ss.TrainEnv.Validate()
 	ss.TrainEnv.Run.Max = ss.MaxRuns // note: we are not setting epoch max -- do that manually
 
 	ss.TestEnv.Nm = "TestEnv"
 	ss.TestEnv.Dsc = "testing params and state"
-	ss.TestEnv.Table = etable.NewIdxView(ss.TrainAB)
+	ss.TestEnv.Table = etable.NewIdxView(ss.Envs[0].Pats)
 	ss.TestEnv.Sequential = true
 	ss.TestEnv.Validate()
 
 	ss.SleepEnv.Nm = "SleepEnv"
 	ss.SleepEnv.Dsc = "sleep params and state"
-	ss.SleepEnv.Table = etable.NewIdxView(ss.Pats)
-	ss.SleepEnv.Sequential = true
+	ss.SleepEnv.Table = ss.StrucPats()
 	ss.SleepEnv.Validate()
 
 	ss.TrainEnv.Init(0)
@@ -422,6 +479,7 @@ func (ss *Sim) ConfigNet(net *leabra.Network) {
 		return
 	}
 	net.InitWts()
+	ss.SaveCTXLrates()
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -435,6 +493,7 @@ func (ss *Sim) Init() {
 	// selected or patterns have been modified etc
 	ss.StopNow = false
 	ss.SetParams("", ss.LogSetParams) // all sheets
+	ss.SaveCTXLrates()                // as set by the params, e.g. a -paramsfile
 	ss.NewRun()
 	ss.UpdateView("train")
 }
@@ -452,25 +511,21 @@ func (ss *Sim) NewRndSeed() {
 func (ss *Sim) Counters(state string) string { // changed from boolean to string
 	if state == "train" {
 		return fmt.Sprintf("Run:"+" "+"%d\tEpoch:"+" "+"%d\tTrial:"+" "+"%d\tCycle:"+" "+"%d\tName:"+
-			" "+"%s\t TrialSSE:"+" "+"%.2f\t LastEpcSSE:"+" "+"%.2f\t\t\n TrainABSSE:"+" "+"%.2f\tTrainACSSE:"+
-			" "+"%.2f\tTestABSSE:"+" "+"%.2f\tTestACSSE:"+" "+"%.2f\t\t", ss.TrainEnv.Run.Cur, ss.TrainEnv.Epoch.Cur,
+			" "+"%s\t TrialSSE:"+" "+"%.2f\t LastEpcSSE:"+" "+"%.2f\t\t\n%s\t\t", ss.TrainEnv.Run.Cur, ss.TrainEnv.Epoch.Cur,
 			ss.TrainEnv.Trial.Cur, ss.Time.Cycle, fmt.Sprintf(ss.TrainEnv.TrialName.Cur), ss.TrlSSE, ss.DispAvgEpcSSE,
-			ss.TrainABSSE, ss.TrainACSSE, ss.TestABSSE, ss.TestACSSE)
+			ss.EnvSSECounters())
 	} else if state == "test" {
 		return fmt.Sprintf("Run:"+" "+"%d\tEpoch:"+" "+"%d\tTrial:"+" "+"%d\tCycle:"+" "+"%d\tName:"+
-			" "+"%s\t TrialSSE:"+" "+"%.2f\t LastEpcSSE:"+" "+"%.2f\t\t\n TrainABSSE:"+" "+"%.2f\tTrainACSSE:"+" "+
-			"%.2f\tTestABSSE:"+" "+"%.2f\tTestACSSE:"+" "+"%.2f\t\t", ss.TrainEnv.Run.Cur, ss.TrainEnv.Epoch.Cur,
+			" "+"%s\t TrialSSE:"+" "+"%.2f\t LastEpcSSE:"+" "+"%.2f\t\t\n%s\t\t", ss.TrainEnv.Run.Cur, ss.TrainEnv.Epoch.Cur,
 			ss.TrainEnv.Trial.Cur, ss.Time.Cycle, fmt.Sprintf(ss.TrainEnv.TrialName.Cur), ss.TrlSSE, ss.DispAvgEpcSSE,
-			ss.TrainABSSE, ss.TrainACSSE, ss.TestABSSE, ss.TestACSSE)
+			ss.EnvSSECounters())
 	} else if state == "sleep" {
 		return fmt.Sprintf("Run:"+" "+"%d\tEpoch:"+" "+"%d\tCycle:"+" "+"%d\tInhibFactor: "+" "+
 			"%.6f\tAvgLaySim: "+" "+"%.10f\t\t\t\nPlusPhase:"+" "+"%t\t MinusPhase:"+""+
-			" "+"%t\t NearA:"+" "+"%d\tMatchA:"+" "+"%.2f\tNearB:"+" "+"%d\tMatchB:"+" "+"%.2f\t\t\t\nNearA':"+
-			" "+"%d\tMatchA':"+" "+"%.2f\tNearC:"+" "+"%d\tMatchC:"+" "+"%.2f\tSlpTrls:"+" "+"%d\tSlpStage:"+" "+
+			" "+"%t\t\t\t\n%s\tSlpTrls:"+" "+"%d\tSlpStage:"+" "+
 			"%s\t\t\t\n",
 			ss.TrainEnv.Run.Cur, ss.TrainEnv.Epoch.Cur, ss.Time.Cycle, ss.InhibFactor, ss.AvgLaySim, ss.PlusPhase,
-			ss.MinusPhase, ss.ClosestABA, ss.ClosestABAMatch, ss.ClosestABB, ss.ClosestABBMatch, ss.ClosestACA,
-			ss.ClosestACAMatch, ss.ClosestACC, ss.ClosestACCMatch, ss.SlpTrls, ss.SleepStage)
+			ss.MinusPhase, ss.EnvMatchCounters(), ss.SlpTrls, ss.SleepStage)
 
 	} else if state == "strucsleep" {
 		return fmt.Sprintf("Run:"+" "+"%d\tEpoch:"+" "+"%d\tTrial:"+" "+"%d\tCycle:"+" "+"%d\tName:"+" "+
@@ -531,6 +586,14 @@ func (ss *Sim) SleepCycInit() {
 	}
 }
 
+// SleepParams applies the sleep learning mask of stage (SlpMask) to the
+// network, and prints what it changed for sleep block block.  The parameter
+// scope of the block undoes it.
+func (ss *Sim) SleepParams(stage, block string) {
+	sets := ss.SlpMask.Apply(ss.Net, stage)
+	fmt.Printf("Sleep learning mask of %v: %v\n", block, strings.Join(sets, " "))
+}
+
 // BackToWake terminates spontaneous sleep and sets the network up for wake training/testing again
 func (ss *Sim) BackToWake() {
 	// Effwt back to =Wt
@@ -641,6 +704,7 @@ func (ss *Sim) AlphaCyc(train bool) {
 
 	if train {
 		ss.Net.DWt()
+		ss.WtChg.AccumDWt(ss.Net)
 	}
 	if ss.ViewOn && viewUpdt == leabra.AlphaCycle {
 
@@ -656,172 +720,6 @@ func (ss *Sim) AlphaCyc(train bool) {
 
 	}
 
-	if ss.TrainEnv.Run.Cur == 0 {
-		ss.DirSeed = ss.RndSeed
-	}
-
-}
-
-func (ss *Sim) StrucSleepAlphaCyc(train bool) {
-	// ss.Win.PollEvents() // this can be used instead of running in a separate goroutine
-	viewUpdt := ss.StrucSleepUpdt
-	if !train {
-		viewUpdt = ss.TestUpdt
-	}
-
-	// update prior weight changes at start, so any DWt values remain visible at end
-	// you might want to do this less frequently to achieve a mini-batch update
-	// in which case, move it out to the TrainTrial method where the relevant
-	// counters are being dealt with.
-	if train {
-		ss.Net.WtFmDWt()
-	}
-	// Setting Output layer to type "output"
-	out := ss.Net.LayerByName("Output").(leabra.LeabraLayer).AsLeabra()
-	out.SetType(emer.Compare)
-
-	// Storing current Gi values
-	inpinhib := ss.Net.LayerByName("Input").(*leabra.Layer).Inhib.Layer.Gi
-	dginhib := ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi
-	ca3inhib := ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi
-	ctxinhib := ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi
-	outinhib := ss.Net.LayerByName("Output").(*leabra.Layer).Inhib.Layer.Gi
-
-	pluscycs := 25
-
-	inhiboscill := make([]float64, 0)
-
-	// Padding for plus cycs + ss.OscillStartCyc
-	for i := 0; i < pluscycs+ss.OscillStartCyc; i++ {
-		inhiboscill = append(inhiboscill, 1.)
-	}
-
-	// Producing Oscill Gi factors
-	for i := ss.OscillStartCyc; i <= ss.OscillStopCyc; i++ {
-		inhiboscill = append(inhiboscill, ss.OscillAmplitude*math.Sin(2*3.14/ss.OscillPeriod*float64(i))+ss.OscillMidline)
-	}
-
-	// Padding after minus stop cycle
-	for i := ss.OscillStopCyc; i < 75; i++ {
-		inhiboscill = append(inhiboscill, 1.)
-	}
-
-	ss.Net.AlphaCycInit(train)
-	ss.Time.AlphaCycStart()
-	for qtr := 0; qtr < 4; qtr++ {
-		for cyc := 0; cyc < ss.Time.CycPerQtr; cyc++ {
-
-			ss.InhibFactor = inhiboscill[ss.Time.Cycle]
-			// Changing Inhibs back to default before next oscill cycle value so that the inhib values are set based on inhib values
-			ss.Net.LayerByName("Input").(*leabra.Layer).Inhib.Layer.Gi = inpinhib
-			ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi = dginhib
-			ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi = ca3inhib
-			ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi = ctxinhib
-			ss.Net.LayerByName("Output").(*leabra.Layer).Inhib.Layer.Gi = outinhib
-
-			oscilllayers := []string{"DG", "CA3", "CTX", "Output"} // layers to apply oscillating inhibition to
-			for _, layer := range oscilllayers {
-				ly := ss.Net.LayerByName(layer).(*leabra.Layer)
-				ly.Inhib.Layer.Gi = ly.Inhib.Layer.Gi * float32(inhiboscill[ss.Time.Cycle])
-			}
-
-			ss.Net.Cycle(&ss.Time, false) // Placed after Gi change
-
-			// We only need the settled plus phase cycle but need all minus phase cycles
-			if qtr == 0 && cyc == 24 {
-				for _, ly := range ss.Net.Layers {
-					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(true)
-				}
-			} else if qtr == 1 && cyc == 0 {
-				for _, ly := range ss.Net.Layers {
-					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(true)
-				}
-			} else if qtr > 0 && cyc > 0 {
-				for _, ly := range ss.Net.Layers {
-					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(false)
-				}
-			}
-			ss.Time.CycleInc()
-			if ss.ViewOn {
-				switch viewUpdt {
-				case leabra.Cycle:
-					if cyc != ss.Time.CycPerQtr-1 { // will be updated by quarter
-						ss.UpdateView("strucsleep")
-					}
-				case leabra.FastSpike:
-					if (cyc+1)%10 == 0 {
-						ss.UpdateView("strucsleep")
-					}
-				}
-			}
-		}
-		switch qtr {
-		case 0:
-			for _, ly := range ss.Net.Layers {
-				ly.(leabra.LeabraLayer).AsLeabra().CalcActP(1)
-			}
-		case 3:
-			for _, ly := range ss.Net.Layers {
-				ly.(leabra.LeabraLayer).AsLeabra().CalcActM(75)
-			}
-		}
-
-		if ss.ViewOn {
-			switch {
-			case viewUpdt <= leabra.Quarter:
-				ss.UpdateView("strucsleep")
-			case viewUpdt == leabra.Phase:
-				if qtr == 0 || qtr == 3 {
-					ss.UpdateView("strucsleep")
-				}
-			}
-		}
-	}
-	for _, lyc := range ss.Net.Layers {
-		ly := ss.Net.LayerByName(lyc.Name()).(*leabra.Layer)
-		for _, p := range ly.SndPrjns {
-			if p.IsOff() {
-				continue
-			}
-			p.(*hip.CHLPrjn).SlpDWt("err")
-		}
-	}
-	out.SetType(emer.Target)
-	if ss.ViewOn && viewUpdt == leabra.AlphaCycle {
-		ss.UpdateView("strucsleep")
-	}
-	// Resetting Gis after sleep
-	ss.Net.LayerByName("Input").(*leabra.Layer).Inhib.Layer.Gi = inpinhib
-	ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi = dginhib
-	ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi = ca3inhib
-	ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi = ctxinhib
-	ss.Net.LayerByName("Output").(*leabra.Layer).Inhib.Layer.Gi = outinhib
-}
-
-func (ss *Sim) StrucSleepTrial() {
-	if ss.NeedsNewRun {
-		ss.NewRun()
-	}
-
-	ss.SleepEnv.Step() // the Env encapsulates and manages all counter state
-
-	ss.ApplyInputs(&ss.SleepEnv)
-	ss.StrucSleepAlphaCyc(true) // train
-}
-
-func (ss *Sim) StrucSleepEpoch() {
-	ss.SleepEnv.Run.Cur = ss.TrainEnv.Run.Cur
-	ss.StopNow = false
-	//curEpc := ss.TrainEnv.Epoch.Cur
-	//curTrial := ss.TrainEnv.Trial.Cur
-	for {
-		ss.StrucSleepTrial()
-		_, _, chg := ss.SleepEnv.Counter(env.Epoch)
-		if chg || ss.StopNow {
-			break
-		}
-	}
-	ss.Stopped()
 }
 
 // ApplyInputs applies input patterns from given environment.
@@ -844,19 +742,15 @@ func (ss *Sim) ApplyInputs(en env.Env) {
 
 // TrainTrial runs one trial of training using TrainEnv
 func (ss *Sim) TrainTrial() {
+	if ss.Manifest == nil {
+		ss.StartManifest()
+	}
+
 	if ss.NeedsNewRun {
 		ss.NewRun()
 	}
 
-	if ss.ABZero == false && ss.ACZero == false {
-		ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-		ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-		ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-		ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-		ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-		ss.Net.GScaleFmAvgAct() // update computed scaling factors
-		ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-	}
+	ss.ConfigEnvHip()
 
 	ss.TrainEnv.Step() // the Env encapsulates and manages all counter state
 
@@ -870,145 +764,16 @@ func (ss *Sim) TrainTrial() {
 		}
 		if ss.TestInterval > 0 && epc%ss.TestInterval == 0 { // note: epc is *next* so won't trigger first time
 			ss.TestAll()
-			learnedAB := false
 
-			if ss.TestABSSE == 0 && ss.TrainABSSE == 0 {
-				ss.ABover++
-			} else {
-				ss.ABover = 0
-			}
+			// All environments learned: sleep
+			if ss.CheckLearned() {
+				ss.SlpTestAll()
+				ss.Milestone("PreSleep", "PreSleep")
+				ss.RunSlpConds()
 
-			if ss.ABover == 30 {
-				learnedAB = true
-			}
-
-			//learnedAB := (ss.TestABSSE == 0 && ss.TrainABSSE == 0)
-			if ss.TrainEnv.Table.Table == ss.TrainAB && learnedAB {
-				ss.ABZero = true
-				ss.TrainEnv.Table = etable.NewIdxView(ss.TrainAC)
-
-				// All Layers on. CTX learning rate lower.
-				ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-				ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-				ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-				ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-				ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-				ss.Net.GScaleFmAvgAct() // update computed scaling factors
-				ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-
-				ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().RcvPrjns.SendName("Input").(*hip.CHLPrjn).Learn.Lrate = 0.0001
-				ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SndPrjns.RecvName("Output").(*hip.CHLPrjn).Learn.Lrate = 0.0001
-
-			}
-
-			//learnedAC := true
-			learnedAC := (ss.TestACSSE == 0 && ss.TrainACSSE == 0)
-			if ss.TrainEnv.Table.Table == ss.TrainAC && learnedAC {
-				ss.ACZero = true
-
-				if ss.ABZero == true && ss.ACZero == true {
-					ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-					ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-					ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-					ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-					ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-					ss.Net.GScaleFmAvgAct() // update computed scaling factors
-					ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-				}
-				ss.TestAll()
-
-				if ss.ABZero == true && ss.ACZero == true {
-					ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-					ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-					ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-					ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-					ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-					ss.Net.GScaleFmAvgAct() // update computed scaling factors
-					ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-				}
-
-				for i := 0; i < 5; i++ {
-
-					ss.InhibOscil = false
-					ss.SleepStage = "SWS"
-					if ss.SleepStage == "SWS" {
-						ss.Net.LayerByName("DG").(*leabra.Layer).SetOff(false)
-						ss.Net.LayerByName("CA3").(*leabra.Layer).SetOff(false)
-						ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.GScaleFmAvgAct() // update computed scaling factors
-						ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-						ss.SleepStage = "SWS"
-						ss.SleepCounter += 1
-						ss.SWSCounter += 1
-					}
-					cycles := 10000
-					ss.SleepTrial("SWS", cycles)
-
-					if ss.ABZero == true && ss.ACZero == true {
-						ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.GScaleFmAvgAct() // update computed scaling factors
-						ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-					}
-					ss.TestAll()
-					if ss.ABZero == true && ss.ACZero == true {
-						ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.GScaleFmAvgAct() // update computed scaling factors
-						ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-					}
-
-					ss.InhibOscil = false
-					ss.SleepStage = "REM"
-					if ss.SleepStage == "REM" {
-						ss.Net.LayerByName("DG").(*leabra.Layer).SetOff(true)
-						ss.Net.LayerByName("CA3").(*leabra.Layer).SetOff(true)
-						ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.GScaleFmAvgAct() // update computed scaling factors
-						ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-						ss.SleepStage = "REM"
-						ss.SleepCounter += 1
-						ss.REMCounter += 1
-					}
-
-					ss.SleepTrial("REM", cycles)
-					if ss.ABZero == true && ss.ACZero == true {
-						ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
-						ss.Net.GScaleFmAvgAct() // update computed scaling factors
-						ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-					}
-					ss.TestAll()
-					if ss.ABZero == true && ss.ACZero == true {
-						ss.Net.LayerByName("CTX").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("DG").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("CA3").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
-						ss.Net.GScaleFmAvgAct() // update computed scaling factors
-						ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
-					}
-
-				}
-
-				ss.ABZero = false
-				ss.ACZero = false
 				ss.SleepCounter = 0
 				ss.SleepStage = "PreSleep"
 				ss.SlpTrls = 0
-				learnedAB = false
-				learnedAB = false
 
 				ss.RunEnd()
 
@@ -1035,9 +800,15 @@ func (ss *Sim) TrainTrial() {
 		}
 	}
 
+	ss.WakeTrial()
+}
+
+// WakeTrial runs the current wake training trial
+func (ss *Sim) WakeTrial() {
 	ss.ApplyInputs(&ss.TrainEnv)
 	ss.AlphaCyc(true)   // train
 	ss.TrialStats(true) // accumulate
+	ss.LogTrnTrl(ss.TrnTrlLog)
 }
 
 // SleepCyc runs one 30,000 cycle trial of spontaneous sleep
@@ -1049,42 +820,36 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 	pluscount := 0
 	minuscount := 0
 	ss.SlpTrls = 0
+	block := ss.CondLabel(fmt.Sprintf("%v-%d", stage, ss.SleepCounter)) // SlpTrlLog label
+	stab := ss.Stability[stage]
+	stab.Reset()
+	stablys := ss.StabilityLays(stage)
+	ss.SlpThr.Reset(ss.FixedSlpThresh(stage))
+	ss.Kick.Reset()
+	ss.Watchdog.Reset()
+	tmrClamps := ss.TMRStart()
+	defer ss.TMREnd(tmrClamps)
 
 	inp := ss.Net.LayerByName("Input").(*leabra.Layer)
 	ctx := ss.Net.LayerByName("CTX").(*leabra.Layer)
 	out := ss.Net.LayerByName("Output").(*leabra.Layer)
-	ca3 := ss.Net.LayerByName("CA3").(*leabra.Layer)
 
-	// Recording all inhibition Gi parameters prior to sleep for the inhibitory oscillations
-	inpinhib := ss.Net.LayerByName("Input").(*leabra.Layer).Inhib.Layer.Gi
-	dginhib := ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi
-	ca3inhib := ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi
-	ctxinhib := ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi
-	outinhib := ss.Net.LayerByName("Output").(*leabra.Layer).Inhib.Layer.Gi
-	pca1inhib := ss.Net.LayerByName("pCA1").(*leabra.Layer).Inhib.Layer.Gi
-	dca1inhib := ss.Net.LayerByName("dCA1").(*leabra.Layer).Inhib.Layer.Gi
+	// Snapshot of the wake params (Gi for the inhibitory oscillations, learning), restored however sleep ends
+	scope := ss.StartParamScope(block)
+	defer ss.EndParamScope(scope)
+	ss.StartSlpCycLog(block)
+	defer ss.EndSlpCycLog()
 
 	ss.Net.GScaleFmAvgAct() // update computed scaling factors
 	ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
+	ss.Downscale.Start(ss.Net)
+	ss.SleepParams(stage, block)
 
 	writeout := [][]string{}
 
 	// Loop for the 30,000 cycle sleep trial
 	for cyc := 0; cyc < cycles; cyc++ { // 10000
 
-		inp.SndPrjns.RecvName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.05
-		out.RcvPrjns.SendName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.05
-
-		inp.SndPrjns.RecvName("DG").(*hip.CHLPrjn).Learn.Learn = false
-		inp.SndPrjns.RecvName("DG").(*hip.CHLPrjn).Learn.Learn = false
-		ca3.SndPrjns.RecvName("CA3").(*hip.CHLPrjn).Learn.Learn = false
-		ca3.SndPrjns.RecvName("pCA1").(*hip.CHLPrjn).Learn.Learn = false
-		inp.SndPrjns.RecvName("dCA1").(*hip.CHLPrjn).Learn.Learn = false
-		out.RcvPrjns.SendName("dCA1").(*hip.CHLPrjn).Learn.Learn = false
-		out.RcvPrjns.SendName("pCA1").(*hip.CHLPrjn).Learn.Learn = false
-		out.SndPrjns.RecvName("pCA1").(*hip.CHLPrjn).Learn.Learn = false
-		out.SndPrjns.RecvName("dCA1").(*hip.CHLPrjn).Learn.Learn = false
-
 		ss.Net.WtFmDWt()
 
 		ss.Net.Cycle(&ss.Time, true)
@@ -1096,13 +861,7 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 			ss.InhibFactor = inhibs[0][cyc] // For sleep GUI counter and sleepcyclog
 
 			// Changing Inhibs back to default before next oscill cycle value so that the inhib values are set based on c values
-			ss.Net.LayerByName("Input").(*leabra.Layer).Inhib.Layer.Gi = inpinhib
-			ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi = dginhib
-			ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi = ca3inhib
-			ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi = ctxinhib
-			ss.Net.LayerByName("Output").(*leabra.Layer).Inhib.Layer.Gi = outinhib
-			ss.Net.LayerByName("pCA1").(*leabra.Layer).Inhib.Layer.Gi = pca1inhib
-			ss.Net.LayerByName("dCA1").(*leabra.Layer).Inhib.Layer.Gi = dca1inhib
+			scope.RestoreGi()
 
 			// Two groups - low layers recieve lower-amplitude inhibitiory oscillations while high layers recive high-amplitude oscillations.
 			// This is done to optimize oscillations for best minus-phases
@@ -1119,72 +878,24 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 			}
 		}
 
+		ss.TMRCyc(stage, cyc, tmrClamps)
+
 		// Average network similarity is the "stability" measure. It tracks the cycle-updated temporal auto-correlation of activation values at each layer.
-		avesim := 0.0
-		tmpsim := 0.0
-
-		lys := []string{}
-		if stage == "REM" {
-			lys = []string{"Input", "Output", "CTX"}
-		} else if stage == "SWS" {
-			lys = []string{"Input", "Output", "CTX", "DG", "CA3", "pCA1", "dCA1"}
-		}
+		ss.AvgLaySim = stab.Stability(ss.Net, stablys)
+		ss.SlpThr.Update(ss.AvgLaySim)
 
-		for _, lyc := range lys {
-			ly := ss.Net.LayerByName(lyc).(*leabra.Layer)
-			tmpsim = ly.Sim
-			if math.IsNaN(tmpsim) {
-				tmpsim = 0
-			}
-			actsum := float32(0)
-			for ni := range ly.Neurons {
-				nrn := &ly.Neurons[ni]
-				act := nrn.Act
-				actsum += act
-			}
-			if actsum < 1 {
-				tmpsim = 0
-			}
-			avesim = avesim + tmpsim
-		}
-		ss.AvgLaySim = avesim / float64(len(lys))
-
-		//If AvgLaySim falls below 0.9 - most likely because a layer has lost all act, random noise will be injected
-		//into the network to get it going again. The first 1000 cycles are skipped to let the network initially settle into an attractor.
-		if ss.Time.Cycle > 30000 && ss.AvgLaySim <= 0.8 && (ss.Time.Cycle%50 == 0 || ss.Time.Cycle%50 == 1 || ss.Time.Cycle%50 == 2 || ss.Time.Cycle%50 == 3 || ss.Time.Cycle%50 == 4) {
-			for _, ly := range ss.Net.Layers {
-				for ni := range ly.(*leabra.Layer).Neurons {
-					nrn := &ly.(*leabra.Layer).Neurons[ni]
-					if nrn.IsOff() {
-						continue
-					}
-					nrn.Act = 0
-					rnd := rand.Float32()
-					rnd = rnd - 0.5
-					if rnd < 0 {
-						rnd = 0
-					}
-					nrn.Act = rnd
-				}
-			}
-		}
+		// If the network gets stuck -- e.g., AvgLaySim falls because a layer has lost all act -- it is kicked to get it going again
+		ss.WatchCyc(block, cyc)
+		ss.SleepKick(block, cyc, stablys)
+		ss.DownscaleCyc(cyc)
 
 		// Logging the SlpCycLog
 		ss.LogSlpCyc(ss.SlpCycLog, ss.Time.Cycle)
 
 		// Mark plus or minus phase
 		if ss.SlpLearn {
-
-			plusthresh := 0.9999
-			minusthresh := plusthresh - 0.01
-
-			if stage == "SWS" {
-				plusthresh = 0.99995
-				minusthresh = plusthresh - 0.0025
-			} else if stage == "REM" {
-				plusthresh = 0.999995
-				minusthresh = plusthresh - 0.0025
-			}
+			plusthresh := ss.SlpThr.Plus
+			minusthresh := ss.SlpThr.Minus
 
 			// Checking if stable above threshold
 			if ss.PlusPhase == false && ss.MinusPhase == false {
@@ -1202,12 +913,15 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 				minuscount = 0
 				ss.PlusPhase = true
 				pluscount++
+				ss.SlpTrl.Start(cyc, ss.OnsetInhib(), plusthresh, minusthresh)
+				ss.SlpTrl.AddPlus(ss.AvgLaySim)
 				for _, ly := range ss.Net.Layers {
 					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(true)
 				}
 
 			} else if pluscount > 0 && ss.AvgLaySim >= plusthresh && ss.PlusPhase == true {
 				pluscount++
+				ss.SlpTrl.AddPlus(ss.AvgLaySim)
 				for _, ly := range ss.Net.Layers {
 					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(false)
 				}
@@ -1216,6 +930,7 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 				ss.PlusPhase = false
 				ss.MinusPhase = true
 				minuscount++
+				ss.SlpTrl.AddMinus(ss.AvgLaySim)
 
 				for _, ly := range ss.Net.Layers {
 					ly.(leabra.LeabraLayer).AsLeabra().CalcActP(pluscount)
@@ -1225,6 +940,7 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 
 			} else if ss.AvgLaySim >= minusthresh && ss.MinusPhase == true {
 				minuscount++
+				ss.SlpTrl.AddMinus(ss.AvgLaySim)
 				for _, ly := range ss.Net.Layers {
 					ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(false)
 				}
@@ -1238,19 +954,15 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 				minuscount = 0
 				stablecount = 0
 
-				for _, lyc := range ss.Net.Layers {
-
-					ly := ss.Net.LayerByName(lyc.Name()).(*leabra.Layer)
-					for _, p := range ly.SndPrjns {
-						if p.IsOff() {
-							continue
-						}
-						p.(*hip.CHLPrjn).SlpDWt("err") // Weight changes occuring here
-					}
+				if ss.SlpDWt {
+					ss.ApplySlpRules() // Weight changes occuring here
+					ss.SlpTrl.AbsDWt = ss.WtChg.AccumDWt(ss.Net)
 				}
 				ss.SlpTrls++
+				ss.LogSlpTrl(ss.SlpTrlLog, block, false)
 				// Catching the rare occasion where stabilty drops in one cycle from above the plus threshold to below the minus threshold - ending trial if this happens
 			} else if ss.AvgLaySim < minusthresh && ss.PlusPhase == true {
+				ss.LogSlpTrl(ss.SlpTrlLog, block, true) // aborted
 				ss.PlusPhase = false
 				pluscount = 0
 				stablecount = 0
@@ -1281,33 +993,28 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 			}
 		}
 
-		inp.SndPrjns.RecvName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.05
-		out.RcvPrjns.SendName("CTX").(*hip.CHLPrjn).Learn.Lrate = 0.05
-
-		inp.SndPrjns.RecvName("DG").(*hip.CHLPrjn).Learn.Learn = true
-		inp.SndPrjns.RecvName("CA3").(*hip.CHLPrjn).Learn.Learn = true
-		ca3.SndPrjns.RecvName("CA3").(*hip.CHLPrjn).Learn.Learn = true
-		ca3.SndPrjns.RecvName("pCA1").(*hip.CHLPrjn).Learn.Learn = true
-		inp.SndPrjns.RecvName("dCA1").(*hip.CHLPrjn).Learn.Learn = true
-		out.RcvPrjns.SendName("dCA1").(*hip.CHLPrjn).Learn.Learn = true
-		out.RcvPrjns.SendName("pCA1").(*hip.CHLPrjn).Learn.Learn = true
-		out.SndPrjns.RecvName("pCA1").(*hip.CHLPrjn).Learn.Learn = true
-		out.SndPrjns.RecvName("dCA1").(*hip.CHLPrjn).Learn.Learn = true
-
 		var inpCycAct []float32
 		inp.UnitVals(&inpCycAct, "Act")
 		var outCycAct []float32
 		out.UnitVals(&outCycAct, "Act")
 
 		// NOTE: SatMatch will only return ONE of the Pats with the lowest errors. Multiple pats may have the same error but, it only returns first one
-		minABA, minABB, ABAMatch, ABBMatch, minACA, minACC, ACAMatch, ACCMatch := ss.SatMatch(inpCycAct, outCycAct)
+		ss.SatMatch(inpCycAct, outCycAct)
+		item := ss.DecodeItem()
+		if ss.PlusPhase { // the item replayed by this trial is the one decoded at the end of its plus phase
+			ss.SlpTrl.Item = item
+		}
+		ss.Kick.SeeItem(item)
 
 		writecyc := []string{}
 
 		writecyc = append(writecyc, fmt.Sprint(ss.TrainEnv.Run.Cur), fmt.Sprint(ss.TrainEnv.Epoch.Cur),
-			fmt.Sprint(ss.SleepCounter), fmt.Sprint(ss.PlusPhase), fmt.Sprint(ss.MinusPhase), fmt.Sprint(minABA),
-			fmt.Sprint(ABAMatch), fmt.Sprint(minABB), fmt.Sprint(ABBMatch), fmt.Sprint(minACA), fmt.Sprint(ACAMatch),
-			fmt.Sprint(minACC), fmt.Sprint(ACCMatch), fmt.Sprint(ss.SlpTrls))
+			fmt.Sprint(ss.SleepCounter), fmt.Sprint(ss.PlusPhase), fmt.Sprint(ss.MinusPhase))
+		for _, en := range ss.Envs {
+			writecyc = append(writecyc, fmt.Sprint(en.NearIn), fmt.Sprint(en.InMatch), fmt.Sprint(en.NearOut),
+				fmt.Sprint(en.OutMatch))
+		}
+		writecyc = append(writecyc, fmt.Sprint(ss.SlpTrls))
 
 		writeout = append(writeout, writecyc)
 
@@ -1324,70 +1031,14 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 		outFmctx.SynVals(&outFmctxsdf, "SynDepFac")
 
 	}
+	ss.DownscaleBlock(block)
 
-	dirpathacts := ""
 	if ss.SlpPatMatchWrtOut {
-		dirpathacts := "output/" + "sleep" + "/" + "ReplayMatch" + "/" + fmt.Sprint(ss.DirSeed) + "/" + "repmatch" +
-			fmt.Sprint(ss.RndSeed) + "_truns_" + fmt.Sprint(ss.MaxRuns) + "_run_" + fmt.Sprint(ss.TrainEnv.Run.Cur) + "/"
-
-		if _, err := os.Stat(filepath.FromSlash(dirpathacts)); os.IsNotExist(err) {
-			os.MkdirAll(filepath.FromSlash(dirpathacts), os.ModePerm)
-		}
-	}
-
-	if ss.SleepCounter == 1 && ss.SlpPatMatchWrtOut {
-		// copying params.go to better track params associated with the run data
-		paramsdata, err := ioutil.ReadFile("params.go")
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		err = ioutil.WriteFile(filepath.FromSlash("output/"+"sleep"+"/"+"ReplayMatch"+"/"+fmt.Sprint(ss.DirSeed)+
-			"/"+"tstacts"+fmt.Sprint(ss.DirSeed)+"_"+"runs_"+fmt.Sprint(ss.MaxRuns)+"params.go"), paramsdata, 0644)
-		if err != nil {
-			fmt.Println("Error creating", dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"_"+"params.go")
-			fmt.Println(err)
-			return
-		}
-
-		mainfile, err := ioutil.ReadFile("simulation_2.go")
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		err = ioutil.WriteFile(filepath.FromSlash("output/"+"sleep"+"/"+"ReplayMatch"+"/"+fmt.Sprint(ss.DirSeed)+
-			"/"+"tstacts"+fmt.Sprint(ss.DirSeed)+"_"+"runs_"+fmt.Sprint(ss.MaxRuns)+"simulation_2.go"),
-			mainfile, 0644)
-		if err != nil {
-			fmt.Println("Error creating", dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"_"+"params.go")
-			fmt.Println(err)
-			return
+		if err := ss.WriteRepMatch(writeout); err != nil {
+			log.Println(err)
 		}
 	}
 
-	if ss.SlpPatMatchWrtOut {
-		filew, _ := os.OpenFile(filepath.FromSlash("output/"+"sleep"+"/"+"ReplayMatch"+"/"+fmt.Sprint(ss.DirSeed)+"/"+"repmatch"+
-			fmt.Sprint(ss.RndSeed)+"_truns_"+fmt.Sprint(ss.MaxRuns)+"_run_"+fmt.Sprint(ss.TrainEnv.Run.Cur)+"/"+"repmatch"+fmt.Sprint(ss.RndSeed)+"_"+
-			"run"+fmt.Sprint(ss.TrainEnv.Run.Cur)+"epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur)+
-			"_"+"stage-"+fmt.Sprint(ss.SleepStage)+"slpblk_"+fmt.Sprint(ss.SleepCounter)+".csv"),
-			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-
-		defer filew.Close()
-		writerw := csv.NewWriter(filew)
-		defer writerw.Flush()
-
-		headers := []string{"Run", "Epoch", "SlpCounter", "PlusPhase", "MinusPhase", "NearA", "AMatch",
-			"NearB", "BMatch", "NearA'", "A'Match", "NearC", "CMatch", "SlpTrl"}
-		writerw.Write(headers)
-		writerw.Flush()
-
-		writerw.WriteAll(writeout)
-		writerw.Flush()
-		filew.Close()
-	}
-
 	// Reset sleep algorithm variables
 	pluscount = 0
 	minuscount = 0
@@ -1398,148 +1049,69 @@ func (ss *Sim) SleepCyc(c [][]float64, stage string, cycles int) {
 	ss.Net.GScaleFmAvgAct() // update computed scaling factors
 	ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
 
-	ss.Net.LayerByName("Input").(*leabra.Layer).Inhib.Layer.Gi = inpinhib
-	ss.Net.LayerByName("DG").(*leabra.Layer).Inhib.Layer.Gi = dginhib
-	ss.Net.LayerByName("CA3").(*leabra.Layer).Inhib.Layer.Gi = ca3inhib
-	ss.Net.LayerByName("CTX").(*leabra.Layer).Inhib.Layer.Gi = ctxinhib
-	ss.Net.LayerByName("Output").(*leabra.Layer).Inhib.Layer.Gi = outinhib
-	ss.Net.LayerByName("pCA1").(*leabra.Layer).Inhib.Layer.Gi = pca1inhib
-	ss.Net.LayerByName("dCA1").(*leabra.Layer).Inhib.Layer.Gi = dca1inhib
-
 	if ss.ViewOn {
 		ss.UpdateView("sleep")
 	}
 }
 
-// NOTE: SatMatch will only return ONE of the Pats with the lowest errors. Multiple pats may have the same error but it only returns first one
-func (ss *Sim) SatMatch(inpact, outact []float32) (int, int, float32, float32, int, int, float32, float32) {
-
-	file, err := os.Open("env1_pats_nohead.tsv")
-	if err != nil {
-		fmt.Println("err in reading file")
-	}
-
-	reader := csv.NewReader(file)
-	reader.LazyQuotes = true
-	reader.Comma = '\t'
-
-	ABpatterns, err := reader.ReadAll()
+// WriteRepMatch writes the decoded replay rows of the current sleep block
+// to its file in the run's sleep output directory.
+func (ss *Sim) WriteRepMatch(rows [][]string) error {
+	filew, isNew, err := ss.OpenOutput(ss.Out.RunFile(ss.TrainEnv.Run.Cur, "sleep",
+		"repmatch_epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur)+"_stage-"+fmt.Sprint(ss.SleepStage)+
+			"_"+ss.CondLabel("slpblk_"+fmt.Sprint(ss.SleepCounter))+".csv"))
 	if err != nil {
-		fmt.Println("err in reading reader object")
-	}
-
-	file, err = os.Open("env2_pats_nohead.tsv")
-	if err != nil {
-		fmt.Println("err in reading file")
-	}
-
-	reader = csv.NewReader(file)
-	reader.LazyQuotes = true
-	reader.Comma = '\t'
-
-	ACpatterns, err := reader.ReadAll()
-	if err != nil {
-		fmt.Println("err in reading reader object")
+		return err
 	}
+	defer filew.Close()
 
-	inpbin := inpact
-	outbin := outact
-
-	ABAerrors := []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	minABA := 0
-
-	ABBerrors := []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	minABB := 0
-
-	for j := 0; j < 10; j++ {
-		diff := []float64{}
-		for i, val := range ABpatterns[j][:120] {
-			valint, _ := strconv.Atoi(val)
-			diff = append(diff, (math.Abs(float64(float32(valint) - inpbin[i]))))
-		}
-		for _, val := range diff {
-			ABAerrors[j] += val
-		}
-		if j > 0 {
-			if ABAerrors[j] < ABAerrors[minABA] {
-				minABA = j
-			}
+	writerw := csv.NewWriter(filew)
+	if isNew {
+		headers := []string{"Run", "Epoch", "SlpCounter", "PlusPhase", "MinusPhase"}
+		for _, en := range ss.Envs {
+			headers = append(headers, en.Name+" NearIn", en.Name+" InMatch", en.Name+" NearOut", en.Name+" OutMatch")
 		}
+		headers = append(headers, "SlpTrl")
+		writerw.Write(headers)
 	}
+	writerw.WriteAll(rows) // WriteAll flushes
+	return writerw.Error()
+}
 
-	for j := 0; j < 10; j++ {
-		diff := []float64{}
-		for i, val := range ABpatterns[j][120:] {
-			valint, _ := strconv.Atoi(val)
-			diff = append(diff, (math.Abs(float64(float32(valint) - outbin[i]))))
-		}
-		for _, val := range diff {
-			ABBerrors[j] += val
-		}
-		if j > 0 {
-			if ABBerrors[j] < ABBerrors[minABB] {
-				minABB = j
-			}
+// DecodeItem returns the name of the training item whose output best matches
+// the current output, given the closest output of each environment from
+// SatMatch -- empty if two environments match equally well.
+func (ss *Sim) DecodeItem() string {
+	var best *SeqEnv
+	tie := false
+	for _, en := range ss.Envs {
+		switch {
+		case best == nil || en.OutMatch < best.OutMatch:
+			best, tie = en, false
+		case en.OutMatch == best.OutMatch:
+			tie = true
 		}
 	}
-
-	ACAerrors := []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	minACA := 0
-
-	ACCerrors := []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	minACC := 0
-
-	for j := 0; j < 10; j++ {
-		diff := []float64{}
-		for i, val := range ACpatterns[j][:120] {
-			valint, _ := strconv.Atoi(val)
-			diff = append(diff, (math.Abs(float64(float32(valint) - inpbin[i]))))
-		}
-		for _, val := range diff {
-			ACAerrors[j] += val
-		}
-		if j > 0 {
-			if ACAerrors[j] < ACAerrors[minACA] {
-				minACA = j
-			}
-		}
+	if best == nil || tie || best.NearOut >= best.Pats.Rows {
+		return ""
 	}
+	return best.Pats.CellString("Name", best.NearOut)
+}
 
-	for j := 0; j < 10; j++ {
-		diff := []float64{}
-		for i, val := range ACpatterns[j][120:] {
-			valint, _ := strconv.Atoi(val)
-			diff = append(diff, (math.Abs(float64(float32(valint) - outbin[i]))))
-		}
-		for _, val := range diff {
-			ACCerrors[j] += val
-		}
-		if j > 0 {
-			if ACCerrors[j] < ACCerrors[minACC] {
-				minACC = j
-			}
-		}
+// SatMatch finds the training patterns of each environment whose input
+// (EXT) and output are closest to the given Input and Output activity, in
+// its NearIn / InMatch and NearOut / OutMatch.
+// NOTE: SatMatch will only return ONE of the Pats with the lowest errors. Multiple pats may have the same error but it only returns first one
+func (ss *Sim) SatMatch(inpact, outact []float32) {
+	for _, en := range ss.Envs {
+		en.NearIn, en.InMatch = NearestPat(en.Pats, "EXT", inpact)
+		en.NearOut, en.OutMatch = NearestPat(en.Pats, "Output", outact)
 	}
-
-	ss.ClosestABA = minABA
-	ss.ClosestABAMatch = float32(ABAerrors[minABA])
-
-	ss.ClosestABB = minABB
-	ss.ClosestABBMatch = float32(ABBerrors[minABB])
-
-	ss.ClosestACA = minACA
-	ss.ClosestACAMatch = float32(ACAerrors[minACA])
-
-	ss.ClosestACC = minACC
-	ss.ClosestACCMatch = float32(ACCerrors[minACC])
-
-	return minABA, minABB, float32(ABAerrors[minABA]), float32(ABBerrors[minABB]),
-		minACA, minACC, float32(ACAerrors[minACA]), float32(ACCerrors[minACC])
-
 }
 
 // SleepTrial sets up one spontaneous sleep trial
 func (ss *Sim) SleepTrial(stage string, cycles int) {
+	ss.StartWtChg(ss.CondLabel(fmt.Sprintf("%v-%d", stage, ss.SleepCounter)))
 	ss.SleepCycInit()
 	ss.UpdateView("sleep")
 
@@ -1558,15 +1130,86 @@ func (ss *Sim) SleepTrial(stage string, cycles int) {
 	ss.SleepCyc(c, stage, cycles)
 	ss.SlpCycPlot.GoUpdate()
 	ss.BackToWake()
+	ss.EndWtChg()
+}
+
+// SleepBlocks runs a sleep bout of pairs SWS / REM block pairs, testing after
+// each block.  Returns the number of sleep learning trials of the bout.
+func (ss *Sim) SleepBlocks(pairs int) int {
+	slpTrls := 0
+	for i := 0; i < pairs; i++ {
+		ss.InhibOscil = false
+		ss.SleepStage = "SWS"
+		if ss.SleepStage == "SWS" {
+			ss.Net.LayerByName("DG").(*leabra.Layer).SetOff(false)
+			ss.Net.LayerByName("CA3").(*leabra.Layer).SetOff(false)
+			ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
+			ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(false)
+			ss.Net.GScaleFmAvgAct() // update computed scaling factors
+			ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
+			ss.SleepStage = "SWS"
+			ss.SleepCounter += 1
+			ss.SWSCounter += 1
+		}
+		ss.SleepTrial("SWS", SleepBlkCycs)
+		slpTrls += ss.SlpTrls
+
+		ss.SlpTestAll()
+		ss.Milestone(ss.SleepStage, ss.CondLabel(fmt.Sprintf("%v-%d", ss.SleepStage, ss.SleepCounter)))
+
+		ss.InhibOscil = false
+		ss.SleepStage = "REM"
+		if ss.SleepStage == "REM" {
+			ss.Net.LayerByName("DG").(*leabra.Layer).SetOff(true)
+			ss.Net.LayerByName("CA3").(*leabra.Layer).SetOff(true)
+			ss.Net.LayerByName("pCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
+			ss.Net.LayerByName("dCA1").(leabra.LeabraLayer).AsLeabra().SetOff(true)
+			ss.Net.GScaleFmAvgAct() // update computed scaling factors
+			ss.Net.InitGInc()       // scaling params change, so need to recompute all netins
+			ss.SleepStage = "REM"
+			ss.SleepCounter += 1
+			ss.REMCounter += 1
+		}
+
+		ss.SleepTrial("REM", SleepBlkCycs)
+		slpTrls += ss.SlpTrls
+		ss.SlpTestAll()
+		ss.Milestone(ss.SleepStage, ss.CondLabel(fmt.Sprintf("%v-%d", ss.SleepStage, ss.SleepCounter)))
+	}
+	ss.TotSlpTrls += slpTrls
+	return slpTrls
+}
+
+// SlpTestAll runs TestAll as the tests around sleep do: with the
+// hippocampus off once all the environments have been learned
+func (ss *Sim) SlpTestAll() {
+	if ss.AllEnvsLearned() {
+		ss.SetHip(false)
+	}
+	ss.TestAll()
+	if ss.AllEnvsLearned() {
+		ss.SetHip(true)
+	}
 }
 
 // RunEnd is called at the end of a run -- save weights, record final log, etc here
 func (ss *Sim) RunEnd() {
 
 	if ss.SaveWts {
-		fnm := ss.WeightsFileName()
+		fnm := ss.Out.RunFile(ss.TrainEnv.Run.Cur, "weights", ss.WeightsFileName())
 		fmt.Printf("Saving Weights to: %v\n", fnm)
-		ss.Net.SaveWtsJSON(gi.FileName(fnm))
+		if err := os.MkdirAll(filepath.Dir(fnm), os.ModePerm); err != nil {
+			log.Println(err)
+		} else if err := ss.Net.SaveWtsJSON(gi.FileName(fnm)); err != nil {
+			log.Println(err)
+		} else {
+			ss.Manifest.AddOutput(fnm)
+		}
+	}
+	ss.EndWtChg()
+	ss.LogRun(ss.RunLog)
+	if ss.Manifest != nil {
+		ss.Manifest.EndRun(ss)
 	}
 }
 
@@ -1574,10 +1217,9 @@ func (ss *Sim) RunEnd() {
 // for the new run value
 func (ss *Sim) NewRun() {
 
-	ss.ABZero = false
+	ss.ResetEnvs()
 	ss.NewRndSeed()
 	run := ss.TrainEnv.Run.Cur
-	ss.TrainEnv.Table = etable.NewIdxView(ss.TrainAB)
 	ss.TrainEnv.Init(run)
 	ss.TestEnv.Init(run)
 	ss.Time.Reset()
@@ -1605,10 +1247,20 @@ func (ss *Sim) NewRun() {
 	ss.TrnTrlLog.SetNumRows(0)
 	ss.TrnEpcLog.SetNumRows(0)
 	ss.TstEpcLog.SetNumRows(0)
+	ss.SlpTrlLog.SetNumRows(0)
+	ss.KickLog.SetNumRows(0)
+	ss.DownscaleLog.SetNumRows(0)
+	ss.ParamLog.SetNumRows(0)
+	ss.StrucSlpLog.SetNumRows(0)
+	ss.SessLog.SetNumRows(0)
 	ss.NeedsNewRun = false
 
 	ss.Net.InitWts()
+	ss.StartWtChg("Wake")
 
+	if ss.Manifest != nil {
+		ss.Manifest.BeginRun(ss)
+	}
 }
 
 // InitStats initializes all the statistics, especially important for the
@@ -1629,6 +1281,9 @@ func (ss *Sim) InitStats() {
 	ss.EpcAvgSSE = 0
 	ss.EpcPctErr = 0
 	ss.EpcCosDiff = 0
+	ss.SlpCondsRun = nil
+	ss.Sess = 0
+	ss.TotSlpTrls = 0
 }
 
 // TrialStats computes the trial-level statistics and adds them to the epoch accumulators if
@@ -1761,66 +1416,29 @@ func (ss *Sim) TestItem(idx int) {
 // TestAll runs through the full set of testing items
 func (ss *Sim) TestAll() {
 
-	ss.TestNm = "AB"
-	ss.TestEnv.Table = etable.NewIdxView(ss.TrainAB)
-	ss.TestEnv.Init(ss.TrainEnv.Run.Cur)
-	for {
-		ss.TestTrial(true) // return on chg
-		_, _, chg := ss.TestEnv.Counter(env.Epoch)
-		if chg || ss.StopNow {
-			break
-		}
-	}
-
-	ss.TestNm = "AC"
-	ss.TestEnv.Table = etable.NewIdxView(ss.TrainAC)
-	ss.TestEnv.Init(ss.TrainEnv.Run.Cur)
-	for {
-		ss.TestTrial(true)
-		_, _, chg := ss.TestEnv.Counter(env.Epoch)
-		if chg || ss.StopNow {
-			break
+	for _, en := range ss.Envs {
+		ss.TestNm = en.Name
+		ss.TestEnv.Table = etable.NewIdxView(en.Pats)
+		ss.TestEnv.Init(ss.TrainEnv.Run.Cur)
+		for {
+			ss.TestTrial(true) // return on chg
+			_, _, chg := ss.TestEnv.Counter(env.Epoch)
+			if chg || ss.StopNow {
+				break
+			}
 		}
 	}
 
 	if ss.TstWrtOut {
-		dirpathacts := filepath.FromSlash("output/" + "wake" + "/" + fmt.Sprint(ss.DirSeed) + "/" + "tstsse" + fmt.Sprint(ss.RndSeed) + "_truns_" + fmt.Sprint(ss.MaxRuns) + "_run_" + fmt.Sprint(ss.TrainEnv.Run.Cur) + "/")
-
-		if _, err := os.Stat(filepath.FromSlash(dirpathacts)); os.IsNotExist(err) {
-			os.MkdirAll(filepath.FromSlash(dirpathacts), os.ModePerm)
-		}
-
-		// copying params.go to better track params associated with the run data
-		paramsdata, err := ioutil.ReadFile("params.go")
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		err = ioutil.WriteFile(filepath.FromSlash("output/"+"wake"+"/"+fmt.Sprint(ss.DirSeed)+"/"+"tstacts"+fmt.Sprint(ss.DirSeed)+"_"+"runs_"+fmt.Sprint(ss.MaxRuns)+"params.go"), paramsdata, 0644)
-		if err != nil {
-			fmt.Println("Error creating", dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"_"+"params.go")
-			fmt.Println(err)
-			return
-		}
-
-		mainfile, err := ioutil.ReadFile("simulation_2.go")
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		err = ioutil.WriteFile(filepath.FromSlash("output/"+"wake"+"/"+fmt.Sprint(ss.DirSeed)+"/"+"tstacts"+fmt.Sprint(ss.DirSeed)+"_"+"runs_"+fmt.Sprint(ss.MaxRuns)+"simulation_2.go"), mainfile, 0644)
-		if err != nil {
-			fmt.Println("Error creating", dirpathacts+"/"+fmt.Sprint(ss.DirSeed)+"_"+"params.go")
-			fmt.Println(err)
-			return
+		fnmtst := ss.Out.RunFile(ss.TrainEnv.Run.Cur, "wake", "tstsse_epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur)+
+			"_poststage-"+fmt.Sprint(ss.SleepStage)+"_"+ss.CondLabel("slpblk_"+fmt.Sprint(ss.SleepCounter))+".csv")
+		if err := os.MkdirAll(filepath.Dir(fnmtst), os.ModePerm); err != nil {
+			log.Println(err)
+		} else if err := ss.TstTrlLog.SaveCSV(gi.FileName(fnmtst), etable.Comma, true); err != nil {
+			log.Println(err)
+		} else {
+			ss.Manifest.AddOutput(fnmtst)
 		}
-
-		ss.TstTrlLog.SaveCSV(gi.FileName(filepath.FromSlash("output/"+"wake"+"/"+fmt.Sprint(ss.DirSeed)+"/"+"tstsse"+fmt.Sprint(ss.RndSeed)+
-			"_truns_"+fmt.Sprint(ss.MaxRuns)+"_run_"+fmt.Sprint(ss.TrainEnv.Run.Cur)+"/"+
-			fmt.Sprint(ss.RndSeed)+"_"+"run"+fmt.Sprint(ss.TrainEnv.Run.Cur)+"epoch"+fmt.Sprint(ss.TrainEnv.Epoch.Cur)+
-			"_"+"poststage-"+fmt.Sprint(ss.SleepStage)+"slpblk_"+fmt.Sprint(ss.SleepCounter)+".csv")), etable.Comma, true)
 	}
 
 	// log only at very end
@@ -1888,21 +1506,6 @@ func (ss *Sim) SetParamsSet(setNm string, sheet string, setMsg bool) error {
 	return err
 }
 
-func (ss *Sim) ConfigPats() {
-	dt := ss.Pats
-	dt.SetMetaData("name", "TrainPats")
-	dt.SetMetaData("desc", "Training patterns")
-	dt.SetFromSchema(etable.Schema{
-		{"Name", etensor.STRING, nil, nil},
-		{"Input", etensor.FLOAT32, []int{5, 5}, []string{"Y", "X"}},
-		{"Output", etensor.FLOAT32, []int{5, 5}, []string{"Y", "X"}},
-	}, 25)
-
-	patgen.PermutedBinaryRows(dt.Cols[1], 6, 1, 0)
-	patgen.PermutedBinaryRows(dt.Cols[2], 6, 1, 0)
-	dt.SaveCSV("random_5x5_25_gen.csv", etable.Comma, etable.Headers)
-}
-
 func (ss *Sim) OpenPat(dt *etable.Table, fname, name, desc string) {
 	err := dt.OpenCSV(gi.FileName(fname), etable.Tab)
 	if err != nil {
@@ -1914,8 +1517,10 @@ func (ss *Sim) OpenPat(dt *etable.Table, fname, name, desc string) {
 }
 
 func (ss *Sim) OpenPats() {
-	ss.OpenPat(ss.TrainAB, "env1_pats.txt", "Env 1", "Env 1 Training Patterns")
-	ss.OpenPat(ss.TrainAC, "env2_pats.txt", "Env 2", "Env 2 Training Patterns")
+	for i, en := range ss.Envs {
+		en.Pats = &etable.Table{}
+		ss.OpenPat(en.Pats, en.File, fmt.Sprintf("Env %d", i+1), fmt.Sprintf("Env %d (%v) Training Patterns", i+1, en.Name))
+	}
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
@@ -1957,7 +1562,7 @@ func (ss *Sim) WeightsFileName() string {
 
 // LogFileName returns default log file name
 func (ss *Sim) LogFileName(lognm string) string {
-	return ss.Net.Nm + "_" + ss.RunName() + "_" + lognm + ".csv"
+	return ss.Net.Nm + "_" + ss.RunName() + "_" + lognm + LogExt(ss.LogDelim)
 }
 
 //////////////////////////////////////////////
@@ -1985,6 +1590,7 @@ func (ss *Sim) LogTrnTrl(dt *etable.Table) {
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TrnTrlPlot.GoUpdate()
+	ss.TrnTrlFile.WriteRow(dt, row)
 }
 
 func (ss *Sim) ConfigTrnTrlLog(dt *etable.Table) {
@@ -2034,11 +1640,15 @@ func (ss *Sim) LogSlpCyc(dt *etable.Table, cyc int) {
 	dt.SetCellFloat("Cycle", cyc, float64(cyc))
 	dt.SetCellFloat("InhibFactor", cyc, float64(ss.InhibFactor))
 	dt.SetCellFloat("AvgLaySim", cyc, float64(ss.AvgLaySim))
+	dt.SetCellFloat("PlusThr", cyc, ss.SlpThr.Plus)
+	dt.SetCellFloat("MinusThr", cyc, ss.SlpThr.Minus)
+	dt.SetCellString("Cue", cyc, strings.Join(ss.TMRActive, " "))
 
 	for _, ly := range ss.Net.Layers {
 		lyc := ss.Net.LayerByName(ly.Name()).(leabra.LeabraLayer).AsLeabra()
 		dt.SetCellFloat(ly.Name()+" Sim", row, float64(lyc.Sim))
 	}
+	ss.WriteSlpCyc(dt, row, cyc)
 
 	ss.SlpCycPlot.GoUpdate()
 
@@ -2060,6 +1670,9 @@ func (ss *Sim) ConfigSlpCycLog(dt *etable.Table) {
 		{"Cycle", etensor.INT64, nil, nil},
 		{"InhibFactor", etensor.FLOAT64, nil, nil},
 		{"AvgLaySim", etensor.FLOAT64, nil, nil},
+		{"PlusThr", etensor.FLOAT64, nil, nil},
+		{"MinusThr", etensor.FLOAT64, nil, nil},
+		{"Cue", etensor.STRING, nil, nil},
 	}
 
 	for _, ly := range ss.Net.Layers {
@@ -2077,6 +1690,8 @@ func (ss *Sim) ConfigSlpCycPlot(plt *eplot.Plot2D, dt *etable.Table) *eplot.Plot
 	// order of params: on, fixMin, min, fixMax, max
 	plt.SetColParams("Cycle", true, true, 0, false, 0)
 	plt.SetColParams("AvgLaySim", true, true, 0, true, 1)
+	plt.SetColParams("PlusThr", true, true, 0, true, 1)
+	plt.SetColParams("MinusThr", true, true, 0, true, 1)
 	return plt
 }
 
@@ -2095,13 +1710,7 @@ func (ss *Sim) LogTrnEpc(dt *etable.Table) {
 	ss.EpcSSE = ss.SumSSE / nt
 	ss.DispAvgEpcSSE = ss.EpcSSE
 
-	if ss.TrainEnv.Table.Table == ss.TrainAB {
-		ss.TrainABSSE = ss.EpcSSE
-	}
-
-	if ss.TrainEnv.Table.Table == ss.TrainAC {
-		ss.TrainACSSE = ss.EpcSSE
-	}
+	ss.CurEnv().TrainSSE = ss.EpcSSE
 
 	ss.SumSSE = 0
 	ss.EpcAvgSSE = ss.SumAvgSSE / nt
@@ -2144,13 +1753,7 @@ func (ss *Sim) LogTrnEpc(dt *etable.Table) {
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TrnEpcPlot.GoUpdate()
-	if ss.TrnEpcFile != nil {
-		if ss.TrainEnv.Run.Cur == 0 && epc == 0 {
-			dt.WriteCSVHeaders(ss.TrnEpcFile, etable.Tab)
-		}
-		dt.WriteCSVRow(ss.TrnEpcFile, row, etable.Tab)
-	}
-
+	ss.TrnEpcFile.WriteRow(dt, row)
 }
 
 func (ss *Sim) ConfigTrnEpcLog(dt *etable.Table) {
@@ -2213,11 +1816,16 @@ func (ss *Sim) LogTstTrl(dt *etable.Table) {
 	//}
 
 	row := dt.Rows
-	if ss.TestNm == "AB" && trl == 0 { // reset at start
+	if ss.TestNm == ss.Envs[0].Name && trl == 0 { // reset at start
 		row = 0
 	}
 	dt.SetNumRows(row + 1)
 
+	cued := 0.0
+	if ss.TMRCued(ss.TestEnv.TrialName.Cur) {
+		cued = 1
+	}
+
 	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
 	dt.SetCellFloat("Epoch", row, float64(epc))
 
@@ -2225,6 +1833,7 @@ func (ss *Sim) LogTstTrl(dt *etable.Table) {
 
 	dt.SetCellFloat("Trial", row, float64(trl))
 	dt.SetCellString("TrialName", row, ss.TestEnv.TrialName.Cur)
+	dt.SetCellFloat("Cued", row, cued)
 	dt.SetCellFloat("Err", row, ss.TrlErr)
 	dt.SetCellFloat("SSE", row, ss.TrlSSE)
 	dt.SetCellFloat("AvgSSE", row, ss.TrlAvgSSE)
@@ -2245,6 +1854,7 @@ func (ss *Sim) LogTstTrl(dt *etable.Table) {
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TstTrlPlot.GoUpdate()
+	ss.TstTrlFile.WriteRow(dt, row)
 }
 
 func (ss *Sim) ConfigTstTrlLog(dt *etable.Table) {
@@ -2263,6 +1873,7 @@ func (ss *Sim) ConfigTstTrlLog(dt *etable.Table) {
 		{"TestNm", etensor.STRING, nil, nil},
 		{"Trial", etensor.INT64, nil, nil},
 		{"TrialName", etensor.STRING, nil, nil},
+		{"Cued", etensor.INT64, nil, nil},
 		{"Err", etensor.FLOAT64, nil, nil},
 		{"SSE", etensor.FLOAT64, nil, nil},
 		{"AvgSSE", etensor.FLOAT64, nil, nil},
@@ -2327,6 +1938,13 @@ func (ss *Sim) LogTstEpc(dt *etable.Table) {
 	dt.SetCellFloat("CosDiff", row, agg.Mean(tix, "CosDiff")[0])
 	dt.SetCellFloat("SlpTrls", row, float64(ss.SlpTrls))
 	dt.SetCellString("PostSlpStg", row, ss.SleepStage)
+	dt.SetCellFloat("SlpBlk", row, float64(ss.SleepCounter))
+	dt.SetCellString("Cond", row, ss.SlpCond.Name)
+	cuedCor, uncuedCor, cuedSSE, uncuedSSE := TMRTstStats(tix)
+	dt.SetCellFloat("CuedPctCor", row, cuedCor)
+	dt.SetCellFloat("UncuedPctCor", row, uncuedCor)
+	dt.SetCellFloat("CuedSSE", row, cuedSSE)
+	dt.SetCellFloat("UncuedSSE", row, uncuedSSE)
 
 	ss.DispAvgEpcSSE = agg.Sum(tix, "SSE")[0] / nt
 	ss.UpdateView("test")
@@ -2344,14 +1962,12 @@ func (ss *Sim) LogTstEpc(dt *etable.Table) {
 		for _, ts := range ss.TstStatNms {
 			dt.SetCellFloat(tst+" "+ts, row, ss.TstStats.CellFloat(ts, ri))
 		}
+		if en := ss.EnvByName(tst); en != nil {
+			en.TestSSE = ss.TstStats.CellFloat("SSE", ri)
+			en.TestCor = 1 - ss.TstStats.CellFloat("Err", ri)
+		}
 	}
 
-	// DS Added
-	ss.TestABSSE = ss.TstStats.CellFloat("SSE", 0)
-	ss.TestACSSE = ss.TstStats.CellFloat("SSE", 1)
-	ss.TestABCor = 1 - ss.TstStats.CellFloat("Err", 0) // This is the AB Error
-	ss.TestACCor = 1 - ss.TstStats.CellFloat("Err", 1) // This is the AC Error
-
 	trlix := etable.NewIdxView(trl)
 	trlix.Filter(func(et *etable.Table, row int) bool {
 		return et.CellFloat("SSE", row) > 0 // include error trials
@@ -2369,6 +1985,7 @@ func (ss *Sim) LogTstEpc(dt *etable.Table) {
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.TstEpcPlot.GoUpdate()
+	ss.TstEpcFile.WriteRow(dt, row)
 }
 
 func (ss *Sim) ConfigTstEpcLog(dt *etable.Table) {
@@ -2387,6 +2004,12 @@ func (ss *Sim) ConfigTstEpcLog(dt *etable.Table) {
 		{"CosDiff", etensor.FLOAT64, nil, nil},
 		{"SlpTrls", etensor.FLOAT64, nil, nil},
 		{"PostSlpStg", etensor.STRING, nil, nil},
+		{"SlpBlk", etensor.INT64, nil, nil},
+		{"Cond", etensor.STRING, nil, nil},
+		{"CuedPctCor", etensor.FLOAT64, nil, nil},
+		{"UncuedPctCor", etensor.FLOAT64, nil, nil},
+		{"CuedSSE", etensor.FLOAT64, nil, nil},
+		{"UncuedSSE", etensor.FLOAT64, nil, nil},
 	}
 	for _, tn := range ss.TstNms {
 		for _, ts := range ss.TstStatNms {
@@ -2408,8 +2031,9 @@ func (ss *Sim) ConfigTstEpcPlot(plt *eplot.Plot2D, dt *etable.Table) *eplot.Plot
 	plt.SetColParams("PctErr", eplot.Off, eplot.FixMin, 0, eplot.FixMax, 1) // default plot
 	plt.SetColParams("PctCor", eplot.Off, eplot.FixMin, 0, eplot.FixMax, 1) // default plot
 	plt.SetColParams("CosDiff", eplot.Off, eplot.FixMin, 0, eplot.FixMax, 1)
-	plt.SetColParams("AB SSE", eplot.On, eplot.FixMin, 0, eplot.FloatMax, 0)
-	plt.SetColParams("AC SSE", eplot.On, eplot.FixMin, 0, eplot.FloatMax, 0)
+	for _, tn := range ss.TstNms {
+		plt.SetColParams(tn+" SSE", eplot.On, eplot.FixMin, 0, eplot.FloatMax, 0)
+	}
 	return plt
 }
 
@@ -2469,49 +2093,57 @@ func (ss *Sim) ConfigTstCycPlot(plt *eplot.Plot2D, dt *etable.Table) *eplot.Plot
 //////////////////////////////////////////////
 //  RunLog
 
-// LogRun adds data from current run to the RunLog table.
+// LogRun adds data from current run to the RunLog table: one row per sleep
+// condition the protocol was run under, from its last test (Cond is empty if
+// the run did not sleep).
 func (ss *Sim) LogRun(dt *etable.Table) {
 	run := ss.TrainEnv.Run.Cur // this is NOT triggered by increment yet -- use Cur
-	row := dt.Rows
-	dt.SetNumRows(row + 1)
+	params := ss.RunName()     // includes tag
 
-	epclog := ss.TstEpcLog
-	epcix := etable.NewIdxView(epclog)
-	// compute mean over last N epochs for run level
-	nlast := 1
-	if nlast > epcix.Len()-1 {
-		nlast = epcix.Len() - 1
-	}
-	epcix.Idxs = epcix.Idxs[epcix.Len()-nlast:]
-
-	params := ss.RunName() // includes tag
-
-	dt.SetCellFloat("Run", row, float64(run))
-	dt.SetCellString("Params", row, params)
-	//dt.SetCellFloat("FirstZero", row, float64(ss.FirstZero)) // DS: Commente out to temporarily get rid of errors
-	dt.SetCellFloat("ShSSE", row, agg.Mean(epcix, "SSE")[0])
-	dt.SetCellFloat("AvgSSE", row, agg.Mean(epcix, "AvgSSE")[0])
-	dt.SetCellFloat("PctErr", row, agg.Mean(epcix, "PctErr")[0])
-	dt.SetCellFloat("PctCor", row, agg.Mean(epcix, "PctCor")[0])
-	dt.SetCellFloat("CosDiff", row, agg.Mean(epcix, "CosDiff")[0])
-	dt.SetCellFloat("SSE", row, agg.Mean(epcix, "SSE")[0])
-	dt.SetCellFloat("AvgSSE", row, agg.Mean(epcix, "AvgSSE")[0])
-	dt.SetCellFloat("PctErr", row, agg.Mean(epcix, "PctErr")[0])
-	dt.SetCellFloat("PctCor", row, agg.Mean(epcix, "PctCor")[0])
-	dt.SetCellFloat("CosDiff", row, agg.Mean(epcix, "CosDiff")[0])
+	conds := ss.SlpCondsRun
+	if len(conds) == 0 {
+		conds = []string{""}
+	}
+	for _, cond := range conds {
+		row := dt.Rows
+		dt.SetNumRows(row + 1)
 
-	runix := etable.NewIdxView(dt)
-	spl := split.GroupBy(runix, []string{"Params"})
-	for _, tn := range ss.TstNms {
-		nm := tn + " " + "Mem"
-		split.Desc(spl, nm)
+		epclog := ss.TstEpcLog
+		epcix := etable.NewIdxView(epclog)
+		epcix.Filter(func(et *etable.Table, r int) bool {
+			return et.CellString("Cond", r) == cond
+		})
+		// compute mean over last N epochs for run level
+		nlast := 1
+		if nlast > epcix.Len() {
+			nlast = epcix.Len()
+		}
+		epcix.Idxs = epcix.Idxs[epcix.Len()-nlast:]
+
+		dt.SetCellFloat("Run", row, float64(run))
+		dt.SetCellString("Params", row, params)
+		dt.SetCellString("Cond", row, cond)
+		dt.SetCellFloat("FirstZero", row, float64(ss.FirstZero))
+		dt.SetCellFloat("SSE", row, agg.Mean(epcix, "SSE")[0])
+		dt.SetCellFloat("AvgSSE", row, agg.Mean(epcix, "AvgSSE")[0])
+		dt.SetCellFloat("PctErr", row, agg.Mean(epcix, "PctErr")[0])
+		dt.SetCellFloat("PctCor", row, agg.Mean(epcix, "PctCor")[0])
+		dt.SetCellFloat("CosDiff", row, agg.Mean(epcix, "CosDiff")[0])
+		for _, tn := range ss.TstNms {
+			dt.SetCellFloat(tn+" PctCor", row, 1-agg.Mean(epcix, tn+" Err")[0])
+			dt.SetCellFloat(tn+" SSE", row, agg.Mean(epcix, tn+" SSE")[0])
+		}
+		ss.RunFile.WriteRow(dt, row)
 	}
+
+	runix := etable.NewIdxView(dt)
+	spl := split.GroupBy(runix, []string{"Params", "Cond"})
 	split.Desc(spl, "FirstZero")
-	ss.RunStats = spl.AggsToTable(false)
+	split.Desc(spl, "PctCor")
+	ss.RunStats = spl.AggsToTable(etable.AddAggName)
 
 	// note: essential to use Go version of update when called from another goroutine
 	ss.RunPlot.GoUpdate()
-
 }
 
 func (ss *Sim) ConfigRunLog(dt *etable.Table) {
@@ -2523,6 +2155,7 @@ func (ss *Sim) ConfigRunLog(dt *etable.Table) {
 	sch := etable.Schema{
 		{"Run", etensor.INT64, nil, nil},
 		{"Params", etensor.STRING, nil, nil},
+		{"Cond", etensor.STRING, nil, nil},
 		{"FirstZero", etensor.FLOAT64, nil, nil},
 		{"SSE", etensor.FLOAT64, nil, nil},
 		{"AvgSSE", etensor.FLOAT64, nil, nil},
@@ -2530,6 +2163,12 @@ func (ss *Sim) ConfigRunLog(dt *etable.Table) {
 		{"PctCor", etensor.FLOAT64, nil, nil},
 		{"CosDiff", etensor.FLOAT64, nil, nil},
 	}
+	for _, tn := range ss.TstNms {
+		sch = append(sch, etable.Schema{
+			{tn + " PctCor", etensor.FLOAT64, nil, nil},
+			{tn + " SSE", etensor.FLOAT64, nil, nil},
+		}...)
+	}
 
 	dt.SetFromSchema(sch, 0)
 }
@@ -2546,6 +2185,10 @@ func (ss *Sim) ConfigRunPlot(plt *eplot.Plot2D, dt *etable.Table) *eplot.Plot2D
 	plt.SetColParams("PctErr", false, true, 0, true, 1)
 	plt.SetColParams("PctCor", false, true, 0, true, 1)
 	plt.SetColParams("CosDiff", false, true, 0, true, 1)
+	for _, tn := range ss.TstNms {
+		plt.SetColParams(tn+" PctCor", false, true, 0, true, 1)
+		plt.SetColParams(tn+" SSE", false, true, 0, false, 0)
+	}
 
 	return plt
 }
@@ -2726,7 +2369,7 @@ func (ss *Sim) ConfigGui() *gi.Window {
 	}}, win.This(), func(recv, send ki.Ki, sig int64, data interface{}) {
 		if !ss.IsRunning {
 			ss.IsRunning = true
-			ss.StrucSleepTrial()
+			ss.StrucSleepTrial("StrucSleep")
 			ss.IsRunning = false
 			vp.SetNeedsFullRender()
 		}
@@ -2865,44 +2508,258 @@ func (ss *Sim) CmdArgs() {
 	var nogui bool
 	var saveEpcLog bool
 	var saveRunLog bool
+	var saveTstEpcLog bool
+	var saveTrnTrlLog bool
+	var saveTstTrlLog bool
+	var logFmt string
+	var paramsFile string
+	var dumpParams string
+	var report string
+	var rsaAt string
+	var rsaLays string
+	var saveWtChgLog bool
+	var saveSlpTrlLog bool
+	var snapWts string
+	var wtsDiff string
+	var wtsDiffTop int
+	var stability string
+	var stabActThr float64
+	var slpThr string
+	var kick string
+	var watchdog string
+	var downscale string
+	var slpRules string
+	var slpMask string
+	var tmr string
+	var protocol string
+	var slpConds string
+	var strucSleep string
+	var envs string
+	var saveSessLog bool
+	var saveKickLog bool
+	var saveDownscaleLog bool
+	var saveParamLog bool
+	var saveStrucSlpLog bool
+	flag.StringVar(&ss.Out.Root, "outdir", "output", "root directory for all output -- each batch of runs writes to its own subdirectory")
+	flag.StringVar(&ss.Out.BatchID, "batch", "", "batch ID naming the output subdirectory (default: the master random seed)")
 	flag.StringVar(&ss.ParamSet, "params", "", "ParamSet name to use -- must be valid name as listed in compiled-in params or loaded params")
 	flag.StringVar(&ss.Tag, "tag", "", "extra tag to add to file names saved from this run")
 	flag.IntVar(&ss.MaxRuns, "runs", 9, "number of runs to do (note that MaxEpcs is in paramset)")
 	flag.BoolVar(&ss.LogSetParams, "setparams", false, "if true, print a record of each parameter that is set")
 	flag.BoolVar(&ss.SaveWts, "wts", false, "if true, save final weights after each run")
 	flag.BoolVar(&saveEpcLog, "epclog", true, "if true, save train epoch log to file")
-	flag.BoolVar(&saveRunLog, "runlog", false, "if true, save run epoch log to file")
+	flag.BoolVar(&saveTstEpcLog, "tstepclog", true, "if true, save test epoch log to file")
+	flag.BoolVar(&saveRunLog, "runlog", false, "if true, save run log to file")
+	flag.BoolVar(&saveTrnTrlLog, "trntrllog", false, "if true, save train trial log to file")
+	flag.BoolVar(&saveTstTrlLog, "tsttrllog", false, "if true, save test trial log to file")
+	flag.BoolVar(&saveKickLog, "kicklog", false, "if true, save the sleep kick log to file")
+	flag.BoolVar(&saveDownscaleLog, "downscalelog", false, "if true, save the synaptic downscaling log to file")
+	flag.IntVar(&ss.SlpCycEvery, "slpcyclog", 0, "if > 0, save the sleep cycle log (stability, inhibition and thresholds by cycle) of each sleep block to its own file, every this many cycles (1 for all)")
+	flag.BoolVar(&saveParamLog, "paramlog", false, "if true, save the log of the parameters changed by each sleep block to file")
+	flag.BoolVar(&saveStrucSlpLog, "strucslplog", false, "if true, save the structured sleep trial log to file")
+	flag.BoolVar(&saveSessLog, "sesslog", false, "if true, save the session log (test results at the end of each session of the protocol) to file")
+	flag.BoolVar(&saveSlpTrlLog, "slptrllog", false, "if true, save the sleep learning trial log to file")
+	flag.BoolVar(&saveWtChgLog, "wtchglog", false, "if true, save the per-projection weight change log of each wake and sleep phase to file")
+	flag.StringVar(&logFmt, "logfmt", "tsv", "format of the log files: tsv or csv")
 	flag.BoolVar(&nogui, "nogui", true, "if not passing any other args and want to run nogui, use nogui")
+	flag.StringVar(&paramsFile, "paramsfile", "", "comma-separated list of JSON params.Sets files to merge over the compiled-in params (later files override earlier ones)")
+	flag.StringVar(&dumpParams, "dumpparams", "", "if set, write the effective layer and projection params (wake and sleep) to this file and exit without running")
+	flag.StringVar(&report, "report", "", "if set, write a statistical report of the sleep benefit across the runs of this batch output directory and exit without running")
+	flag.StringVar(&rsaAt, "rsa", "", "comma-separated list of protocol milestones at which to run representational similarity analysis: PreSleep, SWS, REM (after each block of that stage), PostSleep")
+	flag.StringVar(&rsaLays, "rsalays", "", "comma-separated list of layers compared by RSA (default: CTX,DG,CA3,pCA1,dCA1)")
+	flag.StringVar(&snapWts, "snapwts", "", "comma-separated list of protocol milestones at which to save a snapshot of the weights: PreSleep, SWS, REM (after each block of that stage), PostSleep")
+	flag.StringVar(&wtsDiff, "wtsdiff", "", "if set to two weight files <A>,<B>, write the weight changes from A to B and exit without running")
+	flag.IntVar(&wtsDiffTop, "wtsdifftop", 20, "number of most changed synapses listed by -wtsdiff")
+	flag.StringVar(&stability, "stability", "mean", "network stability metric that marks the sleep plus and minus phases: mean, wmean:<layer>*<weight>:..., min, cos or win:<N>:<metric>, either for all sleep stages or as a comma-separated list of <stage>=<metric> (stages: SWS, REM)")
+	flag.StringVar(&slpThr, "slpthr", "fixed", "sleep plus / minus phase thresholds on the network stability: fixed (hand-tuned), pctile[:<plus>:<minus>] (percentiles of the recent stability, default 0.9:0.25) or zscore[:<plus>:<minus>] (mean + z * SD of the recent stability, default 1:-1)")
+	flag.IntVar(&ss.SlpThr.Win, "slpthrwin", ss.SlpThr.Win, "number of recent sleep cycles whose stability sets the adaptive sleep thresholds")
+	flag.IntVar(&ss.SlpThr.Interval, "slpthrint", ss.SlpThr.Interval, "the adaptive sleep thresholds are recomputed every this many cycles")
+	flag.StringVar(&kick, "kick", "off", "attractor escape policy during sleep: off, or a comma-separated list of <key>=<value> -- triggers: lowsim=<stability>, silent=<total layer activity>, stuck=<cycles of the same decoded item>; when: warmup=<cycles> (1000), period=<cycles> (50), dur=<cycles> (5); action=randomize (default) or noise:<amp>; lays=<layer>:<layer>... (default all)")
+	flag.StringVar(&watchdog, "watchdog", "off", "NaN / runaway activity watchdog during sleep: off, on, or a comma-separated list of <key>=<value> -- every=<cycles> (10), silent=<total activity>:<cycles> (0.001:2000), sat=<mean activity>:<cycles> (0.95:2000), action=warn, kick or abort (warn), hist=<cycles of stability history dumped> (1000)")
+	flag.StringVar(&slpRules, "slprule", DefSlpRules, "sleep learning rule of each projection: a comma-separated list of [<selector>=]<rule>, the last that matches a projection applying -- selector: .<class>, #<name> or Prjn (all, default); rule: err (contrast of the plus and minus phase averages), hebb (plus phase averages) or mix:<hebbian fraction>, e.g. err,.PerCTXPrjn=mix:0.3")
+	flag.StringVar(&slpMask, "slpmask", DefSlpMask, "sleep-time learning mask: a comma-separated list of [<stage>:]<selector>=<setting>, the last that matches a projection applying -- stage: "+strings.Join(SlpMaskStages, ", ")+" (default all); selector: .<class>, #<name> or Prjn (all); setting: off, on or the learning rate (on); the projections none matches keep their wake learning")
+	flag.StringVar(&downscale, "downscale", "off", "synaptic downscaling during sleep: off, on, or a comma-separated list of <key>=<value> -- mode=mult (each weight loses rate of its value, default) or sub (rate of its projection's mean weight); rate=<fraction> (0.05); when=block (a step at the end of each sleep block, default) or cycle (a step every <every> sleep cycles); every=<cycles> (1000); prjns=<selector>:<selector>... (.<class>, #<name> or Prjn for all, default)")
+	flag.StringVar(&protocol, "protocol", DefProtocol, "comma-separated list of the steps run once all the environments have been learned: sleep[:<SWS / REM block pairs>] (5), struc[:<epochs>] (1 epoch of structured sleep, see -strucsleep), wake:<epochs> (further wake training) or test; sleep and test steps are logged in the session log, e.g. sleep:1,wake:5,sleep,wake:5,test")
+	flag.StringVar(&slpConds, "slpconds", DefSlpConds, "comma-separated list of the conditions the sleep steps of the protocol are run under, each from the weights once all the environments have been learned: sleep, quiet (time-matched quiet-wake control: no synaptic depression or sleep learning) or quiet-<mechanism>-... (only the given mechanisms off: syndep, learn, downscale), e.g. sleep,quiet,quiet-learn")
+	flag.StringVar(&strucSleep, "strucsleep", "", "structured sleep of the struc steps of the protocol: a comma-separated list of <key>=<value> -- src=env<N> (the Nth environment of -envs) or mixed (all the environments, default), plus=<cycles> (25), minus=<cycles> of oscillating inhibition (75)")
+	flag.StringVar(&envs, "envs", DefEnvs, "comma-separated list of the environments learned in sequence before sleep: <name>:<file>[:<key>=<value>...] -- crit=<max training and test SSE> (0), over=<consecutive test epochs at criterion> (1), hip=on (default) or off (hippocampus off while the environment is learned); the name prefixes its test log columns")
+	flag.StringVar(&tmr, "tmr", "", "if set, a targeted memory reactivation cue schedule file: tab-separated, with a header line and columns Item, Lay, Start, End and optionally Pat, Stage, Phase (up or down) and Gain")
+	flag.Float64Var(&stabActThr, "stabactthr", DefStabActThr, "layers with less total activity than this count as unstable in the per-layer stability metrics")
 	flag.Parse()
+	var err error
+	if ss.LogDelim, err = LogDelim(logFmt); err != nil {
+		log.Fatalln(err)
+	}
+	if ss.RSAAt, err = ParseMilestones(rsaAt, RSAMilestones); err != nil {
+		log.Fatalln("-rsa:", err)
+	}
+	if ss.SnapWtsAt, err = ParseMilestones(snapWts, SnapMilestones); err != nil {
+		log.Fatalln("-snapwts:", err)
+	}
+	ss.StabActThr = float32(stabActThr)
+	if err = ss.SetStability(stability); err != nil {
+		log.Fatalln("-stability:", err)
+	}
+	if err = ss.SlpThr.Set(slpThr); err != nil {
+		log.Fatalln("-slpthr:", err)
+	}
+	if ss.SlpThr.Win < 2 || ss.SlpThr.Interval < 1 {
+		log.Fatalln("-slpthrwin must be at least 2 and -slpthrint at least 1")
+	}
+	if err = ss.Kick.Set(kick); err != nil {
+		log.Fatalln("-kick:", err)
+	}
+	if err = ss.Watchdog.Set(watchdog); err != nil {
+		log.Fatalln("-watchdog:", err)
+	}
+	if err = ss.Downscale.Set(downscale); err != nil {
+		log.Fatalln("-downscale:", err)
+	}
+	if ss.SlpRules, err = ParseSlpRules(slpRules); err != nil {
+		log.Fatalln("-slprule:", err)
+	}
+	if err = ss.SlpMask.Set(slpMask); err != nil {
+		log.Fatalln("-slpmask:", err)
+	}
+	if ss.Protocol, err = ParseProtocol(protocol); err != nil {
+		log.Fatalln("-protocol:", err)
+	}
+	if ss.SlpConds, err = ParseSlpConds(slpConds); err != nil {
+		log.Fatalln("-slpconds:", err)
+	}
+	if err = ss.StrucSlp.Set(strucSleep); err != nil {
+		log.Fatalln("-strucsleep:", err)
+	}
+	if err = ss.SetEnvs(envs); err != nil {
+		log.Fatalln("-envs:", err)
+	}
+	if err = ss.StrucSlp.Check(len(ss.Envs)); err != nil {
+		log.Fatalln("-strucsleep:", err)
+	}
+	if rsaLays != "" {
+		ss.RSALays = strings.Split(rsaLays, ",")
+	}
+	if report != "" {
+		if err := ss.Report(report); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if wtsDiff != "" {
+		fnms := strings.Split(wtsDiff, ",")
+		if len(fnms) != 2 {
+			log.Fatalln("-wtsdiff: must be two weight files: <A>,<B>")
+		}
+		outs, err := WtsDiff(fnms[0], fnms[1], WtsDiffOut(fnms[0], fnms[1]), wtsDiffTop)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, fnm := range outs {
+			fmt.Printf("Saved weights diff to: %v\n", fnm)
+		}
+		return
+	}
+	if paramsFile != "" {
+		if err := ss.OpenParamsFiles(paramsFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	ss.ReconfigEnvs()
+	if err := ss.CheckEnvs(); err != nil {
+		log.Fatalln("-envs:", err)
+	}
 	ss.Init()
+	for _, lnm := range append(ss.RSALays, ss.Kick.Lays...) {
+		if _, err := ss.Net.LayerByNameTry(lnm); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := ss.SlpRules.Check(ss.Net); err != nil {
+		log.Fatalln("-slprule:", err)
+	}
+	if err := ss.SlpMask.Check(ss.Net); err != nil {
+		log.Fatalln("-slpmask:", err)
+	}
+	if ss.Downscale.On {
+		if err := ss.Downscale.Check(ss.Net); err != nil {
+			log.Fatalln("-downscale:", err)
+		}
+	}
+	if tmr != "" {
+		cues, err := OpenTMR(tmr)
+		if err == nil {
+			err = ss.SetTMR(cues)
+		}
+		if err != nil {
+			log.Fatalln("-tmr:", err)
+		}
+	}
 
 	if ss.ParamSet != "" {
 		fmt.Printf("Using ParamSet: %s\n", ss.ParamSet)
 	}
 
-	if saveEpcLog {
-		var err error
-		fnm := ss.LogFileName("epc" + strconv.Itoa(int(ss.RndSeed)))
-		ss.TrnEpcFile, err = os.Create(fnm)
-		if err != nil {
-			log.Println(err)
-			ss.TrnEpcFile = nil
-		} else {
-			fmt.Printf("Saving epoch log to: %v\n", fnm)
-			defer ss.TrnEpcFile.Close()
+	if dumpParams != "" {
+		if err := ss.DumpParams(dumpParams); err != nil {
+			log.Fatalln(err)
 		}
+		fmt.Printf("Saved effective params to: %v\n", dumpParams)
+		return
+	}
+
+	ss.StartManifest()
+
+	if saveEpcLog {
+		ss.TrnEpcFile = ss.OpenLogFile("epc", "train epoch")
+		defer ss.TrnEpcFile.Close()
+	}
+	if saveTstEpcLog {
+		ss.TstEpcFile = ss.OpenLogFile("tstepc", "test epoch")
+		defer ss.TstEpcFile.Close()
 	}
 	if saveRunLog {
-		var err error
-		fnm := ss.LogFileName("run")
-		ss.RunFile, err = os.Create(fnm)
-		if err != nil {
-			log.Println(err)
-			ss.RunFile = nil
-		} else {
-			fmt.Printf("Saving run log to: %v\n", fnm)
-			defer ss.RunFile.Close()
-		}
+		ss.RunFile = ss.OpenLogFile("run", "run")
+		defer ss.RunFile.Close()
+	}
+	if saveTrnTrlLog {
+		ss.TrnTrlFile = ss.OpenLogFile("trntrl", "train trial")
+		defer ss.TrnTrlFile.Close()
+	}
+	if saveTstTrlLog {
+		ss.TstTrlFile = ss.OpenLogFile("tsttrl", "test trial")
+		defer ss.TstTrlFile.Close()
+	}
+	if saveKickLog {
+		ss.KickFile = ss.OpenLogFile("kick", "sleep kick")
+		defer ss.KickFile.Close()
+	}
+	if saveDownscaleLog {
+		ss.DownscaleFile = ss.OpenLogFile("downscale", "downscaling")
+		defer ss.DownscaleFile.Close()
+	}
+	if saveParamLog {
+		ss.ParamFile = ss.OpenLogFile("param", "parameter scope")
+		defer ss.ParamFile.Close()
+	}
+	if saveStrucSlpLog {
+		ss.StrucSlpFile = ss.OpenLogFile("strucslp", "structured sleep")
+		defer ss.StrucSlpFile.Close()
+	}
+	if saveSessLog {
+		ss.SessFile = ss.OpenLogFile("sess", "session")
+		defer ss.SessFile.Close()
+	}
+	if saveSlpTrlLog {
+		ss.SlpTrlFile = ss.OpenLogFile("slptrl", "sleep learning trial")
+		defer ss.SlpTrlFile.Close()
+	}
+	if saveWtChgLog {
+		ss.WtChgFile = ss.OpenLogFile("wtchg", "weight change")
+		defer ss.WtChgFile.Close()
+	}
+	if len(ss.RSAAt) > 0 {
+		ss.RSAFile = ss.OpenLogFile("rsa", "RSA")
+		defer ss.RSAFile.Close()
 	}
 	if ss.SaveWts {
 		fmt.Printf("Saving final weights per run\n")
@@ -0,0 +1,140 @@
+// Sleep conditions (-slpconds): the sleep steps of the protocol can be run
+// under a time-matched quiet-wake control, with some or all of the
+// sleep-specific mechanisms off, from the same trained weights as sleep.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// DefSlpConds is the default list of sleep conditions: sleep only
+const DefSlpConds = "sleep"
+
+// SlpCondMechs are the sleep mechanisms that a quiet-wake condition turns
+// off -- the sleep of this model has no inhibitory oscillation (SleepBlocks
+// turns InhibOscil off)
+var SlpCondMechs = []string{"syndep", "learn", "downscale"}
+
+// SlpCond is a condition under which the sleep steps of the protocol are
+// run: the same blocks of cycles, run and logged the same way, with some of
+// the sleep mechanisms off
+type SlpCond struct {
+	Name      string `desc:"label of the condition in the logs: sleep, quiet (all mechanisms off) or quiet-<mechanism>-... (only those off)"`
+	SynDep    bool   `desc:"synaptic depression"`
+	Learn     bool   `desc:"sleep learning (SlpDWt) at the end of each sleep trial -- without it, sleep trials are still detected and logged"`
+	Downscale bool   `desc:"synaptic downscaling (-downscale), if on"`
+}
+
+// SleepCond is the sleep condition, with all mechanisms on
+var SleepCond = SlpCond{Name: "sleep", SynDep: true, Learn: true, Downscale: true}
+
+// ParseSlpConds parses a comma-separated list of sleep conditions, each of
+// which is sleep, quiet (a quiet-wake control with all of SlpCondMechs off)
+// or quiet-<mechanism>-... (only the given mechanisms off), e.g.
+// sleep,quiet,quiet-learn
+func ParseSlpConds(spec string) ([]SlpCond, error) {
+	var conds []SlpCond
+	seen := map[string]bool{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		args := strings.Split(s, "-")
+		var sc SlpCond
+		switch {
+		case s == "sleep":
+			sc = SleepCond
+		case s == "quiet":
+			sc = SlpCond{Name: "quiet"}
+		case args[0] == "quiet":
+			sc = SleepCond
+			off := map[string]bool{}
+			for _, m := range args[1:] {
+				switch m {
+				case "syndep":
+					sc.SynDep = false
+				case "learn":
+					sc.Learn = false
+				case "downscale":
+					sc.Downscale = false
+				default:
+					return nil, fmt.Errorf("sleep condition %v: unknown mechanism %v (must be one of %v)", s, m, strings.Join(SlpCondMechs, ", "))
+				}
+				off[m] = true
+			}
+			sc.Name = "quiet"
+			if len(off) < len(SlpCondMechs) {
+				for _, m := range SlpCondMechs {
+					if off[m] {
+						sc.Name += "-" + m
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid sleep condition: %v (must be sleep, quiet or quiet-<mechanism>-...)", s)
+		}
+		if seen[sc.Name] {
+			return nil, fmt.Errorf("sleep condition %v listed twice", sc.Name)
+		}
+		seen[sc.Name] = true
+		conds = append(conds, sc)
+	}
+	if len(conds) == 0 {
+		return nil, fmt.Errorf("no sleep conditions")
+	}
+	return conds, nil
+}
+
+// CondLabel returns lbl prefixed with the name of the current sleep
+// condition, unless it is sleep, for the labels (log blocks, milestones,
+// weight change phases, output files) that would otherwise be the same for
+// all the conditions of a run
+func (ss *Sim) CondLabel(lbl string) string {
+	if ss.SlpCond.Name == "" || ss.SlpCond.Name == SleepCond.Name {
+		return lbl
+	}
+	return ss.SlpCond.Name + "_" + lbl
+}
+
+// RunSlpConds runs the protocol under each of the SlpConds in turn, each
+// starting from the weights, training environment state and sleep block
+// counters the network has once all the environments have been learned.  A
+// condition only turns mechanisms off: those already off (SynDep, SlpDWt,
+// Downscale) stay off.  The network is left in the state reached under the last
+// condition.
+func (ss *Sim) RunSlpConds() {
+	var wts bytes.Buffer
+	if len(ss.SlpConds) > 1 {
+		ss.Net.WriteWtsJSON(&wts)
+	}
+	trn := ss.TrainEnv
+	trn.Order = append([]int(nil), ss.TrainEnv.Order...)
+	blk, sws, rem, stage := ss.SleepCounter, ss.SWSCounter, ss.REMCounter, ss.SleepStage
+	envTsts := ss.EnvTests()
+	syndep, dwt, ds := ss.SynDep, ss.SlpDWt, ss.Downscale.On
+
+	ss.SlpCondsRun = nil
+	for ci, sc := range ss.SlpConds {
+		if ci > 0 {
+			if err := ss.Net.ReadWtsJSON(bytes.NewReader(wts.Bytes())); err != nil {
+				log.Println(err)
+			}
+			ss.TrainEnv = trn
+			ss.TrainEnv.Order = append([]int(nil), trn.Order...)
+			ss.SleepCounter, ss.SWSCounter, ss.REMCounter, ss.SleepStage = blk, sws, rem, stage
+			ss.SetEnvTests(envTsts)
+		}
+		ss.SlpCond = sc
+		ss.SynDep, ss.SlpDWt = syndep && sc.SynDep, dwt && sc.Learn
+		ss.Downscale.On = ds && sc.Downscale
+		ss.RunProtocol()
+		ss.SlpCondsRun = append(ss.SlpCondsRun, sc.Name)
+	}
+	ss.SynDep, ss.SlpDWt, ss.Downscale.On = syndep, dwt, ds
+	ss.SlpCond = SlpCond{}
+}
@@ -0,0 +1,179 @@
+// Sleep learning rules (-slprule): the rule applied by each projection at the
+// end of each sleep trial, chosen by params selector.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emer/emergent/params"
+	"github.com/goki/mat32"
+	"github.com/schapirolab/leabra-sleep/hip"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// DefSlpRules is the default -slprule: error-driven sleep learning in all
+// the projections
+const DefSlpRules = "err"
+
+// SlpRule is the sleep learning rule of the projections matched by a params
+// selector
+type SlpRule struct {
+	Sel  string  `desc:"projections of the rule, as a params selector: .<class>, #<name> or Prjn (all)"`
+	Rule string  `desc:"err: contrast of the plus and minus phase averages; hebb: plus phase averages alone; mix: Hebb of hebb and 1-Hebb of err"`
+	Hebb float32 `desc:"hebbian fraction of the mix rule"`
+}
+
+// Spec returns the rule as in -slprule, without the selector
+func (sr *SlpRule) Spec() string {
+	if sr.Rule == "mix" {
+		return "mix:" + strconv.FormatFloat(float64(sr.Hebb), 'g', -1, 32)
+	}
+	return sr.Rule
+}
+
+// SlpRules are the sleep learning rules of the projections: the last rule
+// that matches a projection applies, and err applies to those none matches
+type SlpRules []SlpRule
+
+// ParseSlpRules parses the -slprule spec: a comma-separated list of
+// [<selector>=]<rule>, where rule is err, hebb or mix:<hebbian fraction>,
+// and the selector defaults to Prjn (all the projections)
+func ParseSlpRules(spec string) (SlpRules, error) {
+	var rs SlpRules
+	for _, ent := range strings.Split(spec, ",") {
+		ent = strings.TrimSpace(ent)
+		if ent == "" {
+			continue
+		}
+		sr := SlpRule{Sel: "Prjn", Rule: ent}
+		if eq := strings.Index(ent, "="); eq >= 0 {
+			sr.Sel, sr.Rule = strings.TrimSpace(ent[:eq]), strings.TrimSpace(ent[eq+1:])
+		}
+		switch {
+		case sr.Sel == "":
+			return nil, fmt.Errorf("sleep learning rule %v: empty selector", ent)
+		case sr.Rule == "err" || sr.Rule == "hebb":
+		case strings.HasPrefix(sr.Rule, "mix:"):
+			hebb, err := strconv.ParseFloat(sr.Rule[len("mix:"):], 32)
+			if err != nil {
+				return nil, fmt.Errorf("sleep learning rule %v: %v", ent, err)
+			}
+			if hebb < 0 || hebb > 1 {
+				return nil, fmt.Errorf("sleep learning rule %v: hebbian fraction must be between 0 and 1", ent)
+			}
+			sr.Rule, sr.Hebb = "mix", float32(hebb)
+		default:
+			return nil, fmt.Errorf("sleep learning rule %v: unknown rule %v (must be err, hebb or mix:<hebbian fraction>)", ent, sr.Rule)
+		}
+		rs = append(rs, sr)
+	}
+	return rs, nil
+}
+
+// For returns the rule of projection pj
+func (rs SlpRules) For(pj *leabra.Prjn) *SlpRule {
+	for i := len(rs) - 1; i >= 0; i-- {
+		if params.SelMatch(rs[i].Sel, pj.Name(), pj.Class(), "Prjn", "Prjn") {
+			return &rs[i]
+		}
+	}
+	return &SlpRule{Sel: "Prjn", Rule: "err"}
+}
+
+// Check returns an error if any of the rules selects no projection of net,
+// or if a projection of the mix rule does not have CHL learning on
+func (rs SlpRules) Check(net *leabra.Network) error {
+	for i := range rs {
+		found := false
+		for _, ly := range net.Layers {
+			for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+				pj := p.(leabra.LeabraPrjn).AsLeabra()
+				found = found || params.SelMatch(rs[i].Sel, pj.Name(), pj.Class(), "Prjn", "Prjn")
+			}
+		}
+		if !found {
+			return fmt.Errorf("sleep learning rule %v: no projection matches %v", rs[i].Sel+"="+rs[i].Spec(), rs[i].Sel)
+		}
+	}
+	for _, ly := range net.Layers {
+		for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+			pj, ok := p.(*hip.CHLPrjn)
+			if !ok {
+				continue
+			}
+			if sr := rs.For(&pj.Prjn); sr.Rule == "mix" && !pj.CHL.On {
+				return fmt.Errorf("sleep learning rule %v: projection %v does not have CHL learning on", sr.Sel+"="+sr.Spec(), pj.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// ApplySlpRules applies the sleep learning rule of each projection of the network
+// that is not off, at the end of a sleep trial
+func (ss *Sim) ApplySlpRules() {
+	for _, ly := range ss.Net.Layers {
+		for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+			if p.IsOff() {
+				continue
+			}
+			pj := p.(*hip.CHLPrjn)
+			sr := ss.SlpRules.For(&pj.Prjn)
+			if sr.Rule == "mix" {
+				SlpDWtMix(pj, sr.Hebb)
+			} else {
+				pj.SlpDWt(sr.Rule)
+			}
+		}
+	}
+}
+
+// SlpDWtMix is CHLPrjn.SlpDWt with a mix of the hebbian and error-driven
+// rules: the error is hebb * plus + (1 - hebb) * (plus - minus), soft
+// bounded, normalized and with momentum as in the pure rules.  CHL learning
+// must be on (see SlpRules.Check).
+func SlpDWtMix(pj *hip.CHLPrjn, hebb float32) {
+	if !pj.Learn.Learn {
+		return
+	}
+	slay := pj.Send.(leabra.LeabraLayer).AsLeabra()
+	for si := range slay.Neurons {
+		nc := int(pj.SConN[si])
+		st := int(pj.SConIdxSt[si])
+		syns := pj.Syns[st : st+nc]
+		for ci := range syns {
+			sy := &syns[ci]
+			err := hebb*sy.ActPAvg + (1-hebb)*(sy.ActPAvg-sy.ActMAvg)
+			if err > 0 {
+				err *= (1 - sy.LWt)
+			} else {
+				err *= sy.LWt
+			}
+			dwt := err
+			norm := float32(1)
+			if pj.Learn.Norm.On {
+				norm = pj.Learn.Norm.NormFmAbsDWt(&sy.Norm, mat32.Abs(dwt))
+			}
+			if pj.Learn.Momentum.On {
+				dwt = norm * pj.Learn.Momentum.MomentFmDWt(&sy.Moment, dwt)
+			} else {
+				dwt *= norm
+			}
+			sy.DWt += pj.Learn.Lrate * dwt
+		}
+		if pj.Learn.Norm.On {
+			maxNorm := float32(0)
+			for ci := range syns {
+				if syns[ci].Norm > maxNorm {
+					maxNorm = syns[ci].Norm
+				}
+			}
+			for ci := range syns {
+				syns[ci].Norm = maxNorm
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSlpThreshSet(t *testing.T) {
+	tests := []struct {
+		spec        string
+		mode        string // empty if the spec is rejected
+		plus, minus float64
+	}{
+		{"fixed", "fixed", 0, 0},
+		{" fixed ", "fixed", 0, 0},
+		{"pctile", "pctile", 0.9, 0.25},
+		{"pctile:0.8:0.1", "pctile", 0.8, 0.1},
+		{"pctile:1:0", "pctile", 1, 0},
+		{"zscore", "zscore", 1, -1},
+		{"zscore:2:0.5", "zscore", 2, 0.5},
+		{"", "", 0, 0},
+		{"adaptive", "", 0, 0},
+		{"fixed:0.9:0.8", "", 0, 0},
+		{"pctile:0.8", "", 0, 0},
+		{"pctile:0.8:0.1:0", "", 0, 0},
+		{"pctile:x:0.1", "", 0, 0},
+		{"pctile:0.8:y", "", 0, 0},
+		{"pctile:0.2:0.8", "", 0, 0},
+		{"pctile:0.5:0.5", "", 0, 0},
+		{"pctile:1.5:0.1", "", 0, 0},
+		{"pctile:0.8:-0.1", "", 0, 0},
+		{"zscore:-1:1", "", 0, 0},
+	}
+	for _, tt := range tests {
+		var st SlpThresh
+		st.Defaults()
+		err := st.Set(tt.spec)
+		switch {
+		case tt.mode == "" && err == nil:
+			t.Errorf("Set(%q) = %+v, want an error", tt.spec, st)
+		case tt.mode != "" && err != nil:
+			t.Errorf("Set(%q): %v", tt.spec, err)
+		case tt.mode != "" && (st.Mode != tt.mode || st.PlusArg != tt.plus || st.MinusArg != tt.minus):
+			t.Errorf("Set(%q) = %v %v:%v, want %v %v:%v", tt.spec, st.Mode, st.PlusArg, st.MinusArg, tt.mode, tt.plus, tt.minus)
+		}
+	}
+}
+
+func TestSlpThreshUpdate(t *testing.T) {
+	sd := math.Sqrt(2.5) // of 1 .. 5
+	tests := []struct {
+		spec        string
+		plus, minus float64
+	}{
+		{"fixed", 0.9, 0.8},
+		{"pctile:0.75:0.25", 4, 2},
+		{"zscore:1:-0.5", 3 + sd, 3 - 0.5*sd},
+	}
+	for _, tt := range tests {
+		var st SlpThresh
+		st.Defaults()
+		if err := st.Set(tt.spec); err != nil {
+			t.Fatal(err)
+		}
+		st.Win, st.Interval = 5, 2
+		st.Reset(0.9, 0.8)
+		for i := 1; i <= 5; i++ {
+			if st.Adaptive() && !math.IsInf(st.Plus, 1) {
+				t.Errorf("%v: thresholds %v before Win cycles, want +Inf", tt.spec, st.Plus)
+			}
+			st.Update(float64(i))
+		}
+		if math.Abs(st.Plus-tt.plus) > 1e-12 || math.Abs(st.Minus-tt.minus) > 1e-12 {
+			t.Errorf("%v: thresholds %v, %v, want %v, %v", tt.spec, st.Plus, st.Minus, tt.plus, tt.minus)
+		}
+		st.Update(6) // not due: Interval
+		if math.Abs(st.Plus-tt.plus) > 1e-12 {
+			t.Errorf("%v: thresholds updated off Interval: %v", tt.spec, st.Plus)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	srt := []float64{1, 2, 3, 4}
+	tests := []struct {
+		vals    []float64
+		p, want float64
+	}{
+		{srt, 0, 1},
+		{srt, 0.5, 2.5},
+		{srt, 0.9, 3.7},
+		{srt, 1, 4},
+		{[]float64{7}, 0.3, 7},
+	}
+	for _, tt := range tests {
+		if got := Percentile(tt.vals, tt.p); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("Percentile(%v, %v) = %v, want %v", tt.vals, tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Sleep learning trial log: one row per plus / minus contrast of sleep, and
+// per plus phase that was aborted before its minus phase.
+
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+)
+
+// SlpTrl records one sleep learning trial as it happens: a plus phase, while
+// the network is stable, followed by a minus phase, at the end of which the
+// weights are updated
+type SlpTrl struct {
+	StartCyc   int     `desc:"sleep cycle at which the plus phase started"`
+	OnsetInhib float64 `desc:"inhibition oscillation factor at the start of the plus phase -- 1 if not oscillating"`
+	PlusThr    float64 `desc:"plus phase threshold at the start of the plus phase"`
+	MinusThr   float64 `desc:"minus phase threshold at the start of the plus phase"`
+	PlusDur    int     `desc:"number of cycles of the plus phase"`
+	MinusDur   int     `desc:"number of cycles of the minus phase"`
+	PlusSim    float64 `desc:"sum of AvgLaySim over the plus phase"`
+	MinusSim   float64 `desc:"sum of AvgLaySim over the minus phase"`
+	Item       string  `desc:"item decoded from the plus phase activity -- empty if there is no decoder"`
+	AbsDWt     float64 `desc:"total |dWt| of the weight update at the end of the minus phase, over the projections that learn -- 0 if no sleep learning rule ran (SlpDWt off)"`
+}
+
+// Start starts recording a new trial, whose plus phase starts at cycle cyc,
+// with inhibition factor inhib and the given plus / minus thresholds
+func (st *SlpTrl) Start(cyc int, inhib, plusThr, minusThr float64) {
+	*st = SlpTrl{StartCyc: cyc, OnsetInhib: inhib, PlusThr: plusThr, MinusThr: minusThr}
+}
+
+// AddPlus adds a cycle with network similarity sim to the plus phase
+func (st *SlpTrl) AddPlus(sim float64) {
+	st.PlusDur++
+	st.PlusSim += sim
+}
+
+// AddMinus adds a cycle with network similarity sim to the minus phase
+func (st *SlpTrl) AddMinus(sim float64) {
+	st.MinusDur++
+	st.MinusSim += sim
+}
+
+// OnsetInhib returns the current inhibition oscillation factor, 1 if the
+// inhibition is not oscillating
+func (ss *Sim) OnsetInhib() float64 {
+	if !ss.InhibOscil {
+		return 1
+	}
+	return ss.InhibFactor
+}
+
+// LogSlpTrl adds the current SlpTrl to the SlpTrlLog: block labels the
+// sleep block, and aborted is true if the plus phase ended without a minus
+// phase, so that no learning took place
+func (ss *Sim) LogSlpTrl(dt *etable.Table, block string, aborted bool) {
+	st := &ss.SlpTrl
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	mean := func(sum float64, n int) float64 {
+		if n == 0 {
+			return math.NaN()
+		}
+		return sum / float64(n)
+	}
+	abort := 0.0
+	if aborted {
+		abort = 1
+	}
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Block", row, block)
+	dt.SetCellFloat("Aborted", row, abort)
+	dt.SetCellFloat("StartCyc", row, float64(st.StartCyc))
+	dt.SetCellFloat("PlusDur", row, float64(st.PlusDur))
+	dt.SetCellFloat("MinusDur", row, float64(st.MinusDur))
+	dt.SetCellFloat("PlusSim", row, mean(st.PlusSim, st.PlusDur))
+	dt.SetCellFloat("MinusSim", row, mean(st.MinusSim, st.MinusDur))
+	dt.SetCellFloat("OnsetInhib", row, st.OnsetInhib)
+	dt.SetCellFloat("PlusThr", row, st.PlusThr)
+	dt.SetCellFloat("MinusThr", row, st.MinusThr)
+	dt.SetCellString("Item", row, st.Item)
+	dt.SetCellFloat("AbsDWt", row, st.AbsDWt)
+
+	ss.SlpTrlFile.WriteRow(dt, row)
+}
+
+// ConfigSlpTrlLog configures the SlpTrlLog: one row per sleep learning trial
+func (ss *Sim) ConfigSlpTrlLog(dt *etable.Table) {
+	dt.SetMetaData("name", "SlpTrlLog")
+	dt.SetMetaData("desc", "Record of each sleep learning trial (plus / minus contrast)")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	dt.SetFromSchema(etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Block", etensor.STRING, nil, nil},
+		{"Aborted", etensor.INT64, nil, nil},
+		{"StartCyc", etensor.INT64, nil, nil},
+		{"PlusDur", etensor.INT64, nil, nil},
+		{"MinusDur", etensor.INT64, nil, nil},
+		{"PlusSim", etensor.FLOAT64, nil, nil},
+		{"MinusSim", etensor.FLOAT64, nil, nil},
+		{"OnsetInhib", etensor.FLOAT64, nil, nil},
+		{"PlusThr", etensor.FLOAT64, nil, nil},
+		{"MinusThr", etensor.FLOAT64, nil, nil},
+		{"Item", etensor.STRING, nil, nil},
+		{"AbsDWt", etensor.FLOAT64, nil, nil},
+	}, 0)
+}
@@ -0,0 +1,37 @@
+// Weight snapshots at protocol milestones (-snapwts), for comparison with
+// the weights diff tool (-wtsdiff).
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/goki/gi/gi"
+)
+
+// SnapMilestones are the protocol milestones at which the weights can be
+// saved (-snapwts): right before sleep, after each SWS or REM sleep block,
+// and at the end of sleep
+var SnapMilestones = []string{"PreSleep", "SWS", "REM", "PostSleep"}
+
+// SnapWts saves the network weights to the run's weights directory, as
+// snap_<label>.wts.gz, if mile is one of SnapWtsAt
+func (ss *Sim) SnapWts(mile, label string) {
+	if !HasMilestone(ss.SnapWtsAt, mile) {
+		return
+	}
+	fnm := ss.Out.RunFile(ss.TrainEnv.Run.Cur, "weights", "snap_"+label+".wts.gz")
+	if err := os.MkdirAll(filepath.Dir(fnm), os.ModePerm); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := ss.Net.SaveWtsJSON(gi.FileName(fnm)); err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Printf("Saved %v weights to: %v\n", label, fnm)
+	ss.Manifest.AddOutput(fnm)
+}
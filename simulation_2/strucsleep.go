@@ -0,0 +1,322 @@
+// Structured sleep (-strucsleep, protocol step struc): the training patterns
+// of a source environment are presented one alpha cycle each, and the sleep
+// learning rule contrasts the settled plus phase with a minus phase under
+// oscillating inhibition.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/emer/emergent/emer"
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// StrucMixed is the pattern source of structured sleep that has the
+// training patterns of all the environments (ss.Pats) -- the others are
+// env<N>, the patterns of the Nth of the Envs
+const StrucMixed = "mixed"
+
+// StrucOscLays are the layers whose inhibition oscillates during the minus
+// phase of structured sleep
+var StrucOscLays = []string{"DG", "CA3", "CTX", "Output"}
+
+// StrucSleep are the parameters of structured sleep
+type StrucSleep struct {
+	Src   string `desc:"source of the patterns presented -- env<N> (the Nth of the Envs) or StrucMixed"`
+	Plus  int    `desc:"number of cycles of the plus phase, settling on the presented pattern"`
+	Minus int    `desc:"number of cycles of the minus phase, with oscillating inhibition (OscillStartCyc to OscillStopCyc of the minus phase)"`
+}
+
+// Defaults sets the default parameters: all the environments, 25 plus and
+// 75 minus cycles
+func (sp *StrucSleep) Defaults() {
+	*sp = StrucSleep{Src: StrucMixed, Plus: 25, Minus: 75}
+}
+
+// Set sets the parameters from spec: a comma-separated list of
+// <key>=<value> with keys src (env<N> or StrucMixed), plus and minus -- keys
+// that are not given keep their defaults
+func (sp *StrucSleep) Set(spec string) error {
+	sp.Defaults()
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil
+	}
+	for _, kv := range strings.Split(spec, ",") {
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			return fmt.Errorf("structured sleep %v: must be <key>=<value>: %v", spec, kv)
+		}
+		key, val := strings.TrimSpace(kv[:eq]), strings.TrimSpace(kv[eq+1:])
+		var err error
+		switch key {
+		case "src":
+			sp.Src = val
+		case "plus":
+			sp.Plus, err = strconv.Atoi(val)
+		case "minus":
+			sp.Minus, err = strconv.Atoi(val)
+		default:
+			return fmt.Errorf("structured sleep %v: unknown key %v (must be src, plus or minus)", spec, key)
+		}
+		if err != nil {
+			return fmt.Errorf("structured sleep %v: %v", spec, err)
+		}
+	}
+	if sp.Src != StrucMixed && sp.SrcEnv() < 0 {
+		return fmt.Errorf("structured sleep %v: src must be env<N> (N from 1) or %v", spec, StrucMixed)
+	}
+	if sp.Plus < 1 || sp.Minus < 1 {
+		return fmt.Errorf("structured sleep %v: plus and minus must be at least 1", spec)
+	}
+	return nil
+}
+
+// SrcEnv returns the index in the Envs of an env<N> Src, -1 if it is not one
+func (sp *StrucSleep) SrcEnv() int {
+	if !strings.HasPrefix(sp.Src, "env") {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(sp.Src, "env"))
+	if err != nil || n < 1 {
+		return -1
+	}
+	return n - 1
+}
+
+// Check returns an error if the Src is the patterns of an environment beyond
+// the nenvs environments
+func (sp *StrucSleep) Check(nenvs int) error {
+	if sp.SrcEnv() >= nenvs {
+		return fmt.Errorf("src %v: there are only %d environments", sp.Src, nenvs)
+	}
+	return nil
+}
+
+// ConfigPats configures ss.Pats, the mixed source of structured sleep: the
+// training patterns of each of the Envs in turn
+func (ss *Sim) ConfigPats() {
+	ss.Pats = ss.Envs[0].Pats.Clone()
+	for _, en := range ss.Envs[1:] {
+		ss.Pats.AppendRows(en.Pats)
+	}
+	ss.Pats.SetMetaData("name", "Mixed")
+	ss.Pats.SetMetaData("desc", "Training Patterns of all the Environments")
+}
+
+// StrucPats returns the patterns of the StrucSlp.Src source
+func (ss *Sim) StrucPats() *etable.IdxView {
+	if ei := ss.StrucSlp.SrcEnv(); ei >= 0 && ei < len(ss.Envs) {
+		return etable.NewIdxView(ss.Envs[ei].Pats)
+	}
+	return etable.NewIdxView(ss.Pats)
+}
+
+// StrucSleepEpochs runs n epochs of structured sleep through the StrucSlp.Src
+// patterns, in a new random order each epoch, as a step of the protocol.
+// Returns the number of trials, which count as sleep learning trials.
+func (ss *Sim) StrucSleepEpochs(n int) int {
+	block := ss.CondLabel(fmt.Sprintf("Struc-%d", ss.Sess))
+	ss.SleepEnv.Table = ss.StrucPats()
+	ss.SleepEnv.Init(ss.TrainEnv.Run.Cur)
+	scope := ss.StartParamScope(block)
+	defer ss.EndParamScope(scope)
+	ss.SleepParams("Struc", block)
+	ss.StartWtChg(block)
+	trls := n * ss.SleepEnv.Trial.Max
+	for i := 0; i < trls; i++ {
+		ss.StrucSleepTrial(block)
+	}
+	ss.Net.WtFmDWt() // the update of the last trial
+	ss.EndWtChg()
+	ss.TotSlpTrls += trls
+	return trls
+}
+
+// StrucSleepTrial runs the next structured sleep trial of the SleepEnv, and
+// logs it in the StrucSlpLog with block
+func (ss *Sim) StrucSleepTrial(block string) {
+	if ss.NeedsNewRun {
+		ss.NewRun()
+	}
+
+	ss.SleepEnv.Step() // the Env encapsulates and manages all counter state
+
+	ss.ApplyInputs(&ss.SleepEnv)
+	dwt, pmdiff := ss.StrucSleepAlphaCyc(true) // train
+	ss.LogStrucSlp(ss.StrucSlpLog, block, pmdiff, dwt)
+}
+
+// StrucSleepEpoch runs one epoch of structured sleep, from the GUI
+func (ss *Sim) StrucSleepEpoch() {
+	ss.StopNow = false
+	ss.StrucSleepEpochs(1)
+	ss.StartWtChg("Wake")
+	ss.Stopped()
+}
+
+// StrucSleepAlphaCyc runs one alpha cycle of structured sleep on the applied
+// pattern: StrucSlp.Plus cycles of plus phase, then StrucSlp.Minus cycles of
+// minus phase, with the inhibition of the StrucOscLays oscillating from cycle
+// OscillStartCyc to OscillStopCyc of the minus phase.  If train is true, the
+// sleep learning rule is applied at the end, unless SlpDWt is off.  Returns
+// the total |dWt| of the update and the mean |plus - minus| activation of the
+// layers that are not clamped.
+func (ss *Sim) StrucSleepAlphaCyc(train bool) (dwt, pmdiff float64) {
+	// ss.Win.PollEvents() // this can be used instead of running in a separate goroutine
+	viewUpdt := ss.StrucSleepUpdt
+	if !train {
+		viewUpdt = ss.TestUpdt
+	}
+
+	// update prior weight changes at start, so any DWt values remain visible at end
+	if train {
+		ss.Net.WtFmDWt()
+	}
+	// Setting Output layer to type "output"
+	out := ss.Net.LayerByName("Output").(leabra.LeabraLayer).AsLeabra()
+	out.SetType(emer.Compare)
+
+	// Storing current Gi values
+	oscLys := make([]*leabra.Layer, len(StrucOscLays))
+	gis := make([]float32, len(StrucOscLays))
+	for i, lnm := range StrucOscLays {
+		oscLys[i] = ss.Net.LayerByName(lnm).(*leabra.Layer)
+		gis[i] = oscLys[i].Inhib.Layer.Gi
+	}
+
+	sp := &ss.StrucSlp
+	var pacts []float32 // plus phase activations of the layers that are not clamped
+	ss.Net.AlphaCycInit(train)
+	ss.Time.AlphaCycStart()
+	for cyc := 0; cyc < sp.Plus+sp.Minus; cyc++ {
+		ss.InhibFactor = 1
+		if m := cyc - sp.Plus; m >= 0 && m >= ss.OscillStartCyc && m <= ss.OscillStopCyc {
+			ss.InhibFactor = ss.OscillAmplitude*math.Sin(2*3.14/ss.OscillPeriod*float64(m)) + ss.OscillMidline
+		}
+		for i, ly := range oscLys {
+			ly.Inhib.Layer.Gi = gis[i] * float32(ss.InhibFactor)
+		}
+
+		ss.Net.Cycle(&ss.Time, false) // Placed after Gi change
+
+		// We only need the settled plus phase cycle but need all minus phase cycles
+		for _, ly := range ss.Net.Layers {
+			if cyc == sp.Plus-1 || cyc == sp.Plus {
+				ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(true)
+			} else if cyc > sp.Plus {
+				ly.(leabra.LeabraLayer).AsLeabra().RunSumUpdt(false)
+			}
+		}
+		if cyc == sp.Plus-1 {
+			for _, ly := range ss.Net.Layers {
+				ly.(leabra.LeabraLayer).AsLeabra().CalcActP(1)
+			}
+			pacts = ss.StrucActs(pacts)
+		}
+		ss.Time.CycleInc()
+		if ss.ViewOn {
+			switch {
+			case viewUpdt == leabra.Cycle:
+				ss.UpdateView("strucsleep")
+			case viewUpdt == leabra.FastSpike:
+				if (cyc+1)%10 == 0 {
+					ss.UpdateView("strucsleep")
+				}
+			case viewUpdt <= leabra.Phase:
+				if cyc == sp.Plus-1 || cyc == sp.Plus+sp.Minus-1 {
+					ss.UpdateView("strucsleep")
+				}
+			}
+		}
+	}
+	for _, ly := range ss.Net.Layers {
+		ly.(leabra.LeabraLayer).AsLeabra().CalcActM(sp.Minus)
+	}
+	macts := ss.StrucActs(nil)
+	for i := range macts {
+		pmdiff += math.Abs(float64(pacts[i] - macts[i]))
+	}
+	if len(macts) > 0 {
+		pmdiff /= float64(len(macts))
+	}
+
+	if train && ss.SlpDWt {
+		ss.ApplySlpRules()
+		dwt = ss.WtChg.AccumDWt(ss.Net)
+	}
+	out.SetType(emer.Target)
+	if ss.ViewOn && viewUpdt == leabra.AlphaCycle {
+		ss.UpdateView("strucsleep")
+	}
+	// Resetting Gis after sleep
+	for i, ly := range oscLys {
+		ly.Inhib.Layer.Gi = gis[i]
+	}
+	return
+}
+
+// StrucActs appends to acts, and returns, the activations of the neurons of
+// the layers that are neither clamped (Input) nor off
+func (ss *Sim) StrucActs(acts []float32) []float32 {
+	acts = acts[:0]
+	for _, lyc := range ss.Net.Layers {
+		ly := lyc.(leabra.LeabraLayer).AsLeabra()
+		if ly.IsOff() || ly.Type() == emer.Input {
+			continue
+		}
+		for ni := range ly.Neurons {
+			acts = append(acts, ly.Neurons[ni].Act)
+		}
+	}
+	return acts
+}
+
+// LogStrucSlp adds the structured sleep trial that just ran in block, with
+// plus / minus difference pmdiff and weight update dwt, to the StrucSlpLog
+func (ss *Sim) LogStrucSlp(dt *etable.Table, block string, pmdiff, dwt float64) {
+	row := dt.Rows
+	dt.SetNumRows(row + 1)
+
+	dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+	dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+	dt.SetCellString("Block", row, block)
+	dt.SetCellFloat("StrucEpc", row, float64(ss.SleepEnv.Epoch.Cur))
+	dt.SetCellFloat("Trial", row, float64(ss.SleepEnv.Trial.Cur))
+	dt.SetCellString("Item", row, ss.SleepEnv.TrialName.Cur)
+	dt.SetCellString("Src", row, ss.StrucSlp.Src)
+	dt.SetCellFloat("Plus", row, float64(ss.StrucSlp.Plus))
+	dt.SetCellFloat("Minus", row, float64(ss.StrucSlp.Minus))
+	dt.SetCellFloat("PMDiff", row, pmdiff)
+	dt.SetCellFloat("AbsDWt", row, dwt)
+
+	ss.StrucSlpFile.WriteRow(dt, row)
+}
+
+// ConfigStrucSlpLog configures the StrucSlpLog: one row per structured sleep trial
+func (ss *Sim) ConfigStrucSlpLog(dt *etable.Table) {
+	dt.SetMetaData("name", "StrucSlpLog")
+	dt.SetMetaData("desc", "Record of each structured sleep trial")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	dt.SetFromSchema(etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Block", etensor.STRING, nil, nil},
+		{"StrucEpc", etensor.INT64, nil, nil},
+		{"Trial", etensor.INT64, nil, nil},
+		{"Item", etensor.STRING, nil, nil},
+		{"Src", etensor.STRING, nil, nil},
+		{"Plus", etensor.INT64, nil, nil},
+		{"Minus", etensor.INT64, nil, nil},
+		{"PMDiff", etensor.FLOAT64, nil, nil},
+		{"AbsDWt", etensor.FLOAT64, nil, nil},
+	}, 0)
+}
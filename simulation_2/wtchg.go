@@ -0,0 +1,164 @@
+// Per-projection accounting of weight changes, separately for each wake and
+// sleep phase of a run, so that we can see which projections actually learn
+// during which phase.
+
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/emer/etable/etable"
+	"github.com/emer/etable/etensor"
+	"github.com/schapirolab/leabra-sleep/leabra"
+)
+
+// PrjnWtChg is the weight change accounting of one projection over a phase
+type PrjnWtChg struct {
+	Prjn        string  `desc:"projection name: <send>To<recv>"`
+	SumAbsDWt   float64 `desc:"sum of |dWt| over all the weight updates of the phase"`
+	NetDWt      float64 `desc:"sum of dWt over all the weight updates of the phase -- the net direction of change"`
+	NUpdt       int     `desc:"number of weight updates with any nonzero dWt"`
+	WtNormStart float64 `desc:"L2 norm of the weights at the start of the phase"`
+	WtNormEnd   float64 `desc:"L2 norm of the weights at the end of the phase"`
+}
+
+// WtChgAcct accumulates the weight changes of every projection of a network
+// over one phase (e.g., Wake or Sleep).  The dWt of each learning update is
+// accumulated right after it is computed (DWt or SlpDWt), before WtFmDWt
+// applies it to the weights.
+type WtChgAcct struct {
+	Phase string      `desc:"current phase -- empty if not accumulating"`
+	Prjns []PrjnWtChg `desc:"accounting of each projection, in order of the sending projections of each layer"`
+}
+
+// Start starts accumulating phase, for all the projections of net
+func (wc *WtChgAcct) Start(net *leabra.Network, phase string) {
+	wc.Phase = phase
+	wc.Prjns = wc.Prjns[:0]
+	for _, ly := range net.Layers {
+		for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+			pj := p.(leabra.LeabraPrjn).AsLeabra()
+			wc.Prjns = append(wc.Prjns, PrjnWtChg{Prjn: pj.Name(), WtNormStart: WtNorm(pj)})
+		}
+	}
+}
+
+// AccumDWt accumulates the current dWt of every projection that is not off
+// and learns, and returns the total |dWt| of the update.  Must be called once
+// per weight update, after the dWt has been computed.  Projections that do
+// not learn are skipped, as WtFmDWt leaves their dWt from the last update
+// they learned on.
+func (wc *WtChgAcct) AccumDWt(net *leabra.Network) float64 {
+	tot := 0.0
+	pi := 0
+	for _, ly := range net.Layers {
+		for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+			idx := pi
+			pi++
+			pj := p.(leabra.LeabraPrjn).AsLeabra()
+			if pj.IsOff() || !pj.Learn.Learn {
+				continue
+			}
+			sabs, sum := 0.0, 0.0
+			for si := range pj.Syns {
+				dwt := float64(pj.Syns[si].DWt)
+				sabs += math.Abs(dwt)
+				sum += dwt
+			}
+			tot += sabs
+			if sabs == 0 || wc.Phase == "" {
+				continue
+			}
+			pw := &wc.Prjns[idx]
+			pw.SumAbsDWt += sabs
+			pw.NetDWt += sum
+			pw.NUpdt++
+		}
+	}
+	return tot
+}
+
+// End ends the current phase, recording the final weight norms.  Returns
+// false if no phase was being accumulated.
+func (wc *WtChgAcct) End(net *leabra.Network) bool {
+	if wc.Phase == "" {
+		return false
+	}
+	pi := 0
+	for _, ly := range net.Layers {
+		for _, p := range ly.(leabra.LeabraLayer).AsLeabra().SndPrjns {
+			wc.Prjns[pi].WtNormEnd = WtNorm(p.(leabra.LeabraPrjn).AsLeabra())
+			pi++
+		}
+	}
+	return true
+}
+
+// WtNorm returns the L2 norm of the weights of projection pj
+func WtNorm(pj *leabra.Prjn) float64 {
+	ss := 0.0
+	for si := range pj.Syns {
+		wt := float64(pj.Syns[si].Wt)
+		ss += wt * wt
+	}
+	return math.Sqrt(ss)
+}
+
+// StartWtChg ends (and logs) the current weight change phase, if any, and
+// starts accumulating phase
+func (ss *Sim) StartWtChg(phase string) {
+	ss.EndWtChg()
+	ss.WtChg.Start(ss.Net, phase)
+}
+
+// EndWtChg ends the current weight change phase, if any, and logs it to the
+// WtChgLog
+func (ss *Sim) EndWtChg() {
+	if !ss.WtChg.End(ss.Net) {
+		return
+	}
+	ss.LogWtChg(ss.WtChgLog)
+	ss.WtChg.Phase = ""
+}
+
+// LogWtChg adds one row per projection to the WtChgLog, from the accounting
+// of the phase that just ended
+func (ss *Sim) LogWtChg(dt *etable.Table) {
+	for _, pw := range ss.WtChg.Prjns {
+		row := dt.Rows
+		dt.SetNumRows(row + 1)
+		dt.SetCellFloat("Run", row, float64(ss.TrainEnv.Run.Cur))
+		dt.SetCellFloat("Epoch", row, float64(ss.TrainEnv.Epoch.Prv))
+		dt.SetCellString("Phase", row, ss.WtChg.Phase)
+		dt.SetCellString("Prjn", row, pw.Prjn)
+		dt.SetCellFloat("SumAbsDWt", row, pw.SumAbsDWt)
+		dt.SetCellFloat("NetDWt", row, pw.NetDWt)
+		dt.SetCellFloat("NUpdt", row, float64(pw.NUpdt))
+		dt.SetCellFloat("WtNormStart", row, pw.WtNormStart)
+		dt.SetCellFloat("WtNormEnd", row, pw.WtNormEnd)
+		dt.SetCellFloat("WtNormChg", row, pw.WtNormEnd-pw.WtNormStart)
+		ss.WtChgFile.WriteRow(dt, row)
+	}
+}
+
+// ConfigWtChgLog configures the WtChgLog: one row per run, phase and projection
+func (ss *Sim) ConfigWtChgLog(dt *etable.Table) {
+	dt.SetMetaData("name", "WtChgLog")
+	dt.SetMetaData("desc", "Weight changes of each projection over each wake and sleep phase")
+	dt.SetMetaData("read-only", "true")
+	dt.SetMetaData("precision", strconv.Itoa(LogPrec))
+
+	dt.SetFromSchema(etable.Schema{
+		{"Run", etensor.INT64, nil, nil},
+		{"Epoch", etensor.INT64, nil, nil},
+		{"Phase", etensor.STRING, nil, nil},
+		{"Prjn", etensor.STRING, nil, nil},
+		{"SumAbsDWt", etensor.FLOAT64, nil, nil},
+		{"NetDWt", etensor.FLOAT64, nil, nil},
+		{"NUpdt", etensor.INT64, nil, nil},
+		{"WtNormStart", etensor.FLOAT64, nil, nil},
+		{"WtNormEnd", etensor.FLOAT64, nil, nil},
+		{"WtNormChg", etensor.FLOAT64, nil, nil},
+	}, 0)
+}